@@ -0,0 +1,186 @@
+/*
+Copyright (c) 2015, Alberto Corsín Lafuente
+All rights reserved.
+
+Redistribution and use in source and binary forms, with or without
+modification, are permitted provided that the following conditions are met:
+
+1. Redistributions of source code must retain the above copyright notice,
+   this list of conditions and the following disclaimer.
+
+2. Redistributions in binary form must reproduce the above copyright notice,
+   this list of conditions and the following disclaimer in the documentation
+   and/or other materials provided with the distribution.
+
+3. Neither the name of the copyright holder nor the names of its contributors
+   may be used to endorse or promote products derived from this software
+   without specific prior written permission.
+
+THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND
+ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED
+WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE
+FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER
+CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY,
+OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+*/
+package auth
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"github.com/acorsinl/casimiro/models"
+	"github.com/acorsinl/casimiro/problem"
+	"github.com/acorsinl/casimiro/system"
+	"net/http"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// APIKeyHeader carries the API key of a request
+	APIKeyHeader = "X-Api-Key"
+	// APIKeyPrefix starts every key, so leaked keys are easy to spot
+	APIKeyPrefix = "cas_"
+	// apiKeyShown is how many characters of a key are kept as its prefix
+	apiKeyShown = 12
+)
+
+// scopePattern matches the scopes of API keys: * for everything, or the
+// first segment of the paths allowed followed by :read (GET and HEAD) or
+// :write (every method, write includes read)
+var scopePattern = regexp.MustCompile(`^(\*|[a-z0-9_-]+:(read|write))$`)
+
+var (
+	scopeResourcesMutex sync.RWMutex
+	// scopeResources are the resources scopes can name, nil for any
+	scopeResources map[string]bool
+)
+
+// NewAPIKey returns a random API key, with the prefix shown to its owner
+// and the hash to store
+func NewAPIKey() (key, prefix, hash string, err error) {
+	secret := make([]byte, 32)
+	if _, err = rand.Read(secret); err != nil {
+		return "", "", "", err
+	}
+	key = APIKeyPrefix + base64.RawURLEncoding.EncodeToString(secret)
+	return key, key[:apiKeyShown], HashAPIKey(key), nil
+}
+
+// HashAPIKey returns the hash API keys are stored and looked up by
+func HashAPIKey(key string) string {
+	return hashToken(key)
+}
+
+// hashToken returns the hash of a random token. Tokens have enough entropy
+// for a plain SHA-256.
+func hashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// SetScopeResources sets the resources, first segments of the paths
+// served, that API key scopes can name. Any resource is accepted until it
+// is called.
+func SetScopeResources(resources []string) {
+	scopeResourcesMutex.Lock()
+	defer scopeResourcesMutex.Unlock()
+	scopeResources = make(map[string]bool)
+	for _, resource := range resources {
+		scopeResources[resource] = true
+	}
+}
+
+// ScopeResources returns the resources set with SetScopeResources, sorted
+func ScopeResources() []string {
+	scopeResourcesMutex.RLock()
+	defer scopeResourcesMutex.RUnlock()
+	var resources []string
+	for resource := range scopeResources {
+		resources = append(resources, resource)
+	}
+	sort.Strings(resources)
+	return resources
+}
+
+// ValidScope tells whether scope can be given to an API key: * or the read
+// or write scope of one of the resources served
+func ValidScope(scope string) bool {
+	if !scopePattern.MatchString(scope) {
+		return false
+	}
+	scopeResourcesMutex.RLock()
+	defer scopeResourcesMutex.RUnlock()
+	return scope == "*" || scopeResources == nil || scopeResources[strings.SplitN(scope, ":", 2)[0]]
+}
+
+// RequiredScope returns the scope an API key needs to make r
+func RequiredScope(r *http.Request) string {
+	resource := strings.SplitN(strings.TrimPrefix(r.URL.Path, "/"), "/", 2)[0]
+	if r.Method == http.MethodGet || r.Method == http.MethodHead {
+		return resource + ":read"
+	}
+	return resource + ":write"
+}
+
+// HasScope tells whether scopes grant required
+func HasScope(scopes []string, required string) bool {
+	for _, scope := range scopes {
+		if scope == "*" || scope == required || (strings.HasSuffix(required, ":read") && scope == strings.TrimSuffix(required, "read")+"write") {
+			return true
+		}
+	}
+	return false
+}
+
+// APIKeys authenticates the requests carrying an API key in APIKeyHeader as
+// its owner, when its scopes allow them. Requests without a key are
+// authenticated by Fallback.
+type APIKeys struct {
+	Keys     models.APIKeyStore
+	Fallback Authenticator
+}
+
+func (a *APIKeys) Challenge() string {
+	if challenger, ok := a.Fallback.(Challenger); ok {
+		return challenger.Challenge()
+	}
+	return ""
+}
+
+func (a *APIKeys) Authenticate(r *http.Request) (string, error) {
+	value := r.Header.Get(APIKeyHeader)
+	if value == "" {
+		return a.Fallback.Authenticate(r)
+	}
+
+	key, err := a.Keys.GetAPIKeyByHash(HashAPIKey(value))
+	if errors.Is(err, models.ErrNotFound) {
+		return "", problem.Unauthorized("Invalid API key")
+	}
+	if err != nil {
+		return "", err
+	}
+	if !key.Active(time.Now()) {
+		return "", problem.Unauthorized("The API key has expired or was revoked")
+	}
+
+	// Keys could otherwise give themselves more scopes
+	if strings.HasPrefix(r.URL.Path, system.APIKeysUrl) {
+		return "", problem.Forbidden("API keys can't be managed with an API key")
+	}
+	if scope := RequiredScope(r); !HasScope(key.Scopes, scope) {
+		return "", problem.Forbidden("The API key doesn't have the " + scope + " scope")
+	}
+	return key.UserId, nil
+}
@@ -0,0 +1,186 @@
+/*
+Copyright (c) 2015, Alberto Corsín Lafuente
+All rights reserved.
+
+Redistribution and use in source and binary forms, with or without
+modification, are permitted provided that the following conditions are met:
+
+1. Redistributions of source code must retain the above copyright notice,
+   this list of conditions and the following disclaimer.
+
+2. Redistributions in binary form must reproduce the above copyright notice,
+   this list of conditions and the following disclaimer in the documentation
+   and/or other materials provided with the distribution.
+
+3. Neither the name of the copyright holder nor the names of its contributors
+   may be used to endorse or promote products derived from this software
+   without specific prior written permission.
+
+THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND
+ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED
+WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE
+FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER
+CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY,
+OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+*/
+package auth
+
+import (
+	"github.com/acorsinl/casimiro/models"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// userAuthenticator authenticates every request as its user
+type userAuthenticator string
+
+func (u userAuthenticator) Authenticate(r *http.Request) (string, error) {
+	return string(u), nil
+}
+
+func TestNewAPIKey(t *testing.T) {
+	key, prefix, hash, err := NewAPIKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(key, APIKeyPrefix) || prefix != key[:apiKeyShown] {
+		t.Errorf("unexpected key %q with prefix %q", key, prefix)
+	}
+	if hash != HashAPIKey(key) || len(hash) != 64 {
+		t.Errorf("unexpected hash %q", hash)
+	}
+
+	other, _, _, _ := NewAPIKey()
+	if other == key {
+		t.Error("expected a different key every time")
+	}
+}
+
+func TestHashAPIKey(t *testing.T) {
+	// SHA-256 of "abc"
+	if hash := HashAPIKey("abc"); hash != "ba7816bf8f01cfea414140de5dae2223b00361a396177a9cb410ff61f20015ad" {
+		t.Errorf("unexpected hash %q", hash)
+	}
+}
+
+func TestValidScope(t *testing.T) {
+	defer func() { scopeResources = nil }()
+
+	tests := []struct {
+		scope      string
+		valid      bool
+		validKnown bool
+	}{
+		{"*", true, true},
+		{"resources:read", true, true},
+		{"resources:write", true, true},
+		{"widgets:read", true, false},
+		{"resources", false, false},
+		{"resources:delete", false, false},
+		{"Resources:read", false, false},
+		{"", false, false},
+	}
+	for _, test := range tests {
+		if valid := ValidScope(test.scope); valid != test.valid {
+			t.Errorf("%q: expected %v, got %v", test.scope, test.valid, valid)
+		}
+	}
+
+	SetScopeResources([]string{"users", "resources"})
+	if resources := ScopeResources(); strings.Join(resources, ",") != "resources,users" {
+		t.Errorf("unexpected resources %v", resources)
+	}
+	for _, test := range tests {
+		if valid := ValidScope(test.scope); valid != test.validKnown {
+			t.Errorf("%q with known resources: expected %v, got %v", test.scope, test.validKnown, valid)
+		}
+	}
+}
+
+func TestHasScope(t *testing.T) {
+	tests := []struct {
+		method  string
+		path    string
+		scopes  []string
+		allowed bool
+	}{
+		{"GET", "/resources", []string{"resources:read"}, true},
+		{"HEAD", "/resources/r1", []string{"resources:read"}, true},
+		{"GET", "/resources/r1", []string{"resources:write"}, true},
+		{"POST", "/resources", []string{"resources:read"}, false},
+		{"DELETE", "/resources/r1", []string{"resources:write"}, true},
+		{"GET", "/users", []string{"resources:write"}, false},
+		{"PATCH", "/users/u1", []string{"resources:read", "*"}, true},
+		{"GET", "/resources", nil, false},
+	}
+	for _, test := range tests {
+		r := httptest.NewRequest(test.method, test.path, nil)
+		if allowed := HasScope(test.scopes, RequiredScope(r)); allowed != test.allowed {
+			t.Errorf("%s %s with %v: expected %v, got %v", test.method, test.path, test.scopes, test.allowed, allowed)
+		}
+	}
+}
+
+func TestAPIKeysAuthenticate(t *testing.T) {
+	store := models.NewMemoryModel()
+	a := &APIKeys{Keys: store, Fallback: userAuthenticator("fallback")}
+
+	past := time.Now().Add(-time.Hour)
+	keys := map[string]*models.APIKey{
+		"cas_read":    {Id: "k1", UserId: "alice", Scopes: []string{"resources:read"}},
+		"cas_all":     {Id: "k2", UserId: "alice", Scopes: []string{"*"}},
+		"cas_revoked": {Id: "k3", UserId: "alice", Scopes: []string{"*"}, RevokedAt: &past},
+		"cas_expired": {Id: "k4", UserId: "alice", Scopes: []string{"*"}, ExpiresAt: &past},
+	}
+	for secret, key := range keys {
+		key.Hash = HashAPIKey(secret)
+		if err := store.InsertAPIKey(key); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	tests := []struct {
+		name   string
+		method string
+		path   string
+		key    string
+		user   string
+		status int
+	}{
+		{"no key", "GET", "/resources", "", "fallback", http.StatusOK},
+		{"read", "GET", "/resources", "cas_read", "alice", http.StatusOK},
+		{"read writing", "POST", "/resources", "cas_read", "", http.StatusForbidden},
+		{"everything", "DELETE", "/resources/r1", "cas_all", "alice", http.StatusOK},
+		{"unknown", "GET", "/resources", "cas_unknown", "", http.StatusUnauthorized},
+		{"revoked", "GET", "/resources", "cas_revoked", "", http.StatusUnauthorized},
+		{"expired", "GET", "/resources", "cas_expired", "", http.StatusUnauthorized},
+		{"managing keys", "GET", "/apikeys", "cas_all", "", http.StatusForbidden},
+	}
+	for _, test := range tests {
+		r := httptest.NewRequest(test.method, test.path, nil)
+		if test.key != "" {
+			r.Header.Set(APIKeyHeader, test.key)
+		}
+		userId, err := a.Authenticate(r)
+		if status := loginStatus(err); status != test.status || userId != test.user {
+			t.Errorf("%s: expected %q %d, got %q %d %v", test.name, test.user, test.status, userId, status, err)
+		}
+	}
+
+	// Revoking a key takes effect at once
+	if err := store.RevokeAPIKey("alice", "k1", time.Now()); err != nil {
+		t.Fatal(err)
+	}
+	r := httptest.NewRequest("GET", "/resources", nil)
+	r.Header.Set(APIKeyHeader, "cas_read")
+	if _, err := a.Authenticate(r); loginStatus(err) != http.StatusUnauthorized {
+		t.Errorf("expected 401 with a revoked key, got %v", err)
+	}
+}
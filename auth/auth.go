@@ -0,0 +1,147 @@
+/*
+Copyright (c) 2015, Alberto Corsín Lafuente
+All rights reserved.
+
+Redistribution and use in source and binary forms, with or without
+modification, are permitted provided that the following conditions are met:
+
+1. Redistributions of source code must retain the above copyright notice,
+   this list of conditions and the following disclaimer.
+
+2. Redistributions in binary form must reproduce the above copyright notice,
+   this list of conditions and the following disclaimer in the documentation
+   and/or other materials provided with the distribution.
+
+3. Neither the name of the copyright holder nor the names of its contributors
+   may be used to endorse or promote products derived from this software
+   without specific prior written permission.
+
+THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND
+ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED
+WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE
+FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER
+CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY,
+OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+*/
+package auth
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"github.com/acorsinl/casimiro/problem"
+	"github.com/acorsinl/casimiro/system"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Authenticator tells who is making a request
+type Authenticator interface {
+	// Authenticate returns the id of the user making r. Requests that can't
+	// be authenticated get a problem, usually Unauthorized.
+	Authenticate(r *http.Request) (string, error)
+}
+
+// ErrUnauthenticated is returned when a request doesn't tell its user
+var ErrUnauthenticated = problem.Unauthorized("The user of the request is missing")
+
+// Challenger is implemented by authenticators that tell clients how to
+// authenticate in the WWW-Authenticate header of 401 responses
+type Challenger interface {
+	Challenge() string
+}
+
+// Handler serves the requests authenticated by authenticator with handler,
+// the user id is available to it with system.UserId. OPTIONS requests
+// aren't authenticated so browsers can make their preflight requests.
+func Handler(authenticator Authenticator, handler http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodOptions {
+			handler.ServeHTTP(w, r)
+			return
+		}
+
+		userId, err := authenticator.Authenticate(r)
+		if err != nil {
+			if challenger, ok := authenticator.(Challenger); ok && challenger.Challenge() != "" && problem.From(err).Status == http.StatusUnauthorized {
+				w.Header().Set("WWW-Authenticate", challenger.Challenge())
+			}
+			problem.Write(w, r, err)
+			return
+		}
+		handler.ServeHTTP(w, system.WithUserId(r, userId))
+	})
+}
+
+// SignatureTolerance is how old the timestamp of a user header signature
+// can be, or how far in the future
+const SignatureTolerance = 5 * time.Minute
+
+// Header trusts the user id given by the upper layer in system.UserHeader.
+// When Proxies or Secret are set the header is only trusted on requests
+// coming from one of Proxies or signed with Secret, the zero value trusts
+// every request.
+//
+// Signatures are sent in system.UserSignatureHeader as
+//
+//	t=<unix time>,v1=<hex HMAC-SHA256 of "<unix time>.<user id>">
+type Header struct {
+	Proxies Proxies
+	Secret  []byte
+}
+
+func (h *Header) Authenticate(r *http.Request) (string, error) {
+	userId := strings.TrimSpace(r.Header.Get(system.UserHeader))
+	if userId == "" {
+		return "", ErrUnauthenticated
+	}
+	if len(h.Proxies) == 0 && len(h.Secret) == 0 {
+		return userId, nil
+	}
+	if h.Proxies.Contains(r.RemoteAddr) {
+		return userId, nil
+	}
+	if len(h.Secret) > 0 && h.validSignature(userId, r.Header.Get(system.UserSignatureHeader), time.Now()) {
+		return userId, nil
+	}
+	return "", problem.Unauthorized("Requests must be made through the proxy")
+}
+
+// Sign returns the signature of userId at time t for a Header with secret
+func Sign(secret []byte, userId string, t time.Time) string {
+	timestamp := strconv.FormatInt(t.Unix(), 10)
+	return "t=" + timestamp + ",v1=" + hex.EncodeToString(signature(secret, timestamp, userId))
+}
+
+func (h *Header) validSignature(userId, header string, now time.Time) bool {
+	var timestamp, value string
+	for _, part := range strings.Split(header, ",") {
+		if strings.HasPrefix(part, "t=") {
+			timestamp = part[2:]
+		} else if strings.HasPrefix(part, "v1=") {
+			value = part[3:]
+		}
+	}
+
+	seconds, err := strconv.ParseInt(timestamp, 10, 64)
+	if err != nil {
+		return false
+	}
+	if age := now.Sub(time.Unix(seconds, 0)); age > SignatureTolerance || age < -SignatureTolerance {
+		return false
+	}
+	given, err := hex.DecodeString(value)
+	return err == nil && hmac.Equal(given, signature(h.Secret, timestamp, userId))
+}
+
+func signature(secret []byte, timestamp, userId string) []byte {
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte(timestamp + "." + userId))
+	return mac.Sum(nil)
+}
@@ -0,0 +1,85 @@
+/*
+Copyright (c) 2015, Alberto Corsín Lafuente
+All rights reserved.
+
+Redistribution and use in source and binary forms, with or without
+modification, are permitted provided that the following conditions are met:
+
+1. Redistributions of source code must retain the above copyright notice,
+   this list of conditions and the following disclaimer.
+
+2. Redistributions in binary form must reproduce the above copyright notice,
+   this list of conditions and the following disclaimer in the documentation
+   and/or other materials provided with the distribution.
+
+3. Neither the name of the copyright holder nor the names of its contributors
+   may be used to endorse or promote products derived from this software
+   without specific prior written permission.
+
+THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND
+ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED
+WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE
+FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER
+CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY,
+OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+*/
+package auth
+
+import (
+	"github.com/acorsinl/casimiro/system"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestHeaderAuthenticate(t *testing.T) {
+	proxies, err := ParseProxies("10.0.0.0/8")
+	if err != nil {
+		t.Fatal(err)
+	}
+	h := &Header{Proxies: proxies, Secret: []byte("secret")}
+
+	tests := []struct {
+		name       string
+		remoteAddr string
+		user       string
+		signature  string
+		valid      bool
+	}{
+		{"from proxy", "10.1.2.3:1234", "alice", "", true},
+		{"signed", "192.0.2.1:1234", "alice", Sign(h.Secret, "alice", time.Now()), true},
+		{"badly signed", "192.0.2.1:1234", "alice", Sign([]byte("other"), "alice", time.Now()), false},
+		{"old signature", "192.0.2.1:1234", "alice", Sign(h.Secret, "alice", time.Now().Add(-time.Hour)), false},
+		{"not from proxy", "192.0.2.1:1234", "alice", "", false},
+		{"empty from proxy", "10.1.2.3:1234", "", "", false},
+		{"blank from proxy", "10.1.2.3:1234", "  ", "", false},
+		{"empty signed", "192.0.2.1:1234", "", Sign(h.Secret, "", time.Now()), false},
+	}
+	for _, test := range tests {
+		r := httptest.NewRequest("GET", "/resources", nil)
+		r.RemoteAddr = test.remoteAddr
+		r.Header.Set(system.UserHeader, test.user)
+		if test.signature != "" {
+			r.Header.Set(system.UserSignatureHeader, test.signature)
+		}
+
+		userId, err := h.Authenticate(r)
+		if test.valid && (err != nil || userId != test.user) {
+			t.Errorf("%s: expected %q, got %q, %v", test.name, test.user, userId, err)
+		}
+		if !test.valid && err == nil {
+			t.Errorf("%s: expected an error, got %q", test.name, userId)
+		}
+	}
+}
+
+func TestHeaderWithoutProxiesRequiresUser(t *testing.T) {
+	r := httptest.NewRequest("GET", "/resources", nil)
+	if _, err := (&Header{}).Authenticate(r); err != ErrUnauthenticated {
+		t.Fatalf("expected ErrUnauthenticated, got %v", err)
+	}
+}
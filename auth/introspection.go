@@ -0,0 +1,179 @@
+/*
+Copyright (c) 2015, Alberto Corsín Lafuente
+All rights reserved.
+
+Redistribution and use in source and binary forms, with or without
+modification, are permitted provided that the following conditions are met:
+
+1. Redistributions of source code must retain the above copyright notice,
+   this list of conditions and the following disclaimer.
+
+2. Redistributions in binary form must reproduce the above copyright notice,
+   this list of conditions and the following disclaimer in the documentation
+   and/or other materials provided with the distribution.
+
+3. Neither the name of the copyright holder nor the names of its contributors
+   may be used to endorse or promote products derived from this software
+   without specific prior written permission.
+
+THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND
+ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED
+WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE
+FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER
+CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY,
+OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+*/
+package auth
+
+import (
+	"encoding/json"
+	"errors"
+	"github.com/acorsinl/casimiro/problem"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+// introspectionCacheSize bounds how many active tokens are remembered
+const introspectionCacheSize = 10000
+
+// Introspection authenticates bearer tokens asking the OAuth2 token
+// introspection endpoint (RFC 7662) at URL, as the client ClientId. The
+// user id is the sub of active tokens, which are cached until they expire.
+type Introspection struct {
+	URL          string
+	ClientId     string
+	ClientSecret string
+	Client       *http.Client
+
+	mutex sync.Mutex
+	cache map[string]introspectedToken
+}
+
+type introspectedToken struct {
+	userId    string
+	expiresAt time.Time
+}
+
+// introspectionResponse holds the members of an introspection response
+// used here
+type introspectionResponse struct {
+	Active bool   `json:"active"`
+	Sub    string `json:"sub"`
+	Exp    int64  `json:"exp"`
+}
+
+// NewIntrospection returns an Introspection asking endpoint with the
+// credentials of a client
+func NewIntrospection(endpoint, clientId, clientSecret string) *Introspection {
+	return &Introspection{
+		URL:          endpoint,
+		ClientId:     clientId,
+		ClientSecret: clientSecret,
+		Client:       &http.Client{Timeout: 10 * time.Second},
+		cache:        make(map[string]introspectedToken),
+	}
+}
+
+func (i *Introspection) Challenge() string {
+	return "Bearer"
+}
+
+func (i *Introspection) Authenticate(r *http.Request) (string, error) {
+	token, err := BearerToken(r)
+	if err != nil {
+		return "", err
+	}
+
+	key := hashToken(token)
+	now := time.Now()
+	if userId, ok := i.cached(key, now); ok {
+		return userId, nil
+	}
+
+	response, err := i.introspect(token)
+	if err != nil {
+		return "", problem.Unavailable("The token can't be checked, try again later").Wrap(err)
+	}
+	if !response.Active || (response.Exp != 0 && !now.Before(time.Unix(response.Exp, 0))) {
+		return "", problem.Unauthorized("The token is invalid or has expired")
+	}
+	if response.Sub == "" {
+		return "", problem.Unauthorized("The token has no subject")
+	}
+
+	// Tokens without expiry are checked every time, they may be revoked
+	if response.Exp != 0 {
+		i.store(key, introspectedToken{userId: response.Sub, expiresAt: time.Unix(response.Exp, 0)}, now)
+	}
+	return response.Sub, nil
+}
+
+// introspect asks the endpoint about token
+func (i *Introspection) introspect(token string) (*introspectionResponse, error) {
+	form := url.Values{"token": {token}, "token_type_hint": {"access_token"}}
+	request, err := http.NewRequest(http.MethodPost, i.URL, strings.NewReader(form.Encode()))
+	if err != nil {
+		return nil, err
+	}
+	request.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	request.Header.Set("Accept", "application/json")
+	if i.ClientId != "" {
+		// Client credentials are form encoded before (RFC 6749, 2.3.1)
+		request.SetBasicAuth(url.QueryEscape(i.ClientId), url.QueryEscape(i.ClientSecret))
+	}
+
+	response, err := i.Client.Do(request)
+	if err != nil {
+		return nil, err
+	}
+	defer response.Body.Close()
+
+	if response.StatusCode != http.StatusOK {
+		return nil, errors.New("Introspection endpoint answered " + response.Status)
+	}
+	var introspection introspectionResponse
+	if err = json.NewDecoder(response.Body).Decode(&introspection); err != nil {
+		return nil, err
+	}
+	return &introspection, nil
+}
+
+func (i *Introspection) cached(key string, now time.Time) (string, bool) {
+	i.mutex.Lock()
+	defer i.mutex.Unlock()
+
+	token, ok := i.cache[key]
+	if !ok {
+		return "", false
+	}
+	if !now.Before(token.expiresAt) {
+		delete(i.cache, key)
+		return "", false
+	}
+	return token.userId, true
+}
+
+// store caches token, making room by dropping the expired ones when full
+func (i *Introspection) store(key string, token introspectedToken, now time.Time) {
+	i.mutex.Lock()
+	defer i.mutex.Unlock()
+
+	if len(i.cache) >= introspectionCacheSize {
+		for cachedKey, cached := range i.cache {
+			if !now.Before(cached.expiresAt) {
+				delete(i.cache, cachedKey)
+			}
+		}
+		if len(i.cache) >= introspectionCacheSize {
+			return
+		}
+	}
+	i.cache[key] = token
+}
@@ -0,0 +1,168 @@
+/*
+Copyright (c) 2015, Alberto Corsín Lafuente
+All rights reserved.
+
+Redistribution and use in source and binary forms, with or without
+modification, are permitted provided that the following conditions are met:
+
+1. Redistributions of source code must retain the above copyright notice,
+   this list of conditions and the following disclaimer.
+
+2. Redistributions in binary form must reproduce the above copyright notice,
+   this list of conditions and the following disclaimer in the documentation
+   and/or other materials provided with the distribution.
+
+3. Neither the name of the copyright holder nor the names of its contributors
+   may be used to endorse or promote products derived from this software
+   without specific prior written permission.
+
+THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND
+ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED
+WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE
+FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER
+CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY,
+OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+*/
+package auth
+
+import (
+	"encoding/json"
+	"errors"
+	"github.com/acorsinl/casimiro/problem"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+)
+
+// introspectionServer is a stand-in for an OAuth2 server, answering about
+// the tokens named after their state and counting the requests for each
+type introspectionServer struct {
+	*httptest.Server
+	mutex    sync.Mutex
+	requests map[string]int
+}
+
+func newIntrospectionServer(t *testing.T) *introspectionServer {
+	s := &introspectionServer{requests: make(map[string]int)}
+	s.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		clientId, secret, ok := r.BasicAuth()
+		if !ok || clientId != "casimiro" || secret != "s%3Acret" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+
+		token := r.PostFormValue("token")
+		s.mutex.Lock()
+		s.requests[token]++
+		s.mutex.Unlock()
+
+		response := map[string]interface{}{"active": false}
+		switch token {
+		case "active":
+			response = map[string]interface{}{"active": true, "sub": "alice", "exp": time.Now().Add(time.Hour).Unix()}
+		case "without-exp":
+			response = map[string]interface{}{"active": true, "sub": "alice"}
+		case "without-sub":
+			response = map[string]interface{}{"active": true, "exp": time.Now().Add(time.Hour).Unix()}
+		case "expired":
+			response = map[string]interface{}{"active": true, "sub": "alice", "exp": time.Now().Add(-time.Minute).Unix()}
+		case "failing":
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(response)
+	}))
+	t.Cleanup(s.Close)
+	return s
+}
+
+func (s *introspectionServer) requestsFor(token string) int {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.requests[token]
+}
+
+func introspect(i *Introspection, token string) (string, int) {
+	r := httptest.NewRequest("GET", "/resources", nil)
+	r.Header.Set("Authorization", "Bearer "+token)
+	userId, err := i.Authenticate(r)
+	var p *problem.Problem
+	if errors.As(err, &p) {
+		return userId, p.Status
+	}
+	if err != nil {
+		return userId, http.StatusInternalServerError
+	}
+	return userId, http.StatusOK
+}
+
+func TestIntrospection(t *testing.T) {
+	server := newIntrospectionServer(t)
+	i := NewIntrospection(server.URL, "casimiro", "s:cret")
+
+	tests := []struct {
+		token  string
+		status int
+	}{
+		{"active", http.StatusOK},
+		{"without-exp", http.StatusOK},
+		{"inactive", http.StatusUnauthorized},
+		{"expired", http.StatusUnauthorized},
+		{"without-sub", http.StatusUnauthorized},
+		{"failing", http.StatusServiceUnavailable},
+	}
+	for _, test := range tests {
+		userId, status := introspect(i, test.token)
+		if status != test.status {
+			t.Errorf("%s: expected status %d, got %d", test.token, test.status, status)
+		}
+		if status == http.StatusOK && userId != "alice" {
+			t.Errorf("%s: expected alice, got %q", test.token, userId)
+		}
+	}
+}
+
+func TestIntrospectionUnreachable(t *testing.T) {
+	server := newIntrospectionServer(t)
+	i := NewIntrospection(server.URL, "casimiro", "s:cret")
+	server.Close()
+
+	if _, status := introspect(i, "active"); status != http.StatusServiceUnavailable {
+		t.Fatalf("expected status 503, got %d", status)
+	}
+}
+
+func TestIntrospectionCache(t *testing.T) {
+	server := newIntrospectionServer(t)
+	i := NewIntrospection(server.URL, "casimiro", "s:cret")
+
+	for n := 0; n < 3; n++ {
+		introspect(i, "active")
+		introspect(i, "without-exp")
+	}
+	if requests := server.requestsFor("active"); requests != 1 {
+		t.Fatalf("expected the active token to be cached, got %d requests", requests)
+	}
+	if requests := server.requestsFor("without-exp"); requests != 3 {
+		t.Fatalf("expected tokens without exp not to be cached, got %d requests", requests)
+	}
+
+	// Once its exp is reached the token is asked about again
+	key := hashToken("active")
+	i.mutex.Lock()
+	i.cache[key] = introspectedToken{userId: "alice", expiresAt: time.Now().Add(-time.Second)}
+	i.mutex.Unlock()
+	if _, status := introspect(i, "active"); status != http.StatusOK {
+		t.Fatalf("expected status 200, got %d", status)
+	}
+	if requests := server.requestsFor("active"); requests != 2 {
+		t.Fatalf("expected the expired entry to be dropped, got %d requests", requests)
+	}
+}
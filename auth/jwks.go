@@ -0,0 +1,150 @@
+/*
+Copyright (c) 2015, Alberto Corsín Lafuente
+All rights reserved.
+
+Redistribution and use in source and binary forms, with or without
+modification, are permitted provided that the following conditions are met:
+
+1. Redistributions of source code must retain the above copyright notice,
+   this list of conditions and the following disclaimer.
+
+2. Redistributions in binary form must reproduce the above copyright notice,
+   this list of conditions and the following disclaimer in the documentation
+   and/or other materials provided with the distribution.
+
+3. Neither the name of the copyright holder nor the names of its contributors
+   may be used to endorse or promote products derived from this software
+   without specific prior written permission.
+
+THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND
+ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED
+WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE
+FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER
+CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY,
+OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+*/
+package auth
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"os"
+)
+
+// Key is a verification key of a JSON Web Key Set (RFC 7517)
+type Key struct {
+	Id        string
+	Algorithm string
+	// Key is a []byte for HMAC keys, *rsa.PublicKey or *ecdsa.PublicKey
+	Key interface{}
+}
+
+type jsonWebKey struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Alg string `json:"alg"`
+	Use string `json:"use"`
+	K   string `json:"k"`
+	N   string `json:"n"`
+	E   string `json:"e"`
+	Crv string `json:"crv"`
+	X   string `json:"x"`
+	Y   string `json:"y"`
+}
+
+// LoadKeys reads the keys of the JSON Web Key Set in path. oct (HS256), RSA
+// (RS256) and P-256 EC (ES256) keys are supported, keys used for encryption
+// are skipped.
+func LoadKeys(path string) ([]Key, error) {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var set struct {
+		Keys []jsonWebKey `json:"keys"`
+	}
+	if err = json.Unmarshal(content, &set); err != nil {
+		return nil, fmt.Errorf("Invalid JWKS %s: %v", path, err)
+	}
+
+	var keys []Key
+	for i, jwk := range set.Keys {
+		if jwk.Use != "" && jwk.Use != "sig" {
+			continue
+		}
+		key, err := jwk.key()
+		if err != nil {
+			return nil, fmt.Errorf("Invalid key %d in %s: %v", i, path, err)
+		}
+		keys = append(keys, key)
+	}
+	if len(keys) == 0 {
+		return nil, errors.New("No signing keys in " + path)
+	}
+	return keys, nil
+}
+
+func (jwk jsonWebKey) key() (Key, error) {
+	key := Key{Id: jwk.Kid, Algorithm: jwk.Alg}
+	switch jwk.Kty {
+	case "oct":
+		secret, err := base64.RawURLEncoding.DecodeString(jwk.K)
+		if err != nil || len(secret) == 0 {
+			return key, errors.New("Invalid k")
+		}
+		key.Key = secret
+		return key, checkAlgorithm(&key, "HS256")
+	case "RSA":
+		n, err := decodeInt(jwk.N)
+		if err != nil {
+			return key, errors.New("Invalid n")
+		}
+		e, err := decodeInt(jwk.E)
+		if err != nil || !e.IsInt64() {
+			return key, errors.New("Invalid e")
+		}
+		key.Key = &rsa.PublicKey{N: n, E: int(e.Int64())}
+		return key, checkAlgorithm(&key, "RS256")
+	case "EC":
+		if jwk.Crv != "P-256" {
+			return key, errors.New("Unsupported curve " + jwk.Crv)
+		}
+		x, errX := decodeInt(jwk.X)
+		y, errY := decodeInt(jwk.Y)
+		if errX != nil || errY != nil || !elliptic.P256().IsOnCurve(x, y) {
+			return key, errors.New("Invalid point")
+		}
+		key.Key = &ecdsa.PublicKey{Curve: elliptic.P256(), X: x, Y: y}
+		return key, checkAlgorithm(&key, "ES256")
+	}
+	return key, errors.New("Unsupported key type " + jwk.Kty)
+}
+
+// checkAlgorithm sets the algorithm of key, the only one supported for its
+// type
+func checkAlgorithm(key *Key, algorithm string) error {
+	if key.Algorithm != "" && key.Algorithm != algorithm {
+		return errors.New("Unsupported algorithm " + key.Algorithm)
+	}
+	key.Algorithm = algorithm
+	return nil
+}
+
+func decodeInt(value string) (*big.Int, error) {
+	content, err := base64.RawURLEncoding.DecodeString(value)
+	if err != nil || len(content) == 0 {
+		return nil, errors.New("Invalid integer")
+	}
+	return new(big.Int).SetBytes(content), nil
+}
@@ -0,0 +1,120 @@
+/*
+Copyright (c) 2015, Alberto Corsín Lafuente
+All rights reserved.
+
+Redistribution and use in source and binary forms, with or without
+modification, are permitted provided that the following conditions are met:
+
+1. Redistributions of source code must retain the above copyright notice,
+   this list of conditions and the following disclaimer.
+
+2. Redistributions in binary form must reproduce the above copyright notice,
+   this list of conditions and the following disclaimer in the documentation
+   and/or other materials provided with the distribution.
+
+3. Neither the name of the copyright holder nor the names of its contributors
+   may be used to endorse or promote products derived from this software
+   without specific prior written permission.
+
+THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND
+ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED
+WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE
+FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER
+CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY,
+OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+*/
+package auth
+
+import (
+	"errors"
+	"github.com/acorsinl/casimiro/problem"
+	"github.com/golang-jwt/jwt/v5"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// JWT authenticates requests with a JSON Web Token (RFC 7519) given in an
+// Authorization: Bearer header. Tokens must be signed with one of Keys, have
+// an exp claim and be valid at the time of the request.
+type JWT struct {
+	Keys []Key
+	// Issuer and Audience, when set, must match the iss and aud claims
+	Issuer   string
+	Audience string
+	// UserClaim holds the user id, sub when empty
+	UserClaim string
+	// Leeway allows for clock skew when checking exp and nbf
+	Leeway time.Duration
+}
+
+func (j *JWT) Challenge() string {
+	return "Bearer"
+}
+
+func (j *JWT) Authenticate(r *http.Request) (string, error) {
+	token, err := BearerToken(r)
+	if err != nil {
+		return "", err
+	}
+
+	options := []jwt.ParserOption{
+		jwt.WithValidMethods([]string{"HS256", "RS256", "ES256"}),
+		jwt.WithExpirationRequired(),
+		jwt.WithLeeway(j.Leeway),
+	}
+	if j.Issuer != "" {
+		options = append(options, jwt.WithIssuer(j.Issuer))
+	}
+	if j.Audience != "" {
+		options = append(options, jwt.WithAudience(j.Audience))
+	}
+
+	claims := jwt.MapClaims{}
+	if _, err = jwt.ParseWithClaims(token, claims, j.key, options...); err != nil {
+		return "", problem.Unauthorized("Invalid token: " + strings.TrimPrefix(err.Error(), "token has invalid claims: "))
+	}
+
+	userClaim := j.UserClaim
+	if userClaim == "" {
+		userClaim = "sub"
+	}
+	userId, _ := claims[userClaim].(string)
+	if userId == "" {
+		return "", problem.Unauthorized("Invalid token: " + userClaim + " claim is missing")
+	}
+	return userId, nil
+}
+
+// key returns the key token must be verified with, the one named in its
+// kid header or the only key for its algorithm
+func (j *JWT) key(token *jwt.Token) (interface{}, error) {
+	kid, _ := token.Header["kid"].(string)
+	var found *Key
+	for i, key := range j.Keys {
+		if key.Algorithm != token.Method.Alg() || (kid != "" && key.Id != kid) {
+			continue
+		}
+		if found != nil {
+			return nil, errors.New("key is ambiguous, kid is required")
+		}
+		found = &j.Keys[i]
+	}
+	if found == nil {
+		return nil, errors.New("unknown key")
+	}
+	return found.Key, nil
+}
+
+// BearerToken returns the token of the Authorization: Bearer header of r
+func BearerToken(r *http.Request) (string, error) {
+	header := r.Header.Get("Authorization")
+	if len(header) < 7 || !strings.EqualFold(header[:7], "Bearer ") || strings.TrimSpace(header[7:]) == "" {
+		return "", problem.Unauthorized("A bearer token is required")
+	}
+	return strings.TrimSpace(header[7:]), nil
+}
@@ -0,0 +1,75 @@
+/*
+Copyright (c) 2015, Alberto Corsín Lafuente
+All rights reserved.
+
+Redistribution and use in source and binary forms, with or without
+modification, are permitted provided that the following conditions are met:
+
+1. Redistributions of source code must retain the above copyright notice,
+   this list of conditions and the following disclaimer.
+
+2. Redistributions in binary form must reproduce the above copyright notice,
+   this list of conditions and the following disclaimer in the documentation
+   and/or other materials provided with the distribution.
+
+3. Neither the name of the copyright holder nor the names of its contributors
+   may be used to endorse or promote products derived from this software
+   without specific prior written permission.
+
+THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND
+ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED
+WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE
+FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER
+CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY,
+OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+*/
+package auth
+
+import (
+	"github.com/golang-jwt/jwt/v5"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+var testSecret = []byte("a secret only known to the tests")
+
+func authenticateJWT(t *testing.T, j *JWT, claims jwt.MapClaims) (string, error) {
+	token, err := jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString(testSecret)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r := httptest.NewRequest("GET", "/resources", nil)
+	r.Header.Set("Authorization", "Bearer "+token)
+	return j.Authenticate(r)
+}
+
+func TestJWTAuthenticate(t *testing.T) {
+	j := &JWT{Keys: []Key{{Algorithm: "HS256", Key: testSecret}}, Leeway: time.Minute}
+	now := time.Now()
+
+	tests := []struct {
+		name   string
+		claims jwt.MapClaims
+		valid  bool
+	}{
+		{"valid", jwt.MapClaims{"sub": "alice", "exp": now.Add(time.Hour).Unix()}, true},
+		{"within leeway", jwt.MapClaims{"sub": "alice", "exp": now.Add(-30 * time.Second).Unix()}, true},
+		{"expired", jwt.MapClaims{"sub": "alice", "exp": now.Add(-time.Hour).Unix()}, false},
+		{"without exp", jwt.MapClaims{"sub": "alice"}, false},
+		{"without sub", jwt.MapClaims{"exp": now.Add(time.Hour).Unix()}, false},
+	}
+	for _, test := range tests {
+		userId, err := authenticateJWT(t, j, test.claims)
+		if test.valid && (err != nil || userId != "alice") {
+			t.Errorf("%s: expected alice, got %q, %v", test.name, userId, err)
+		}
+		if !test.valid && err == nil {
+			t.Errorf("%s: expected an error, got %q", test.name, userId)
+		}
+	}
+}
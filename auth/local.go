@@ -0,0 +1,257 @@
+/*
+Copyright (c) 2015, Alberto Corsín Lafuente
+All rights reserved.
+
+Redistribution and use in source and binary forms, with or without
+modification, are permitted provided that the following conditions are met:
+
+1. Redistributions of source code must retain the above copyright notice,
+   this list of conditions and the following disclaimer.
+
+2. Redistributions in binary form must reproduce the above copyright notice,
+   this list of conditions and the following disclaimer in the documentation
+   and/or other materials provided with the distribution.
+
+3. Neither the name of the copyright holder nor the names of its contributors
+   may be used to endorse or promote products derived from this software
+   without specific prior written permission.
+
+THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND
+ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED
+WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE
+FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER
+CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY,
+OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+*/
+package auth
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"github.com/acorsinl/casimiro/models"
+	"github.com/acorsinl/casimiro/problem"
+	"github.com/acorsinl/casimiro/system"
+	"golang.org/x/crypto/bcrypt"
+	"net/http"
+	"time"
+)
+
+// Tokens are given to local users when they log in or refresh their
+// session, in the fields of an OAuth2 token response
+type Tokens struct {
+	AccessToken  string `json:"accessToken"`
+	TokenType    string `json:"tokenType"`
+	ExpiresIn    int    `json:"expiresIn"`
+	RefreshToken string `json:"refreshToken"`
+}
+
+// Local authenticates users registered in Users with a password. Logging
+// in starts a session with a bearer access token, valid for AccessTTL, and
+// a refresh token that gets new tokens until RefreshTTL after the last
+// refresh. Accounts are locked for LockoutDuration after MaxFailedLogins
+// login attempts without a successful one.
+type Local struct {
+	Users           models.UserStore
+	AccessTTL       time.Duration
+	RefreshTTL      time.Duration
+	MaxFailedLogins int
+	LockoutDuration time.Duration
+	// BcryptCost is the cost of password hashes, bcrypt.DefaultCost if zero
+	BcryptCost int
+}
+
+// NewLocal returns a Local authenticator for users with the default
+// settings
+func NewLocal(users models.UserStore) *Local {
+	return &Local{
+		Users:           users,
+		AccessTTL:       15 * time.Minute,
+		RefreshTTL:      30 * 24 * time.Hour,
+		MaxFailedLogins: 5,
+		LockoutDuration: 15 * time.Minute,
+	}
+}
+
+var (
+	errInvalidCredentials = problem.Unauthorized("Invalid username or password")
+	errInvalidToken       = problem.Unauthorized("The token is invalid or has expired")
+)
+
+// dummyHash is checked against the password of unknown users, so they
+// take as long to fail as known ones
+var dummyHash, _ = bcrypt.GenerateFromPassword([]byte("casimiro"), bcrypt.DefaultCost)
+
+func (l *Local) Challenge() string {
+	return "Bearer"
+}
+
+func (l *Local) Authenticate(r *http.Request) (string, error) {
+	token, err := BearerToken(r)
+	if err != nil {
+		return "", err
+	}
+
+	session, err := l.Users.GetSessionByAccessHash(hashToken(token))
+	if errors.Is(err, models.ErrNotFound) {
+		return "", errInvalidToken
+	}
+	if err != nil {
+		return "", err
+	}
+	if !time.Now().Before(session.AccessExpiresAt) {
+		return "", errInvalidToken
+	}
+	return session.UserId, nil
+}
+
+// Register creates a user with username and password
+func (l *Local) Register(username, password string) (*models.User, error) {
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), l.BcryptCost)
+	if errors.Is(err, bcrypt.ErrPasswordTooLong) {
+		return nil, problem.Validation("The password is too long", problem.FieldError{Field: "password", Message: "must have at most 72 bytes"})
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	user := &models.User{Id: system.NewUUID(), Username: username, PasswordHash: string(hash)}
+	err = l.Users.InsertUser(user)
+	if errors.Is(err, models.ErrDuplicate) {
+		return nil, problem.Conflict("The username is already taken")
+	}
+	if err != nil {
+		return nil, err
+	}
+	return user, nil
+}
+
+// Login checks the password of username and starts a session
+func (l *Local) Login(username, password string) (*Tokens, error) {
+	user, err := l.Users.GetUserByName(username)
+	if errors.Is(err, models.ErrNotFound) {
+		bcrypt.CompareHashAndPassword(dummyHash, []byte(password))
+		return nil, errInvalidCredentials
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	// The attempt is counted before checking the password, so concurrent
+	// guesses can't get past the lockout
+	now := time.Now()
+	allowed, err := l.Users.RecordLoginAttempt(user.Id, l.MaxFailedLogins, now, now.Add(l.LockoutDuration))
+	if err != nil {
+		return nil, err
+	}
+	if !allowed {
+		return nil, problem.New(http.StatusTooManyRequests, "locked", "Too many failed logins, try again later")
+	}
+
+	if bcrypt.CompareHashAndPassword([]byte(user.PasswordHash), []byte(password)) != nil {
+		return nil, errInvalidCredentials
+	}
+	if err = l.Users.ResetLoginAttempts(user.Id); err != nil {
+		return nil, err
+	}
+
+	session := &models.Session{Id: system.NewUUID(), UserId: user.Id}
+	tokens, err := l.newTokens(session, now)
+	if err != nil {
+		return nil, err
+	}
+	if err = l.Users.InsertSession(session); err != nil {
+		return nil, err
+	}
+	return tokens, nil
+}
+
+// Refresh replaces the tokens of the session of refreshToken, which can't
+// be used again
+func (l *Local) Refresh(refreshToken string) (*Tokens, error) {
+	hash := hashToken(refreshToken)
+	session, err := l.Users.GetSessionByRefreshHash(hash)
+	if errors.Is(err, models.ErrNotFound) {
+		return nil, errInvalidToken
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	if !now.Before(session.ExpiresAt) {
+		l.Users.DeleteSession(session.Id)
+		return nil, errInvalidToken
+	}
+
+	tokens, err := l.newTokens(session, now)
+	if err != nil {
+		return nil, err
+	}
+	err = l.Users.RotateSession(session, hash)
+	if errors.Is(err, models.ErrNotFound) {
+		// Refreshed or logged out meanwhile
+		return nil, errInvalidToken
+	}
+	if err != nil {
+		return nil, err
+	}
+	return tokens, nil
+}
+
+// Logout ends the session of the access token given in r
+func (l *Local) Logout(r *http.Request) error {
+	token, err := BearerToken(r)
+	if err != nil {
+		return err
+	}
+
+	session, err := l.Users.GetSessionByAccessHash(hashToken(token))
+	if errors.Is(err, models.ErrNotFound) {
+		return errInvalidToken
+	}
+	if err != nil {
+		return err
+	}
+
+	err = l.Users.DeleteSession(session.Id)
+	if errors.Is(err, models.ErrNotFound) {
+		return nil
+	}
+	return err
+}
+
+// newTokens sets new random tokens and expiry times in session
+func (l *Local) newTokens(session *models.Session, now time.Time) (*Tokens, error) {
+	access, err := randomToken()
+	if err != nil {
+		return nil, err
+	}
+	refresh, err := randomToken()
+	if err != nil {
+		return nil, err
+	}
+
+	session.AccessHash = hashToken(access)
+	session.RefreshHash = hashToken(refresh)
+	session.AccessExpiresAt = now.Add(l.AccessTTL).UTC()
+	session.ExpiresAt = now.Add(l.RefreshTTL).UTC()
+	return &Tokens{
+		AccessToken:  access,
+		TokenType:    "Bearer",
+		ExpiresIn:    int(l.AccessTTL / time.Second),
+		RefreshToken: refresh,
+	}, nil
+}
+
+func randomToken() (string, error) {
+	secret := make([]byte, 32)
+	if _, err := rand.Read(secret); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(secret), nil
+}
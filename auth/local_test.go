@@ -0,0 +1,109 @@
+/*
+Copyright (c) 2015, Alberto Corsín Lafuente
+All rights reserved.
+
+Redistribution and use in source and binary forms, with or without
+modification, are permitted provided that the following conditions are met:
+
+1. Redistributions of source code must retain the above copyright notice,
+   this list of conditions and the following disclaimer.
+
+2. Redistributions in binary form must reproduce the above copyright notice,
+   this list of conditions and the following disclaimer in the documentation
+   and/or other materials provided with the distribution.
+
+3. Neither the name of the copyright holder nor the names of its contributors
+   may be used to endorse or promote products derived from this software
+   without specific prior written permission.
+
+THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND
+ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED
+WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE
+FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER
+CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY,
+OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+*/
+package auth
+
+import (
+	"errors"
+	"github.com/acorsinl/casimiro/models"
+	"github.com/acorsinl/casimiro/problem"
+	"golang.org/x/crypto/bcrypt"
+	"net/http"
+	"sync"
+	"testing"
+)
+
+func newTestLocal(t *testing.T) *Local {
+	local := NewLocal(models.NewMemoryModel())
+	local.BcryptCost = bcrypt.MinCost
+	if _, err := local.Register("alice", "secret"); err != nil {
+		t.Fatal(err)
+	}
+	return local
+}
+
+func loginStatus(err error) int {
+	var p *problem.Problem
+	if errors.As(err, &p) {
+		return p.Status
+	}
+	return http.StatusOK
+}
+
+func TestLoginLocksAfterMaxFailedLogins(t *testing.T) {
+	local := newTestLocal(t)
+
+	for i := 0; i < local.MaxFailedLogins; i++ {
+		if _, err := local.Login("alice", "wrong"); loginStatus(err) != http.StatusUnauthorized {
+			t.Fatalf("attempt %d: expected status 401, got %v", i+1, err)
+		}
+	}
+	if _, err := local.Login("alice", "secret"); loginStatus(err) != http.StatusTooManyRequests {
+		t.Fatalf("expected the account to be locked, got %v", err)
+	}
+}
+
+func TestLoginResetsFailedLogins(t *testing.T) {
+	local := newTestLocal(t)
+
+	for i := 0; i < local.MaxFailedLogins-1; i++ {
+		local.Login("alice", "wrong")
+	}
+	if _, err := local.Login("alice", "secret"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := local.Login("alice", "wrong"); loginStatus(err) != http.StatusUnauthorized {
+		t.Fatalf("expected status 401, got %v", err)
+	}
+}
+
+func TestConcurrentLoginsCantExceedMaxFailedLogins(t *testing.T) {
+	local := newTestLocal(t)
+
+	var wg sync.WaitGroup
+	var mutex sync.Mutex
+	checked := 0
+	for i := 0; i < 50; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, err := local.Login("alice", "wrong")
+			if loginStatus(err) == http.StatusUnauthorized {
+				mutex.Lock()
+				checked++
+				mutex.Unlock()
+			}
+		}()
+	}
+	wg.Wait()
+
+	if checked != local.MaxFailedLogins {
+		t.Fatalf("expected %d passwords to be checked, got %d", local.MaxFailedLogins, checked)
+	}
+}
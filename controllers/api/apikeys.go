@@ -0,0 +1,273 @@
+/*
+Copyright (c) 2015, Alberto Corsín Lafuente
+All rights reserved.
+
+Redistribution and use in source and binary forms, with or without
+modification, are permitted provided that the following conditions are met:
+
+1. Redistributions of source code must retain the above copyright notice,
+   this list of conditions and the following disclaimer.
+
+2. Redistributions in binary form must reproduce the above copyright notice,
+   this list of conditions and the following disclaimer in the documentation
+   and/or other materials provided with the distribution.
+
+3. Neither the name of the copyright holder nor the names of its contributors
+   may be used to endorse or promote products derived from this software
+   without specific prior written permission.
+
+THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND
+ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED
+WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE
+FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER
+CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY,
+OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+*/
+package api
+
+import (
+	"errors"
+	"github.com/acorsinl/casimiro/auth"
+	"github.com/acorsinl/casimiro/models"
+	"github.com/acorsinl/casimiro/problem"
+	"github.com/acorsinl/casimiro/system"
+	"github.com/gorilla/mux"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var apiKeyStore models.APIKeyStore
+
+// SetAPIKeyStore sets the storage backend used by the API key handlers
+func SetAPIKeyStore(store models.APIKeyStore) {
+	apiKeyStore = store
+}
+
+// apiKeyRequest is the body of POST /apikeys
+type apiKeyRequest struct {
+	Name      string     `json:"name" validate:"required,max=255"`
+	Scopes    []string   `json:"scopes" validate:"required"`
+	ExpiresAt *time.Time `json:"expiresAt"`
+}
+
+// GetAPIKeys retrieves the API keys of the current user, revoked ones
+// included
+func GetAPIKeys(w http.ResponseWriter, r *http.Request) {
+	userId := system.UserId(r)
+	queryParams, err := system.GetQueryParameters(r.RequestURI)
+	if err != nil {
+		problem.Write(w, r, problem.BadRequest(err.Error()))
+		return
+	}
+
+	offset, limit, err := system.GetPagingParameters(queryParams)
+	if err != nil {
+		problem.Write(w, r, problem.BadRequest(err.Error()))
+		return
+	}
+
+	// One more key than needed tells whether there is a next page
+	keys, err := apiKeyStore.GetAPIKeys(userId, offset, limit+1)
+	if err != nil {
+		problem.Write(w, r, storeProblem(err))
+		return
+	}
+	more := len(keys) > limit
+	if more {
+		keys = keys[:limit]
+	}
+
+	output := system.APIMultipleOutput{}
+	output.Data = make([]map[string]interface{}, len(keys))
+	for index := range keys {
+		output.Data[index] = apiKeyData(&keys[index], "")
+	}
+	output.Paging = make(map[string]interface{})
+	output.Paging["offset"] = offset
+	output.Paging["limit"] = limit
+	output.Links = map[string]string{"first": system.PageLink(r, nil)}
+	if offset > 0 {
+		prev := offset - limit
+		if prev < 0 {
+			prev = 0
+		}
+		output.Links["prev"] = system.PageLink(r, map[string]string{"$offset": strconv.Itoa(prev)})
+	}
+	if more {
+		output.Links["next"] = system.PageLink(r, map[string]string{"$offset": strconv.Itoa(offset + limit)})
+	}
+	system.APIMultipleResults(http.StatusOK, "OK", output, w)
+}
+
+// AddAPIKey creates an API key for the current user. The key is only
+// returned in this response, only its hash is stored.
+func AddAPIKey(w http.ResponseWriter, r *http.Request) {
+	request := new(apiKeyRequest)
+	userId := system.UserId(r)
+
+	if err := decodeBody(r, request); err != nil {
+		problem.Write(w, r, err)
+		return
+	}
+
+	var fieldErrors []problem.FieldError
+	for i, scope := range request.Scopes {
+		if !auth.ValidScope(scope) {
+			fieldErrors = append(fieldErrors, problem.FieldError{Field: "scopes[" + strconv.Itoa(i) + "]", Message: scopeMessage()})
+		}
+	}
+	if len(strings.Join(request.Scopes, " ")) > models.APIKeyScopesLength {
+		fieldErrors = append(fieldErrors, problem.FieldError{Field: "scopes", Message: "must have at most " + strconv.Itoa(models.APIKeyScopesLength) + " characters in total"})
+	}
+	if request.ExpiresAt != nil && !request.ExpiresAt.After(time.Now()) {
+		fieldErrors = append(fieldErrors, problem.FieldError{Field: "expiresAt", Message: "must be in the future"})
+	}
+	if len(fieldErrors) > 0 {
+		problem.Write(w, r, problem.Validation("The request body isn't valid", fieldErrors...))
+		return
+	}
+
+	secret, prefix, hash, err := auth.NewAPIKey()
+	if err != nil {
+		problem.Write(w, r, problem.Internal(err))
+		return
+	}
+	key := &models.APIKey{
+		Id:        system.NewUUID(),
+		UserId:    userId,
+		Name:      request.Name,
+		Prefix:    prefix,
+		Hash:      hash,
+		Scopes:    request.Scopes,
+		ExpiresAt: request.ExpiresAt,
+	}
+
+	err = retry(func() error {
+		return apiKeyStore.InsertAPIKey(key)
+	})
+	if err != nil {
+		problem.Write(w, r, storeProblem(err))
+		return
+	}
+
+	system.APISingleResult(http.StatusCreated, "API key added", apiKeyData(key, secret), w)
+}
+
+// GetAPIKey retrieves an API key of the current user given its Id
+func GetAPIKey(w http.ResponseWriter, r *http.Request) {
+	userId := system.UserId(r)
+	keyId := mux.Vars(r)["keyId"]
+
+	key, err := apiKeyStore.GetAPIKeyById(userId, keyId)
+	if err != nil {
+		problem.Write(w, r, storeProblem(err))
+		return
+	}
+
+	system.APISingleResult(http.StatusOK, "OK", apiKeyData(key, ""), w)
+}
+
+// RotateAPIKey replaces the secret of an active API key of the current
+// user, the previous one stops working at once
+func RotateAPIKey(w http.ResponseWriter, r *http.Request) {
+	userId := system.UserId(r)
+	keyId := mux.Vars(r)["keyId"]
+
+	secret, prefix, hash, err := auth.NewAPIKey()
+	if err != nil {
+		problem.Write(w, r, err)
+		return
+	}
+	err = retry(func() error {
+		return apiKeyStore.RotateAPIKey(userId, keyId, prefix, hash, time.Now())
+	})
+	if errors.Is(err, models.ErrNotFound) {
+		err = apiKeyUnchanged(userId, keyId, "Expired and revoked API keys can't be rotated")
+	}
+	if err != nil {
+		problem.Write(w, r, storeProblem(err))
+		return
+	}
+
+	key, err := apiKeyStore.GetAPIKeyById(userId, keyId)
+	if err != nil {
+		problem.Write(w, r, storeProblem(err))
+		return
+	}
+
+	system.APISingleResult(http.StatusOK, "API key rotated", apiKeyData(key, secret), w)
+}
+
+// RevokeAPIKey revokes an API key of the current user, revoked keys are
+// kept so their owner can still see them
+func RevokeAPIKey(w http.ResponseWriter, r *http.Request) {
+	userId := system.UserId(r)
+	keyId := mux.Vars(r)["keyId"]
+
+	err := retry(func() error {
+		return apiKeyStore.RevokeAPIKey(userId, keyId, time.Now())
+	})
+	if errors.Is(err, models.ErrNotFound) {
+		err = apiKeyUnchanged(userId, keyId, "The API key is already revoked")
+	}
+	if err != nil {
+		problem.Write(w, r, storeProblem(err))
+		return
+	}
+
+	system.APIReturn(http.StatusOK, "API key revoked", w)
+}
+
+// scopeMessage tells which scopes API keys can be given
+func scopeMessage() string {
+	resources := auth.ScopeResources()
+	if len(resources) == 0 {
+		return "must be * or <resource>:read or <resource>:write"
+	}
+	return "must be * or <resource>:read or <resource>:write, where resource is one of " + strings.Join(resources, ", ")
+}
+
+// apiKeyUnchanged returns the error to answer when a key of userId wasn't
+// changed by a conditional update: not found if it doesn't exist, otherwise
+// a conflict explained by detail
+func apiKeyUnchanged(userId, keyId, detail string) error {
+	if _, err := apiKeyStore.GetAPIKeyById(userId, keyId); err != nil {
+		return err
+	}
+	return problem.Conflict(detail)
+}
+
+// APIKeyOptions returns the Access-Control tier headers for API keys
+func APIKeyOptions(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Access-Control-Allow-Methods", "POST, GET, OPTIONS, DELETE")
+	w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Content-Length, Accept-Encoding, Authorization, "+auth.APIKeyHeader+", "+system.UserHeader)
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+}
+
+// apiKeyData returns the JSON representation of key, along with its secret
+// when just created or rotated
+func apiKeyData(key *models.APIKey, secret string) map[string]interface{} {
+	data := make(map[string]interface{})
+	data["href"] = system.APIKeysUrl + "/" + key.Id
+	data["id"] = key.Id
+	data["name"] = key.Name
+	data["prefix"] = key.Prefix
+	data["scopes"] = key.Scopes
+	if key.Scopes == nil {
+		data["scopes"] = []string{}
+	}
+	data["expiresAt"] = key.ExpiresAt
+	data["revokedAt"] = key.RevokedAt
+	data["createdAt"] = key.CreatedAt
+	data["updatedAt"] = key.UpdatedAt
+	if secret != "" {
+		data["key"] = secret
+	}
+	return data
+}
@@ -0,0 +1,130 @@
+/*
+Copyright (c) 2015, Alberto Corsín Lafuente
+All rights reserved.
+
+Redistribution and use in source and binary forms, with or without
+modification, are permitted provided that the following conditions are met:
+
+1. Redistributions of source code must retain the above copyright notice,
+   this list of conditions and the following disclaimer.
+
+2. Redistributions in binary form must reproduce the above copyright notice,
+   this list of conditions and the following disclaimer in the documentation
+   and/or other materials provided with the distribution.
+
+3. Neither the name of the copyright holder nor the names of its contributors
+   may be used to endorse or promote products derived from this software
+   without specific prior written permission.
+
+THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND
+ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED
+WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE
+FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER
+CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY,
+OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+*/
+package api
+
+import (
+	"encoding/json"
+	"github.com/acorsinl/casimiro/auth"
+	"github.com/acorsinl/casimiro/models"
+	"github.com/acorsinl/casimiro/problem"
+	"github.com/acorsinl/casimiro/system"
+	"github.com/gorilla/mux"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+// apiKeysRouter returns a router serving the API keys of a memory store
+// along with the store
+func apiKeysRouter() (*mux.Router, *models.MemoryModel) {
+	store := models.NewMemoryModel()
+	SetAPIKeyStore(store)
+	auth.SetScopeResources([]string{"resources"})
+
+	r := mux.NewRouter()
+	r.HandleFunc(system.APIKeysUrl, AddAPIKey).Methods("POST")
+	r.HandleFunc(system.APIKeysUrl+"/{keyId}", RevokeAPIKey).Methods("DELETE")
+	return r, store
+}
+
+func TestAddAPIKey(t *testing.T) {
+	router, store := apiKeysRouter()
+
+	w := serveResources(router, "POST", system.APIKeysUrl, "application/json", `{"name": "ci", "scopes": ["resources:read"]}`)
+	if w.Code != http.StatusCreated {
+		t.Fatalf("expected 201, got %d %s", w.Code, w.Body.String())
+	}
+	var output struct {
+		Data struct {
+			Id     string `json:"id"`
+			Key    string `json:"key"`
+			Prefix string `json:"prefix"`
+		} `json:"data"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &output); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(output.Data.Key, output.Data.Prefix) {
+		t.Errorf("expected key %q to start with %q", output.Data.Key, output.Data.Prefix)
+	}
+	// Only the hash of the key is stored
+	key, err := store.GetAPIKeyByHash(auth.HashAPIKey(output.Data.Key))
+	if err != nil || key.Id != output.Data.Id || key.UserId != "alice" {
+		t.Errorf("unexpected stored key %+v %v", key, err)
+	}
+}
+
+func TestAddAPIKeyScopeErrors(t *testing.T) {
+	router, _ := apiKeysRouter()
+	long := `"resources:read"` + strings.Repeat(`, "resources:read"`, models.APIKeyScopesLength/len("resources:read"))
+
+	tests := []struct {
+		scopes string
+		field  string
+	}{
+		{`"resources:delete"`, "scopes[0]"},
+		{`"*", "widgets:read"`, "scopes[1]"},
+		{long, "scopes"},
+	}
+	for _, test := range tests {
+		w := serveResources(router, "POST", system.APIKeysUrl, "application/json", `{"name": "ci", "scopes": [`+test.scopes+`]}`)
+		if w.Code != http.StatusUnprocessableEntity {
+			t.Errorf("%s: expected 422, got %d", test.field, w.Code)
+			continue
+		}
+		var output problem.Problem
+		if err := json.Unmarshal(w.Body.Bytes(), &output); err != nil {
+			t.Fatal(err)
+		}
+		if len(output.Errors) != 1 || output.Errors[0].Field != test.field {
+			t.Errorf("%s: unexpected errors %v", test.field, output.Errors)
+		}
+	}
+}
+
+func TestRevokeAPIKey(t *testing.T) {
+	router, store := apiKeysRouter()
+	if err := store.InsertAPIKey(&models.APIKey{Id: "k1", UserId: "alice", Hash: "h1", Scopes: []string{"*"}}); err != nil {
+		t.Fatal(err)
+	}
+
+	if w := serveResources(router, "DELETE", system.APIKeysUrl+"/k1", "", ""); w.Code != http.StatusOK {
+		t.Fatalf("expected 200 revoking, got %d", w.Code)
+	}
+	if key, _ := store.GetAPIKeyById("alice", "k1"); key.RevokedAt == nil {
+		t.Error("expected the key to be revoked")
+	}
+	if w := serveResources(router, "DELETE", system.APIKeysUrl+"/k1", "", ""); w.Code != http.StatusConflict {
+		t.Errorf("expected 409 revoking again, got %d", w.Code)
+	}
+	if w := serveResources(router, "DELETE", system.APIKeysUrl+"/missing", "", ""); w.Code != http.StatusNotFound {
+		t.Errorf("expected 404 revoking a missing key, got %d", w.Code)
+	}
+}
@@ -0,0 +1,83 @@
+/*
+Copyright (c) 2015, Alberto Corsín Lafuente
+All rights reserved.
+
+Redistribution and use in source and binary forms, with or without
+modification, are permitted provided that the following conditions are met:
+
+1. Redistributions of source code must retain the above copyright notice,
+   this list of conditions and the following disclaimer.
+
+2. Redistributions in binary form must reproduce the above copyright notice,
+   this list of conditions and the following disclaimer in the documentation
+   and/or other materials provided with the distribution.
+
+3. Neither the name of the copyright holder nor the names of its contributors
+   may be used to endorse or promote products derived from this software
+   without specific prior written permission.
+
+THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND
+ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED
+WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE
+FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER
+CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY,
+OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+*/
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"github.com/acorsinl/casimiro/problem"
+	"github.com/acorsinl/casimiro/validation"
+	"io/ioutil"
+	"net/http"
+)
+
+// decodeBody decodes the JSON body of r into destination and validates it,
+// returning the problem to answer if either fails
+func decodeBody(r *http.Request, destination interface{}) error {
+	content, err := ioutil.ReadAll(r.Body)
+	r.Body.Close()
+	if err != nil {
+		return problem.BadRequest(err.Error())
+	}
+	return decodeDocument(content, destination)
+}
+
+// decodeDocument checks a JSON document against the schema of destination,
+// then decodes it into destination and validates the result
+func decodeDocument(document []byte, destination interface{}) error {
+	err := validation.SchemaOf(destination).Validate(document)
+	if err != nil {
+		var violations validation.Errors
+		if !errors.As(err, &violations) {
+			return problem.BadRequest("Invalid JSON body: " + err.Error())
+		}
+		return validationProblem(err)
+	}
+
+	if err = json.Unmarshal(document, destination); err != nil {
+		return problem.Validation(err.Error())
+	}
+	return validationProblem(validation.Validate(destination))
+}
+
+// validationProblem turns validation errors into a 422 listing every
+// violation, other errors are returned unchanged
+func validationProblem(err error) error {
+	var violations validation.Errors
+	if !errors.As(err, &violations) {
+		return err
+	}
+
+	fieldErrors := make([]problem.FieldError, len(violations))
+	for i, violation := range violations {
+		fieldErrors[i] = problem.FieldError{Field: violation.Field, Message: violation.Message}
+	}
+	return problem.Validation("The request body isn't valid", fieldErrors...)
+}
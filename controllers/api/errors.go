@@ -0,0 +1,74 @@
+/*
+Copyright (c) 2015, Alberto Corsín Lafuente
+All rights reserved.
+
+Redistribution and use in source and binary forms, with or without
+modification, are permitted provided that the following conditions are met:
+
+1. Redistributions of source code must retain the above copyright notice,
+   this list of conditions and the following disclaimer.
+
+2. Redistributions in binary form must reproduce the above copyright notice,
+   this list of conditions and the following disclaimer in the documentation
+   and/or other materials provided with the distribution.
+
+3. Neither the name of the copyright holder nor the names of its contributors
+   may be used to endorse or promote products derived from this software
+   without specific prior written permission.
+
+THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND
+ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED
+WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE
+FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER
+CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY,
+OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+*/
+package api
+
+import (
+	"errors"
+	"github.com/acorsinl/casimiro/models"
+	"github.com/acorsinl/casimiro/problem"
+	"time"
+)
+
+// storeAttempts is how many times a storage operation is tried while it
+// fails with models.ErrRetry
+const storeAttempts = 3
+
+// retry runs operation until it succeeds or fails with an error that isn't
+// models.ErrRetry, up to storeAttempts times, waiting longer after each one
+func retry(operation func() error) error {
+	var err error
+	for attempt := 1; attempt <= storeAttempts; attempt++ {
+		if err = operation(); !errors.Is(err, models.ErrRetry) {
+			return err
+		}
+		if attempt < storeAttempts {
+			time.Sleep(time.Duration(attempt) * 50 * time.Millisecond)
+		}
+	}
+	return err
+}
+
+// storeProblem returns the problem to answer for an error of the storage,
+// other errors are returned unchanged
+func storeProblem(err error) error {
+	switch {
+	case errors.Is(err, models.ErrNotFound):
+		return problem.NotFound("Not found")
+	case errors.Is(err, models.ErrDuplicate):
+		return problem.Conflict("A resource with the same id already exists")
+	case errors.Is(err, models.ErrReferenced):
+		return problem.Conflict("The resource is referenced by others")
+	case errors.Is(err, models.ErrInvalidReference):
+		return problem.Validation("The resource references one that doesn't exist")
+	case errors.Is(err, models.ErrRetry):
+		return problem.Unavailable("The storage is busy, try again later").Wrap(err)
+	}
+	return err
+}
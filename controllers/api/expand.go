@@ -0,0 +1,132 @@
+/*
+Copyright (c) 2015, Alberto Corsín Lafuente
+All rights reserved.
+
+Redistribution and use in source and binary forms, with or without
+modification, are permitted provided that the following conditions are met:
+
+1. Redistributions of source code must retain the above copyright notice,
+   this list of conditions and the following disclaimer.
+
+2. Redistributions in binary form must reproduce the above copyright notice,
+   this list of conditions and the following disclaimer in the documentation
+   and/or other materials provided with the distribution.
+
+3. Neither the name of the copyright holder nor the names of its contributors
+   may be used to endorse or promote products derived from this software
+   without specific prior written permission.
+
+THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND
+ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED
+WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE
+FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER
+CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY,
+OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+*/
+package api
+
+import (
+	"errors"
+	"github.com/acorsinl/casimiro/models"
+	"github.com/acorsinl/casimiro/system"
+	"net/url"
+)
+
+// Expander loads a relation of several resources at once. It gets the ids
+// of resources owned by userId and returns the related data keyed by
+// resource id; resources without related data can be left out.
+type Expander func(userId string, resourceIds []string) (map[string]interface{}, error)
+
+// resourceExpanders holds the relations of resources, owner is always
+// available
+var resourceExpanders = map[string]Expander{"owner": expandOwner}
+
+// ExpandResources lets $expand=name inline the relation loaded by expander
+// in the resources returned by the /resources handlers. It must be called
+// before the server starts.
+func ExpandResources(name string, expander Expander) {
+	resourceExpanders[name] = expander
+}
+
+// selectParameter returns the fields asked for in $select, or the default
+// ones. The id is always returned since it identifies the resource.
+func selectParameter(queryParams url.Values) ([]string, error) {
+	value := queryParams.Get("$select")
+	if value == "" {
+		return models.DefaultResourceSelect, nil
+	}
+
+	fields, err := system.ParseFieldList("$select", value, models.ResourceSelectable)
+	if err != nil {
+		return nil, err
+	}
+	for _, field := range fields {
+		if field == "id" {
+			return fields, nil
+		}
+	}
+	return append([]string{"id"}, fields...), nil
+}
+
+// expandParameter returns the relations asked for in $expand
+func expandParameter(queryParams url.Values) ([]string, error) {
+	value := queryParams.Get("$expand")
+	if value == "" {
+		return nil, nil
+	}
+
+	relations := make(map[string]bool)
+	for name := range resourceExpanders {
+		relations[name] = true
+	}
+	return system.ParseFieldList("$expand", value, relations)
+}
+
+// expand loads relations for resources and adds them to data, which holds
+// the output of each resource in the same order. Resources without related
+// data get a null.
+func expand(userId string, relations []string, resources []models.Resource, data []map[string]interface{}) error {
+	if len(relations) == 0 || len(resources) == 0 {
+		return nil
+	}
+
+	ids := make([]string, len(resources))
+	for index := range resources {
+		ids[index] = resources[index].Id
+	}
+	for _, relation := range relations {
+		related, err := resourceExpanders[relation](userId, ids)
+		if err != nil {
+			return err
+		}
+		for index := range resources {
+			data[index][relation] = related[resources[index].Id]
+		}
+	}
+	return nil
+}
+
+// expandOwner returns the user owning the resources, with the username of
+// local users
+func expandOwner(userId string, resourceIds []string) (map[string]interface{}, error) {
+	owner := map[string]interface{}{"id": userId}
+	if localAuth != nil {
+		user, err := localAuth.Users.GetUserById(userId)
+		if err != nil && !errors.Is(err, models.ErrNotFound) {
+			return nil, err
+		}
+		if err == nil {
+			owner["username"] = user.Username
+		}
+	}
+
+	related := make(map[string]interface{}, len(resourceIds))
+	for _, id := range resourceIds {
+		related[id] = owner
+	}
+	return related, nil
+}
@@ -0,0 +1,110 @@
+/*
+Copyright (c) 2015, Alberto Corsín Lafuente
+All rights reserved.
+
+Redistribution and use in source and binary forms, with or without
+modification, are permitted provided that the following conditions are met:
+
+1. Redistributions of source code must retain the above copyright notice,
+   this list of conditions and the following disclaimer.
+
+2. Redistributions in binary form must reproduce the above copyright notice,
+   this list of conditions and the following disclaimer in the documentation
+   and/or other materials provided with the distribution.
+
+3. Neither the name of the copyright holder nor the names of its contributors
+   may be used to endorse or promote products derived from this software
+   without specific prior written permission.
+
+THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND
+ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED
+WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE
+FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER
+CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY,
+OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+*/
+package api
+
+import (
+	"encoding/json"
+	"github.com/acorsinl/casimiro/auth"
+	"github.com/acorsinl/casimiro/models"
+	"github.com/acorsinl/casimiro/system"
+	"github.com/gorilla/mux"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestExpandOwner(t *testing.T) {
+	store := models.NewMemoryModel()
+	SetResourceStore(store)
+	SetLocalAuth(auth.NewLocal(store))
+	defer SetLocalAuth(nil)
+
+	alice := &models.User{Id: "alice-id", Username: "alice"}
+	if err := store.InsertUser(alice); err != nil {
+		t.Fatal(err)
+	}
+	for _, id := range []string{"r1", "r2"} {
+		if err := store.InsertResource(&models.Resource{Id: id, UserId: alice.Id, Href: system.ResourcesUrl + "/" + id}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := store.InsertResource(&models.Resource{Id: "r3", UserId: "bob-id", Href: system.ResourcesUrl + "/r3"}); err != nil {
+		t.Fatal(err)
+	}
+
+	r := mux.NewRouter()
+	r.HandleFunc(system.ResourcesUrl, GetResources).Methods("GET")
+	r.HandleFunc(system.ResourcesUrl+"/{resourceId}", GetResource).Methods("GET")
+	get := func(userId, url string) (int, []byte) {
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, system.WithUserId(httptest.NewRequest("GET", url, nil), userId))
+		return w.Code, w.Body.Bytes()
+	}
+
+	var page struct {
+		Data []map[string]interface{} `json:"data"`
+	}
+	code, body := get(alice.Id, system.ResourcesUrl+"?$expand=owner")
+	if code != http.StatusOK {
+		t.Fatalf("expected 200, got %d %s", code, body)
+	}
+	if err := json.Unmarshal(body, &page); err != nil {
+		t.Fatal(err)
+	}
+	if len(page.Data) != 2 {
+		t.Fatalf("expected 2 resources, got %v", page.Data)
+	}
+	for _, resource := range page.Data {
+		owner, _ := resource["owner"].(map[string]interface{})
+		if owner["id"] != alice.Id || owner["username"] != "alice" {
+			t.Errorf("unexpected owner of %v: %v", resource["id"], resource["owner"])
+		}
+	}
+
+	// Owners that aren't local users only have their id
+	var single struct {
+		Data map[string]interface{} `json:"data"`
+	}
+	code, body = get("bob-id", system.ResourcesUrl+"/r3?$expand=owner")
+	if code != http.StatusOK {
+		t.Fatalf("expected 200, got %d %s", code, body)
+	}
+	if err := json.Unmarshal(body, &single); err != nil {
+		t.Fatal(err)
+	}
+	owner, _ := single.Data["owner"].(map[string]interface{})
+	if owner["id"] != "bob-id" || owner["username"] != nil {
+		t.Errorf("unexpected owner %v", single.Data["owner"])
+	}
+
+	if code, _ = get(alice.Id, system.ResourcesUrl+"?$expand=unknown"); code != http.StatusBadRequest {
+		t.Errorf("expected 400 for an unknown relation, got %d", code)
+	}
+}
@@ -0,0 +1,368 @@
+/*
+Copyright (c) 2015, Alberto Corsín Lafuente
+All rights reserved.
+
+Redistribution and use in source and binary forms, with or without
+modification, are permitted provided that the following conditions are met:
+
+1. Redistributions of source code must retain the above copyright notice,
+   this list of conditions and the following disclaimer.
+
+2. Redistributions in binary form must reproduce the above copyright notice,
+   this list of conditions and the following disclaimer in the documentation
+   and/or other materials provided with the distribution.
+
+3. Neither the name of the copyright holder nor the names of its contributors
+   may be used to endorse or promote products derived from this software
+   without specific prior written permission.
+
+THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND
+ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED
+WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE
+FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER
+CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY,
+OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+*/
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"github.com/acorsinl/casimiro/auth"
+	"github.com/acorsinl/casimiro/models"
+	"github.com/acorsinl/casimiro/problem"
+	"github.com/acorsinl/casimiro/system"
+	"github.com/acorsinl/casimiro/validation"
+	"github.com/gorilla/mux"
+	"io/ioutil"
+	"net/http"
+	"reflect"
+	"strconv"
+)
+
+// Hooks lets a registered resource add business logic to the generic
+// handlers. Returning an error from a hook aborts the request with a 400
+// and the error text, or with the problem returned.
+type Hooks[T any] struct {
+	BeforeCreate func(r *http.Request, item *T) error
+	BeforeUpdate func(r *http.Request, item *T) error
+	BeforeDelete func(r *http.Request, id string) error
+}
+
+// RegisterResource serves the items of store under /name with the standard
+// REST methods, the same way the hand written /resources handlers do:
+//
+//	GET, POST, OPTIONS /name
+//	GET /name/$schema
+//	GET, PUT, PATCH, DELETE, OPTIONS /name/{id}
+//
+// T is serialized with encoding/json, the id and href of every item are
+// added to the output. Bodies are checked against the JSON Schema of T.
+func RegisterResource[T any](r *mux.Router, name string, store models.Store[T], hooks Hooks[T]) {
+	handler := &resourceHandler[T]{url: "/" + name, store: store, hooks: hooks}
+
+	r.HandleFunc(handler.url, handler.list).Methods("GET")
+	r.HandleFunc(handler.url, handler.create).Methods("POST")
+	r.HandleFunc(handler.url, handler.options).Methods("OPTIONS")
+	r.HandleFunc(handler.url+"/$schema", handler.schema).Methods("GET")
+	r.HandleFunc(handler.url+"/{id}", handler.get).Methods("GET")
+	r.HandleFunc(handler.url+"/{id}", handler.update).Methods("PUT")
+	r.HandleFunc(handler.url+"/{id}", handler.patch).Methods("PATCH")
+	r.HandleFunc(handler.url+"/{id}", handler.delete).Methods("DELETE")
+	r.HandleFunc(handler.url+"/{id}", handler.options).Methods("OPTIONS")
+}
+
+type resourceHandler[T any] struct {
+	url   string
+	store models.Store[T]
+	hooks Hooks[T]
+}
+
+func (h *resourceHandler[T]) list(w http.ResponseWriter, r *http.Request) {
+	userId := system.UserId(r)
+	queryParams, err := system.GetQueryParameters(r.RequestURI)
+	if err != nil {
+		problem.Write(w, r, problem.BadRequest(err.Error()))
+		return
+	}
+
+	offset, limit, err := system.GetPagingParameters(queryParams)
+	if err != nil {
+		problem.Write(w, r, problem.BadRequest(err.Error()))
+		return
+	}
+
+	// One more item than needed tells whether there is a next page
+	items, err := h.store.List(userId, offset, limit+1)
+	if err != nil {
+		problem.Write(w, r, storeProblem(err))
+		return
+	}
+	more := len(items) > limit
+	if more {
+		items = items[:limit]
+	}
+
+	output := system.APIMultipleOutput{}
+	output.Data = make([]map[string]interface{}, len(items))
+	for index := range items {
+		if output.Data[index], err = h.data(&items[index]); err != nil {
+			problem.Write(w, r, storeProblem(err))
+			return
+		}
+	}
+	output.Paging = make(map[string]interface{})
+	output.Paging["offset"] = offset
+	output.Paging["limit"] = limit
+	output.Links = map[string]string{"first": system.PageLink(r, nil)}
+	if offset > 0 {
+		prev := offset - limit
+		if prev < 0 {
+			prev = 0
+		}
+		output.Links["prev"] = system.PageLink(r, map[string]string{"$offset": strconv.Itoa(prev)})
+	}
+	if more {
+		output.Links["next"] = system.PageLink(r, map[string]string{"$offset": strconv.Itoa(offset + limit)})
+	}
+	system.APIMultipleResults(http.StatusOK, "OK", output, w)
+}
+
+func (h *resourceHandler[T]) create(w http.ResponseWriter, r *http.Request) {
+	item := new(T)
+	userId := system.UserId(r)
+
+	if err := decodeBody(r, item); err != nil {
+		problem.Write(w, r, err)
+		return
+	}
+	models.SetItemId(item, system.NewUUID())
+
+	if h.hooks.BeforeCreate != nil {
+		if err := h.hooks.BeforeCreate(r, item); err != nil {
+			problem.Write(w, r, hookProblem(err))
+			return
+		}
+	}
+
+	err := retry(func() error {
+		return h.store.Insert(userId, item)
+	})
+	if err != nil {
+		problem.Write(w, r, storeProblem(err))
+		return
+	}
+
+	h.single(http.StatusCreated, "Resource added", item, w, r)
+}
+
+func (h *resourceHandler[T]) get(w http.ResponseWriter, r *http.Request) {
+	userId := system.UserId(r)
+	id := mux.Vars(r)["id"]
+
+	item, err := h.store.GetById(userId, id)
+	if err != nil {
+		problem.Write(w, r, storeProblem(err))
+		return
+	}
+
+	h.single(http.StatusOK, "OK", item, w, r)
+}
+
+// update replaces the fields of an item clients can send, the ones hidden
+// from encoding/json keep their stored values
+func (h *resourceHandler[T]) update(w http.ResponseWriter, r *http.Request) {
+	item := new(T)
+	userId := system.UserId(r)
+
+	if err := decodeBody(r, item); err != nil {
+		problem.Write(w, r, err)
+		return
+	}
+	id := mux.Vars(r)["id"]
+	models.SetItemId(item, id)
+
+	modify := func(current *T) error {
+		replacement := *item
+		keepHidden(&replacement, current)
+		if h.hooks.BeforeUpdate != nil {
+			if err := h.hooks.BeforeUpdate(r, &replacement); err != nil {
+				return hookProblem(err)
+			}
+		}
+		*current = replacement
+		return nil
+	}
+
+	var updated *T
+	err := retry(func() (err error) {
+		updated, err = h.store.Modify(userId, id, modify)
+		return err
+	})
+	if err != nil {
+		problem.Write(w, r, storeProblem(err))
+		return
+	}
+
+	h.single(http.StatusOK, "Resource modified", updated, w, r)
+}
+
+// patch applies a JSON Merge Patch (RFC 7396) to an item, the result goes
+// through the BeforeUpdate hook like a full update. The item is modified
+// atomically by the store, so concurrent patches don't lose updates.
+func (h *resourceHandler[T]) patch(w http.ResponseWriter, r *http.Request) {
+	userId := system.UserId(r)
+	id := mux.Vars(r)["id"]
+
+	if system.ContentType(r) != system.MergePatchContentType {
+		problem.Write(w, r, problem.UnsupportedMediaType("Content-Type must be "+system.MergePatchContentType))
+		return
+	}
+
+	patch, err := ioutil.ReadAll(r.Body)
+	r.Body.Close()
+	if err != nil {
+		problem.Write(w, r, problem.BadRequest(err.Error()))
+		return
+	}
+
+	modify := func(current *T) error {
+		original, err := h.data(current)
+		if err != nil {
+			return err
+		}
+		document, err := json.Marshal(original)
+		if err != nil {
+			return err
+		}
+
+		patched, err := system.MergePatch(document, patch)
+		if err != nil {
+			return problem.BadRequest("Invalid merge patch: " + err.Error())
+		}
+
+		// The id and href are managed by the server, as in a full update
+		var fields map[string]interface{}
+		if err = json.Unmarshal(patched, &fields); err != nil {
+			return problem.BadRequest("Invalid merge patch: " + err.Error())
+		}
+		delete(fields, "id")
+		delete(fields, "href")
+		if patched, err = json.Marshal(fields); err != nil {
+			return err
+		}
+
+		item := new(T)
+		if err = decodeDocument(patched, item); err != nil {
+			return err
+		}
+		models.SetItemId(item, id)
+		keepHidden(item, current)
+
+		if h.hooks.BeforeUpdate != nil {
+			if err := h.hooks.BeforeUpdate(r, item); err != nil {
+				return hookProblem(err)
+			}
+		}
+		*current = *item
+		return nil
+	}
+
+	var item *T
+	err = retry(func() (err error) {
+		item, err = h.store.Modify(userId, id, modify)
+		return err
+	})
+	if err != nil {
+		problem.Write(w, r, storeProblem(err))
+		return
+	}
+
+	h.single(http.StatusOK, "Resource modified", item, w, r)
+}
+
+func (h *resourceHandler[T]) delete(w http.ResponseWriter, r *http.Request) {
+	userId := system.UserId(r)
+	id := mux.Vars(r)["id"]
+
+	if h.hooks.BeforeDelete != nil {
+		if err := h.hooks.BeforeDelete(r, id); err != nil {
+			problem.Write(w, r, hookProblem(err))
+			return
+		}
+	}
+
+	err := retry(func() error {
+		return h.store.Delete(userId, id)
+	})
+	if err != nil {
+		problem.Write(w, r, storeProblem(err))
+		return
+	}
+
+	system.APIReturn(http.StatusOK, "Resource deleted", w)
+}
+
+// keepHidden copies to item the fields of current that aren't serialized
+// with encoding/json, as clients can't send them
+func keepHidden[T any](item, current *T) {
+	v := reflect.ValueOf(item).Elem()
+	stored := reflect.ValueOf(current).Elem()
+	for i := 0; i < v.NumField(); i++ {
+		field := v.Type().Field(i)
+		if field.PkgPath == "" && field.Tag.Get("json") == "-" {
+			v.Field(i).Set(stored.Field(i))
+		}
+	}
+}
+
+// hookProblem returns the problem to answer when a hook fails
+func hookProblem(err error) error {
+	var hookErr *problem.Problem
+	if errors.As(err, &hookErr) {
+		return hookErr
+	}
+	return problem.BadRequest(err.Error())
+}
+
+func (h *resourceHandler[T]) options(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Access-Control-Allow-Methods", "POST, GET, OPTIONS, PUT, PATCH, DELETE")
+	w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Content-Length, Accept-Encoding, Authorization, "+auth.APIKeyHeader+", "+system.UserHeader)
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	w.Header().Set("Accept-Patch", system.MergePatchContentType)
+	writeSchema(w, h.url, validation.SchemaOf(new(T)))
+}
+
+func (h *resourceHandler[T]) schema(w http.ResponseWriter, r *http.Request) {
+	writeSchema(w, h.url, validation.SchemaOf(new(T)))
+}
+
+func (h *resourceHandler[T]) single(code int, info string, item *T, w http.ResponseWriter, r *http.Request) {
+	data, err := h.data(item)
+	if err != nil {
+		problem.Write(w, r, storeProblem(err))
+		return
+	}
+	system.APISingleResult(code, info, data, w)
+}
+
+// data returns the JSON representation of item as a map, with its id and href
+func (h *resourceHandler[T]) data(item *T) (map[string]interface{}, error) {
+	var data map[string]interface{}
+	content, err := json.Marshal(item)
+	if err != nil {
+		return nil, err
+	}
+	if err = json.Unmarshal(content, &data); err != nil {
+		return nil, err
+	}
+
+	id := models.ItemId(item)
+	data["id"] = id
+	data["href"] = h.url + "/" + id
+	return data, nil
+}
@@ -0,0 +1,169 @@
+/*
+Copyright (c) 2015, Alberto Corsín Lafuente
+All rights reserved.
+
+Redistribution and use in source and binary forms, with or without
+modification, are permitted provided that the following conditions are met:
+
+1. Redistributions of source code must retain the above copyright notice,
+   this list of conditions and the following disclaimer.
+
+2. Redistributions in binary form must reproduce the above copyright notice,
+   this list of conditions and the following disclaimer in the documentation
+   and/or other materials provided with the distribution.
+
+3. Neither the name of the copyright holder nor the names of its contributors
+   may be used to endorse or promote products derived from this software
+   without specific prior written permission.
+
+THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND
+ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED
+WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE
+FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER
+CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY,
+OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+*/
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/acorsinl/casimiro/models"
+	"github.com/acorsinl/casimiro/system"
+	"github.com/gorilla/mux"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+)
+
+type gadget struct {
+	Id     string            `db:"id" json:"-"`
+	Name   string            `db:"name" json:"name" validate:"required"`
+	Tags   map[string]string `db:"tags" json:"tags"`
+	Secret string            `db:"secret" json:"-"`
+}
+
+func gadgetRouter() *mux.Router {
+	r, _ := gadgetStore()
+	return r
+}
+
+// gadgetStore returns a router serving gadgets along with their store
+func gadgetStore() (*mux.Router, models.Store[gadget]) {
+	r := mux.NewRouter()
+	store := models.NewMemoryTable[gadget]()
+	RegisterResource(r, "gadgets", store, Hooks[gadget]{})
+	return r, store
+}
+
+// serveGadgets sends a request to router as alice and decodes the data of
+// the response
+func serveGadgets(t *testing.T, router http.Handler, method, url, contentType, body string) (int, map[string]interface{}) {
+	r := httptest.NewRequest(method, url, strings.NewReader(body))
+	r.Header.Set("Content-Type", contentType)
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, system.WithUserId(r, "alice"))
+
+	var output struct {
+		Data map[string]interface{} `json:"data"`
+	}
+	if w.Code < 300 {
+		if err := json.Unmarshal(w.Body.Bytes(), &output); err != nil {
+			t.Fatalf("%s %s: %v", method, url, err)
+		}
+	}
+	return w.Code, output.Data
+}
+
+func TestRegisteredResource(t *testing.T) {
+	router := gadgetRouter()
+
+	code, created := serveGadgets(t, router, "POST", "/gadgets", "application/json", `{"name": "bolt"}`)
+	if code != http.StatusCreated {
+		t.Fatalf("expected 201, got %d", code)
+	}
+	id := created["id"].(string)
+	url := "/gadgets/" + id
+	if created["href"] != url {
+		t.Errorf("expected href %s, got %v", url, created["href"])
+	}
+
+	code, patched := serveGadgets(t, router, "PATCH", url, system.MergePatchContentType, `{"name": "nut", "href": "/elsewhere", "id": "other"}`)
+	if code != http.StatusOK || patched["name"] != "nut" || patched["href"] != url || patched["id"] != id {
+		t.Errorf("unexpected patch answer %d %v", code, patched)
+	}
+
+	code, stored := serveGadgets(t, router, "GET", url, "", "")
+	if code != http.StatusOK || stored["name"] != "nut" || stored["href"] != url {
+		t.Errorf("unexpected get answer %d %v", code, stored)
+	}
+
+	if code, _ = serveGadgets(t, router, "PATCH", url, system.MergePatchContentType, `{"name": null}`); code != http.StatusUnprocessableEntity {
+		t.Errorf("expected 422 for an invalid patch, got %d", code)
+	}
+	if code, _ = serveGadgets(t, router, "PATCH", "/gadgets/missing", system.MergePatchContentType, `{"name": "nut"}`); code != http.StatusNotFound {
+		t.Errorf("expected 404 patching a missing gadget, got %d", code)
+	}
+	if code, _ = serveGadgets(t, router, "DELETE", url, "", ""); code != http.StatusOK {
+		t.Errorf("expected 200 deleting, got %d", code)
+	}
+	if code, _ = serveGadgets(t, router, "GET", url, "", ""); code != http.StatusNotFound {
+		t.Errorf("expected 404 after deleting, got %d", code)
+	}
+}
+
+func TestConcurrentPatches(t *testing.T) {
+	router := gadgetRouter()
+	_, created := serveGadgets(t, router, "POST", "/gadgets", "application/json", `{"name": "bolt", "tags": {}}`)
+	url := "/gadgets/" + created["id"].(string)
+
+	// Every patch adds its own tag, none of them may be lost
+	var wg sync.WaitGroup
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			body := fmt.Sprintf(`{"tags": {"t%d": "x"}}`, i)
+			if code, _ := serveGadgets(t, router, "PATCH", url, system.MergePatchContentType, body); code != http.StatusOK {
+				t.Errorf("patch %d answered %d", i, code)
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	_, stored := serveGadgets(t, router, "GET", url, "", "")
+	if tags, _ := stored["tags"].(map[string]interface{}); len(tags) != 20 {
+		t.Errorf("expected 20 tags, got %v", stored["tags"])
+	}
+}
+
+func TestUpdatesKeepHiddenFields(t *testing.T) {
+	router, store := gadgetStore()
+	if err := store.Insert("alice", &gadget{Id: "w1", Name: "bolt", Secret: "s3cr3t"}); err != nil {
+		t.Fatal(err)
+	}
+
+	if code, _ := serveGadgets(t, router, "PATCH", "/gadgets/w1", system.MergePatchContentType, `{"name": "nut", "Secret": "x"}`); code != http.StatusOK {
+		t.Fatalf("expected 200 patching, got %d", code)
+	}
+	if stored, _ := store.GetById("alice", "w1"); stored.Name != "nut" || stored.Secret != "s3cr3t" {
+		t.Errorf("unexpected gadget after patch %+v", stored)
+	}
+
+	if code, _ := serveGadgets(t, router, "PUT", "/gadgets/w1", "application/json", `{"name": "washer"}`); code != http.StatusOK {
+		t.Fatalf("expected 200 updating, got %d", code)
+	}
+	if stored, _ := store.GetById("alice", "w1"); stored.Name != "washer" || stored.Secret != "s3cr3t" {
+		t.Errorf("unexpected gadget after update %+v", stored)
+	}
+
+	if code, _ := serveGadgets(t, router, "PUT", "/gadgets/missing", "application/json", `{"name": "washer"}`); code != http.StatusNotFound {
+		t.Errorf("expected 404 updating a missing gadget, got %d", code)
+	}
+}
@@ -30,11 +30,17 @@ OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
 package api
 
 import (
+	"encoding/json"
+	"errors"
+	"github.com/acorsinl/casimiro/auth"
 	"github.com/acorsinl/casimiro/models"
+	"github.com/acorsinl/casimiro/problem"
 	"github.com/acorsinl/casimiro/system"
+	"github.com/acorsinl/casimiro/validation"
 	"github.com/gorilla/mux"
+	"io/ioutil"
 	"net/http"
-	"strconv"
+	"net/url"
 )
 
 type Resource struct {
@@ -42,60 +48,222 @@ type Resource struct {
 	Href string `json:"href,omitempty"`
 }
 
-// GetResources retrieves all resources for the current logged user
+var resourceStore models.ResourceStore
+
+// SetResourceStore sets the storage backend used by the resource handlers
+func SetResourceStore(store models.ResourceStore) {
+	resourceStore = store
+}
+
+// GetResources retrieves all resources for the current logged user. Pages
+// are selected with $offset or with the $after/$before cursors returned in
+// the paging block, $count=true adds the total number of resources and
+// $filter restricts both to the resources matching it. $orderby sorts by
+// models.ResourceSortable fields, always ending with id. $select lists the
+// fields to return and $expand inlines their owner or the relations added
+// with ExpandResources.
 func GetResources(w http.ResponseWriter, r *http.Request) {
-	var model models.Model
-	var offset, limit int
-	userId := r.Header.Get(system.UserHeader)
+	userId := system.UserId(r)
 	queryParams, err := system.GetQueryParameters(r.RequestURI)
 	if err != nil {
-		system.APIReturn(http.StatusInternalServerError, err.Error(), w)
+		problem.Write(w, r, problem.BadRequest(err.Error()))
+		return
+	}
+
+	offset, limit, err := system.GetPagingParameters(queryParams)
+	if err != nil {
+		problem.Write(w, r, problem.BadRequest(err.Error()))
 		return
 	}
 
-	if queryParams.Get("$offset") == "" || queryParams.Get("$limit") == "" {
-		offset = system.PagingOffset
-		limit = system.PagingLimit
-	} else {
-		offset, _ = strconv.Atoi(queryParams.Get("$offset"))
-		limit, _ = strconv.Atoi(queryParams.Get("$limit"))
+	var order []system.OrderBy
+	if orderBy := queryParams.Get("$orderby"); orderBy != "" {
+		if order, err = system.ParseOrderBy(orderBy, models.ResourceSortable); err != nil {
+			problem.Write(w, r, problem.BadRequest(err.Error()))
+			return
+		}
 	}
+	order = models.ResourceOrder(order)
 
-	resources, err := model.GetResources(userId, offset, limit)
+	fields, err := selectParameter(queryParams)
 	if err != nil {
-		system.APIReturn(http.StatusInternalServerError, err.Error(), w)
+		problem.Write(w, r, problem.BadRequest(err.Error()))
+		return
+	}
+	relations, err := expandParameter(queryParams)
+	if err != nil {
+		problem.Write(w, r, problem.BadRequest(err.Error()))
 		return
 	}
 
+	// One more row than needed tells whether there is a next page
+	list := models.ListQuery{Offset: offset, Limit: limit + 1, Order: order, Select: fields}
+	if list.After, err = cursorParameter(queryParams, "$after", order); err != nil {
+		problem.Write(w, r, problem.BadRequest("Invalid $after cursor"))
+		return
+	}
+	if list.Before, err = cursorParameter(queryParams, "$before", order); err != nil {
+		problem.Write(w, r, problem.BadRequest("Invalid $before cursor"))
+		return
+	}
+	if list.After != nil && list.Before != nil {
+		problem.Write(w, r, problem.BadRequest("$after and $before can't be used together"))
+		return
+	}
+	if filter := queryParams.Get("$filter"); filter != "" {
+		if list.Filter, err = system.ParseFilter(filter, models.ResourceFilters); err != nil {
+			problem.Write(w, r, problem.BadRequest(err.Error()))
+			return
+		}
+	}
+
+	resources, err := resourceStore.GetResources(userId, list)
+	if err != nil {
+		problem.Write(w, r, storeProblem(err))
+		return
+	}
+
+	more := len(resources) > limit
+	if more && list.Before != nil {
+		resources = resources[1:]
+	} else if more {
+		resources = resources[:limit]
+	}
+
 	output := system.APIMultipleOutput{}
 	output.Data = make([]map[string]interface{}, len(resources))
 	for index := range resources {
-		output.Data[index] = make(map[string]interface{})
-		output.Data[index]["id"] = resources[index].Id
-		output.Data[index]["href"] = resources[index].Href
+		output.Data[index] = resources[index].Data(fields)
+	}
+	if err = expand(userId, relations, resources, output.Data); err != nil {
+		problem.Write(w, r, storeProblem(err))
+		return
 	}
 	output.Paging = make(map[string]interface{})
-	output.Paging["offset"] = system.PagingOffset
-	output.Paging["limit"] = system.PagingLimit
+	output.Paging["limit"] = limit
+	if list.After == nil && list.Before == nil {
+		output.Paging["offset"] = offset
+	}
+
+	end, _ := system.EncodeCursor(&models.Cursor{End: true})
+	output.Links = map[string]string{
+		"first": system.PageLink(r, nil),
+		"last":  system.PageLink(r, map[string]string{"$before": end}),
+	}
+	if len(resources) > 0 {
+		hasNext := (list.Before != nil && !list.Before.End) || (list.Before == nil && more)
+		hasPrev := list.After != nil || (list.Before != nil && more) || (list.Before == nil && offset > 0)
+		if hasNext {
+			next, _ := system.EncodeCursor(models.CursorOf(&resources[len(resources)-1], order))
+			output.Paging["next"] = next
+			output.Links["next"] = system.PageLink(r, map[string]string{"$after": next})
+		}
+		if hasPrev {
+			prev, _ := system.EncodeCursor(models.CursorOf(&resources[0], order))
+			output.Paging["prev"] = prev
+			output.Links["prev"] = system.PageLink(r, map[string]string{"$before": prev})
+		}
+	}
+
+	if queryParams.Get("$count") == "true" {
+		count, err := resourceStore.CountResources(userId, list.Filter)
+		if err != nil {
+			problem.Write(w, r, storeProblem(err))
+			return
+		}
+		output.Paging["count"] = count
+	}
+	system.APIMultipleResults(http.StatusOK, "OK", output, w)
+}
+
+// AggregateResources computes the $aggregate values (count by default) of
+// the resources of the current user matching $filter, one result for each
+// distinct value of the $groupby fields.
+func AggregateResources(w http.ResponseWriter, r *http.Request) {
+	var query models.AggregateQuery
+	userId := system.UserId(r)
+	queryParams, err := system.GetQueryParameters(r.RequestURI)
+	if err != nil {
+		problem.Write(w, r, problem.BadRequest(err.Error()))
+		return
+	}
+
+	if filter := queryParams.Get("$filter"); filter != "" {
+		if query.Filter, err = system.ParseFilter(filter, models.ResourceFilters); err != nil {
+			problem.Write(w, r, problem.BadRequest(err.Error()))
+			return
+		}
+	}
+	if groupBy := queryParams.Get("$groupby"); groupBy != "" {
+		if query.GroupBy, err = system.ParseFieldList("$groupby", groupBy, models.ResourceGroupable); err != nil {
+			problem.Write(w, r, problem.BadRequest(err.Error()))
+			return
+		}
+	}
+	aggregate := queryParams.Get("$aggregate")
+	if aggregate == "" {
+		aggregate = "count"
+	}
+	if query.Aggregates, err = system.ParseAggregates(aggregate, models.ResourceAggregatable); err != nil {
+		problem.Write(w, r, problem.BadRequest(err.Error()))
+		return
+	}
+	for _, value := range query.Aggregates {
+		if models.ResourceGroupable[value.Alias] {
+			problem.Write(w, r, problem.BadRequest("$aggregate alias "+value.Alias+" is a field name"))
+			return
+		}
+	}
+
+	results, err := resourceStore.AggregateResources(userId, query)
+	if err != nil {
+		problem.Write(w, r, storeProblem(err))
+		return
+	}
+
+	output := system.APIMultipleOutput{}
+	output.Data = results
+	if output.Data == nil {
+		output.Data = []map[string]interface{}{}
+	}
+	output.Paging = map[string]interface{}{"count": len(results)}
 	system.APIMultipleResults(http.StatusOK, "OK", output, w)
 }
 
+// cursorParameter decodes the cursor given in the name query parameter, if
+// any, checking it belongs to a listing sorted by order
+func cursorParameter(queryParams url.Values, name string, order []system.OrderBy) (*models.Cursor, error) {
+	var cursor models.Cursor
+
+	token := queryParams.Get(name)
+	if token == "" {
+		return nil, nil
+	}
+	if err := system.DecodeCursor(token, &cursor); err != nil {
+		return nil, err
+	}
+	return &cursor, cursor.Bind(order)
+}
+
 // AddResource creates a new resource owned by the current user
 func AddResource(w http.ResponseWriter, r *http.Request) {
-	var model models.Model
-	var resource *models.Resource
-	var err error
+	resource := new(models.Resource)
+	userId := system.UserId(r)
 
-	if err = system.DecodeJSON(r, &resource); err != nil {
-		system.APIReturn(http.StatusInternalServerError, err.Error(), w)
+	err := decodeBody(r, resource)
+	if err != nil {
+		problem.Write(w, r, err)
 		return
 	}
 
 	resource.Id = system.NewUUID()
+	resource.UserId = userId
 
-	err = model.InsertResource(resource)
+	err = retry(func() error {
+		return resourceStore.InsertResource(resource)
+	})
 	if err != nil {
-		system.APIReturn(http.StatusInternalServerError, err.Error(), w)
+		problem.Write(w, r, storeProblem(err))
 		return
 	}
 
@@ -106,54 +274,72 @@ func AddResource(w http.ResponseWriter, r *http.Request) {
 }
 
 // GetResource retrieves a resource owned by the current user given
-// its resource Id.
+// its resource Id. $select and $expand work as in GetResources.
 func GetResource(w http.ResponseWriter, r *http.Request) {
-	var model models.Model
-	userId := r.Header.Get(system.UserHeader)
+	userId := system.UserId(r)
 	resourceId := mux.Vars(r)["resourceId"]
 
 	/*resource, err := getResource(userId, resourceId)
 	if err != nil {
-		system.APIReturn(http.StatusInternalServerError, err.Error(), w)
+		problem.Write(w, r, storeProblem(err))
 		return
 	}
 	if resource == nil {
-		system.APIReturn(http.StatusNotFound, "Not found", w)
+		problem.Write(w, r, problem.NotFound("Not found"))
 		return
 	}*/
 
-	resource, err := model.GetResourceById(userId, resourceId)
+	queryParams, err := system.GetQueryParameters(r.RequestURI)
+	if err != nil {
+		problem.Write(w, r, problem.BadRequest(err.Error()))
+		return
+	}
+	fields, err := selectParameter(queryParams)
+	if err != nil {
+		problem.Write(w, r, problem.BadRequest(err.Error()))
+		return
+	}
+	relations, err := expandParameter(queryParams)
 	if err != nil {
-		system.APIReturn(http.StatusInternalServerError, err.Error(), w)
+		problem.Write(w, r, problem.BadRequest(err.Error()))
+		return
+	}
+
+	resource, err := resourceStore.GetResourceById(userId, resourceId)
+	if err != nil {
+		problem.Write(w, r, storeProblem(err))
 		return
 	}
 
 	resource.Href = system.ResourcesUrl + "/" + resource.Id
-	data := make(map[string]interface{})
-	data["href"] = resource.Href
-	data["id"] = resource.Id
-	system.APISingleResult(http.StatusOK, "OK", data, w)
+	data := []map[string]interface{}{resource.Data(fields)}
+	if err = expand(userId, relations, []models.Resource{*resource}, data); err != nil {
+		problem.Write(w, r, storeProblem(err))
+		return
+	}
+	system.APISingleResult(http.StatusOK, "OK", data[0], w)
 }
 
 // UpdateResource allows to full update a record in the database
 func UpdateResource(w http.ResponseWriter, r *http.Request) {
-	var model models.Model
-	var resource *models.Resource
-	userId := r.Header.Get(system.UserHeader)
+	resource := new(models.Resource)
+	userId := system.UserId(r)
 	resourceId := mux.Vars(r)["resourceId"]
 
-	err := system.DecodeJSON(r, &resource)
+	err := decodeBody(r, resource)
 	if err != nil {
-		system.APIReturn(http.StatusInternalServerError, err.Error(), w)
+		problem.Write(w, r, err)
 		return
 	}
 
 	resource.Id = resourceId
 	resource.Href = system.ResourcesUrl + "/" + resource.Id
 
-	err = model.UpdateResource(resource, userId)
+	err = retry(func() error {
+		return resourceStore.UpdateResource(resource, userId)
+	})
 	if err != nil {
-		system.APIReturn(http.StatusInternalServerError, err.Error(), w)
+		problem.Write(w, r, storeProblem(err))
 		return
 	}
 
@@ -164,29 +350,116 @@ func UpdateResource(w http.ResponseWriter, r *http.Request) {
 }
 
 // PatchResource allows partial updates of a given resource owned
-// by the current user
+// by the current user. The body can be a JSON Merge Patch (RFC 7396) or a
+// JSON Patch (RFC 6902), which is applied atomically.
 func PatchResource(w http.ResponseWriter, r *http.Request) {
-	system.APIReturn(http.StatusNotImplemented, "Patch method not implemented yet", w)
+	var apply func(document, patch []byte) ([]byte, error)
+	userId := system.UserId(r)
+	resourceId := mux.Vars(r)["resourceId"]
+
+	switch system.ContentType(r) {
+	case system.MergePatchContentType:
+		apply = system.MergePatch
+	case system.JSONPatchContentType:
+		apply = system.ApplyJSONPatch
+	default:
+		problem.Write(w, r, problem.UnsupportedMediaType("Content-Type must be "+system.MergePatchContentType+" or "+system.JSONPatchContentType))
+		return
+	}
+
+	patch, err := ioutil.ReadAll(r.Body)
+	r.Body.Close()
+	if err != nil {
+		problem.Write(w, r, problem.BadRequest(err.Error()))
+		return
+	}
+
+	modify := func(current *models.Resource) error {
+		original, err := json.Marshal(current)
+		if err != nil {
+			return err
+		}
+
+		patched, err := apply(original, patch)
+		if err != nil {
+			return err
+		}
+
+		var resource models.Resource
+		if err = decodeDocument(patched, &resource); err != nil {
+			return err
+		}
+		if resource.Id != current.Id {
+			return problem.Validation("Resource id can't be modified", problem.FieldError{Field: "id", Message: "can't be modified"})
+		}
+
+		// Ownership, href and timestamps are managed by the server
+		resource.UserId = current.UserId
+		resource.Href = system.ResourcesUrl + "/" + current.Id
+		resource.CreatedAt = current.CreatedAt
+		resource.UpdatedAt = current.UpdatedAt
+		*current = resource
+		return nil
+	}
+
+	var resource *models.Resource
+	err = retry(func() (err error) {
+		resource, err = resourceStore.ModifyResource(userId, resourceId, modify)
+		return err
+	})
+	if err != nil {
+		problem.Write(w, r, patchProblem(err))
+		return
+	}
+
+	data := make(map[string]interface{})
+	data["href"] = resource.Href
+	data["id"] = resource.Id
+	system.APISingleResult(http.StatusOK, "Resource modified", data, w)
+}
+
+// patchProblem returns the problem to answer for an error applying a patch
+func patchProblem(err error) error {
+	var syntaxErr *json.SyntaxError
+
+	switch {
+	case errors.Is(err, system.ErrPatchTestFailed):
+		return problem.Conflict(err.Error())
+	case errors.Is(err, system.ErrPatchPath):
+		return problem.Validation(err.Error())
+	case errors.Is(err, system.ErrInvalidPatch), errors.As(err, &syntaxErr):
+		return problem.BadRequest("Invalid patch: " + err.Error())
+	}
+	return storeProblem(err)
 }
 
 // DeleteResource deletes a given resource owned by the current user
 func DeleteResource(w http.ResponseWriter, r *http.Request) {
-	var model models.Model
-	userId := r.Header.Get(system.UserHeader)
+	userId := system.UserId(r)
 	resourceId := mux.Vars(r)["resourceId"]
 
-	err := model.DeleteResourceById(userId, resourceId)
+	err := retry(func() error {
+		return resourceStore.DeleteResourceById(userId, resourceId)
+	})
 	if err != nil {
-		system.APIReturn(http.StatusInternalServerError, "Resource not deleted", w)
+		problem.Write(w, r, storeProblem(err))
 		return
 	}
 
 	system.APIReturn(http.StatusOK, "Resource deleted", w)
 }
 
-// ResourceOptions returns the Access-Control tier headers for this API resource
+// ResourceOptions returns the Access-Control tier headers for this API
+// resource, along with the JSON Schema of its bodies
 func ResourceOptions(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Access-Control-Allow-Methods", "POST, GET, OPTIONS, PUT, PATCH, DELETE")
-	w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Content-Length, Accept-Encoding, "+system.UserHeader)
+	w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Content-Length, Accept-Encoding, Authorization, "+auth.APIKeyHeader+", "+system.UserHeader)
 	w.Header().Set("Access-Control-Allow-Origin", "*")
+	w.Header().Set("Accept-Patch", system.MergePatchContentType+", "+system.JSONPatchContentType)
+	writeSchema(w, system.ResourcesUrl, validation.SchemaOf(models.Resource{}))
+}
+
+// ResourceSchema returns the JSON Schema resources are validated against
+func ResourceSchema(w http.ResponseWriter, r *http.Request) {
+	writeSchema(w, system.ResourcesUrl, validation.SchemaOf(models.Resource{}))
 }
@@ -0,0 +1,402 @@
+/*
+Copyright (c) 2015, Alberto Corsín Lafuente
+All rights reserved.
+
+Redistribution and use in source and binary forms, with or without
+modification, are permitted provided that the following conditions are met:
+
+1. Redistributions of source code must retain the above copyright notice,
+   this list of conditions and the following disclaimer.
+
+2. Redistributions in binary form must reproduce the above copyright notice,
+   this list of conditions and the following disclaimer in the documentation
+   and/or other materials provided with the distribution.
+
+3. Neither the name of the copyright holder nor the names of its contributors
+   may be used to endorse or promote products derived from this software
+   without specific prior written permission.
+
+THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND
+ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED
+WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE
+FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER
+CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY,
+OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+*/
+package api
+
+import (
+	"encoding/json"
+	"github.com/acorsinl/casimiro/models"
+	"github.com/acorsinl/casimiro/system"
+	"github.com/acorsinl/casimiro/validation"
+	"github.com/gorilla/mux"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// resourcesRouter serves the resource handlers from a memory store holding
+// r1 to r5 for alice, created in that order, and r6 for bob
+func resourcesRouter(t *testing.T) (*mux.Router, *models.MemoryModel) {
+	store := models.NewMemoryModel()
+	SetResourceStore(store)
+	for _, id := range []string{"r1", "r2", "r3", "r4", "r5"} {
+		if err := store.InsertResource(&models.Resource{Id: id, UserId: "alice", Href: system.ResourcesUrl + "/" + id}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := store.InsertResource(&models.Resource{Id: "r6", UserId: "bob", Href: system.ResourcesUrl + "/r6"}); err != nil {
+		t.Fatal(err)
+	}
+
+	r := mux.NewRouter()
+	r.HandleFunc(system.ResourcesUrl, GetResources).Methods("GET")
+	r.HandleFunc(system.ResourcesUrl+"/$aggregate", AggregateResources).Methods("GET")
+	r.HandleFunc(system.ResourcesUrl+"/{resourceId}", GetResource).Methods("GET")
+	r.HandleFunc(system.ResourcesUrl+"/{resourceId}", PatchResource).Methods("PATCH")
+	return r, store
+}
+
+// resourcesPage is the output of a listing
+type resourcesPage struct {
+	Data   []map[string]interface{} `json:"data"`
+	Paging map[string]interface{}   `json:"paging"`
+	Links  map[string]string        `json:"-"`
+}
+
+func (p *resourcesPage) ids() []string {
+	var ids []string
+	for _, resource := range p.Data {
+		id, _ := resource["id"].(string)
+		ids = append(ids, id)
+	}
+	return ids
+}
+
+// getPage sends a GET to router as alice, decoding the listing and its Link
+// header when it succeeds
+func getPage(t *testing.T, router http.Handler, url string) (int, *resourcesPage) {
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, system.WithUserId(httptest.NewRequest("GET", url, nil), "alice"))
+
+	page := &resourcesPage{Links: make(map[string]string)}
+	if w.Code != http.StatusOK {
+		return w.Code, page
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), page); err != nil {
+		t.Fatalf("GET %s: %v", url, err)
+	}
+	for _, link := range strings.Split(w.Header().Get("Link"), ", ") {
+		parts := strings.SplitN(link, "; rel=", 2)
+		if len(parts) == 2 {
+			page.Links[strings.Trim(parts[1], `"`)] = strings.Trim(parts[0], "<>")
+		}
+	}
+	return w.Code, page
+}
+
+func TestResourceLinks(t *testing.T) {
+	router, _ := resourcesRouter(t)
+	url := system.ResourcesUrl + "?$limit=2&$filter=" + "startswith(id,%20'r')"
+
+	pages := [][]string{{"r1", "r2"}, {"r3", "r4"}, {"r5"}}
+	for i, expected := range pages {
+		code, page := getPage(t, router, url)
+		if code != http.StatusOK {
+			t.Fatalf("page %d: expected 200, got %d", i, code)
+		}
+		if strings.Join(page.ids(), ",") != strings.Join(expected, ",") {
+			t.Fatalf("page %d: expected %v, got %v", i, expected, page.ids())
+		}
+		if page.Links["first"] != system.ResourcesUrl+"?$filter=startswith%28id%2C+%27r%27%29&$limit=2" {
+			t.Errorf("page %d: unexpected first link %s", i, page.Links["first"])
+		}
+		if _, ok := page.Links["last"]; !ok {
+			t.Errorf("page %d: expected a last link", i)
+		}
+		if _, ok := page.Links["prev"]; ok != (i > 0) {
+			t.Errorf("page %d: unexpected prev link %q", i, page.Links["prev"])
+		}
+		links, _ := page.Paging["links"].(map[string]interface{})
+		if len(links) != len(page.Links) {
+			t.Errorf("page %d: expected the paging links to match the header, got %v", i, links)
+		}
+		url = page.Links["next"]
+		if (url == "") != (i == len(pages)-1) {
+			t.Fatalf("page %d: unexpected next link %q", i, url)
+		}
+	}
+
+	// Going back from the last page gives the same pages
+	_, page := getPage(t, router, system.ResourcesUrl+"?$limit=2")
+	_, page = getPage(t, router, page.Links["last"])
+	if strings.Join(page.ids(), ",") != "r4,r5" {
+		t.Fatalf("expected the last page to be r4,r5, got %v", page.ids())
+	}
+	if _, ok := page.Links["next"]; ok {
+		t.Errorf("expected no next link on the last page, got %s", page.Links["next"])
+	}
+	_, page = getPage(t, router, page.Links["prev"])
+	if strings.Join(page.ids(), ",") != "r2,r3" {
+		t.Fatalf("expected r2,r3 before the last page, got %v", page.ids())
+	}
+	_, page = getPage(t, router, page.Links["prev"])
+	if strings.Join(page.ids(), ",") != "r1" {
+		t.Fatalf("expected r1 first, got %v", page.ids())
+	}
+	if _, ok := page.Links["prev"]; ok {
+		t.Errorf("expected no prev link on the first page, got %s", page.Links["prev"])
+	}
+}
+
+// serveResources sends a request to router as alice
+func serveResources(router http.Handler, method, url, contentType, body string) *httptest.ResponseRecorder {
+	r := httptest.NewRequest(method, url, strings.NewReader(body))
+	if contentType != "" {
+		r.Header.Set("Content-Type", contentType)
+	}
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, system.WithUserId(r, "alice"))
+	return w
+}
+
+// problemDetail returns the detail of the problem answered in w
+func problemDetail(t *testing.T, w *httptest.ResponseRecorder) string {
+	var output struct {
+		Detail string `json:"detail"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &output); err != nil {
+		t.Fatalf("expected a problem, got %s", w.Body.String())
+	}
+	return output.Detail
+}
+
+func TestGetResources(t *testing.T) {
+	router, _ := resourcesRouter(t)
+	tests := []struct {
+		url    string
+		ids    string
+		paging map[string]bool
+	}{
+		{system.ResourcesUrl, "r1,r2,r3,r4,r5", map[string]bool{"offset": true}},
+		{system.ResourcesUrl + "?$offset=1&$limit=2", "r2,r3", map[string]bool{"offset": true, "next": true, "prev": true}},
+		{system.ResourcesUrl + "?$orderby=id%20desc", "r5,r4,r3,r2,r1", map[string]bool{"offset": true}},
+		{system.ResourcesUrl + "?$orderby=updatedAt%20desc,createdAt%20desc", "r5,r4,r3,r2,r1", map[string]bool{"offset": true}},
+		{system.ResourcesUrl + "?$filter=id%20in%20('r1',%20'r4')&$count=true", "r1,r4", map[string]bool{"offset": true, "count": true}},
+		{system.ResourcesUrl + "?$offset=10", "", map[string]bool{"offset": true}},
+	}
+	for _, test := range tests {
+		code, page := getPage(t, router, test.url)
+		if code != http.StatusOK {
+			t.Errorf("%s: expected 200, got %d", test.url, code)
+			continue
+		}
+		if ids := strings.Join(page.ids(), ","); ids != test.ids {
+			t.Errorf("%s: expected %s, got %s", test.url, test.ids, ids)
+		}
+		for _, name := range []string{"offset", "next", "prev", "count"} {
+			if _, ok := page.Paging[name]; ok != test.paging[name] {
+				t.Errorf("%s: unexpected %s in paging %v", test.url, name, page.Paging)
+			}
+		}
+	}
+
+	_, page := getPage(t, router, system.ResourcesUrl+"?$filter=startswith(id,%20'r')&$count=true&$limit=1")
+	if page.Paging["count"] != 5.0 || page.Paging["limit"] != 1.0 {
+		t.Errorf("expected the count of every matching resource, got %v", page.Paging)
+	}
+	_, page = getPage(t, router, system.ResourcesUrl+"?$select=createdAt")
+	if len(page.Data) == 0 || len(page.Data[0]) != 2 || page.Data[0]["createdAt"] == nil {
+		t.Errorf("expected id and createdAt only, got %v", page.Data)
+	}
+}
+
+func TestGetResourcesErrors(t *testing.T) {
+	router, _ := resourcesRouter(t)
+	_, page := getPage(t, router, system.ResourcesUrl+"?$orderby=id%20desc&$limit=2")
+	next, _ := page.Paging["next"].(string)
+
+	nested := strings.Repeat("(", system.FilterMaxDepth+1) + "id%20eq%20'r1'" + strings.Repeat(")", system.FilterMaxDepth+1)
+	tests := []struct {
+		query  string
+		detail string
+	}{
+		{"?$orderby=href", "Can't order by href"},
+		{"?$orderby=userId%20desc", "Can't order by userId"},
+		{"?$orderby=CreatedAt", "Can't order by CreatedAt"},
+		{"?$orderby=id%20sideways", system.ErrInvalidOrderBy.Error()},
+		{"?$orderby=id,id", system.ErrInvalidOrderBy.Error()},
+		{"?$orderby=createdAt,", system.ErrInvalidOrderBy.Error()},
+		{"?$limit=0", system.ErrInvalidPaging.Error()},
+		{"?$offset=-1", system.ErrInvalidPaging.Error()},
+		{"?$after=forged", "Invalid $after cursor"},
+		// Cursors are only valid for the order they were made for
+		{"?$before=" + next, "Invalid $before cursor"},
+		{"?$orderby=id%20desc&$after=" + next + "&$before=" + next, "$after and $before can't be used together"},
+		{"?$filter=owner%20eq%20'bob'", "owner can't be used in filters"},
+		{"?$filter=" + nested, "nested"},
+		{"?$select=owner", "owner"},
+	}
+	for _, test := range tests {
+		w := serveResources(router, "GET", system.ResourcesUrl+test.query, "", "")
+		if w.Code != http.StatusBadRequest {
+			t.Errorf("%s: expected 400, got %d", test.query, w.Code)
+			continue
+		}
+		if detail := problemDetail(t, w); !strings.Contains(detail, test.detail) {
+			t.Errorf("%s: expected %q, got %q", test.query, test.detail, detail)
+		}
+	}
+
+	if code, page := getPage(t, router, system.ResourcesUrl+"?$orderby=id%20desc&$limit=2&$after="+next); code != http.StatusOK || strings.Join(page.ids(), ",") != "r3,r2" {
+		t.Errorf("expected the cursor to work with its order, got %d %v", code, page.ids())
+	}
+}
+
+func TestAggregateResources(t *testing.T) {
+	router, _ := resourcesRouter(t)
+	aggregate := func(query string) (int, []map[string]interface{}) {
+		code, page := getPage(t, router, system.ResourcesUrl+"/$aggregate"+query)
+		return code, page.Data
+	}
+
+	if code, results := aggregate(""); code != http.StatusOK || len(results) != 1 || results[0]["count"] != 5.0 {
+		t.Errorf("expected alice's 5 resources to be counted, got %d %v", code, results)
+	}
+	code, results := aggregate("?$filter=id%20in%20('r1','r2')&$aggregate=count%20as%20total,max(createdAt),min(updatedAt)")
+	if code != http.StatusOK || len(results) != 1 || results[0]["total"] != 2.0 {
+		t.Fatalf("expected a total of 2, got %d %v", code, results)
+	}
+	if results[0]["maxCreatedAt"] == nil || results[0]["minUpdatedAt"] == nil {
+		t.Errorf("expected maxCreatedAt and minUpdatedAt, got %v", results[0])
+	}
+	if code, results = aggregate("?$filter=id%20eq%20'none'&$groupby=createdAt"); code != http.StatusOK || len(results) != 0 {
+		t.Errorf("expected no groups, got %d %v", code, results)
+	}
+
+	code, results = aggregate("?$groupby=createdAt")
+	total := 0.0
+	for _, result := range results {
+		if result["createdAt"] == nil {
+			t.Errorf("expected createdAt in every group, got %v", result)
+		}
+		count, _ := result["count"].(float64)
+		total += count
+	}
+	if code != http.StatusOK || total != 5 {
+		t.Errorf("expected the groups to add up to 5, got %d %v", code, results)
+	}
+
+	for _, query := range []string{
+		"?$aggregate=sum(createdAt)",
+		"?$aggregate=median(createdAt)",
+		"?$aggregate=count%20as%20createdAt&$groupby=createdAt",
+		"?$groupby=id",
+		"?$filter=id%20gt",
+	} {
+		if code, _ := aggregate(query); code != http.StatusBadRequest {
+			t.Errorf("%s: expected 400, got %d", query, code)
+		}
+	}
+}
+
+func TestPatchResource(t *testing.T) {
+	router, store := resourcesRouter(t)
+	tests := []struct {
+		name        string
+		id          string
+		contentType string
+		body        string
+		code        int
+	}{
+		{"merge patch", "r1", system.MergePatchContentType, `{"href": "/elsewhere"}`, http.StatusOK},
+		{"empty merge patch", "r1", system.MergePatchContentType, `{}`, http.StatusOK},
+		{"merge patch of the id", "r1", system.MergePatchContentType, `{"id": "r9"}`, http.StatusUnprocessableEntity},
+		{"merge patch with a wrong type", "r1", system.MergePatchContentType, `{"createdAt": 1}`, http.StatusUnprocessableEntity},
+		{"invalid merge patch", "r1", system.MergePatchContentType, `{"href":`, http.StatusBadRequest},
+		{"json patch", "r1", system.JSONPatchContentType, `[{"op": "test", "path": "/id", "value": "r1"}, {"op": "replace", "path": "/href", "value": "/x"}]`, http.StatusOK},
+		{"failed test", "r1", system.JSONPatchContentType, `[{"op": "test", "path": "/id", "value": "r2"}]`, http.StatusConflict},
+		{"missing path", "r1", system.JSONPatchContentType, `[{"op": "remove", "path": "/owner"}]`, http.StatusUnprocessableEntity},
+		{"unknown operation", "r1", system.JSONPatchContentType, `[{"op": "jump", "path": "/href"}]`, http.StatusBadRequest},
+		{"json patch of the id", "r1", system.JSONPatchContentType, `[{"op": "replace", "path": "/id", "value": "r9"}]`, http.StatusUnprocessableEntity},
+		{"unsupported type", "r1", "application/json", `{"href": "/x"}`, http.StatusUnsupportedMediaType},
+		{"missing resource", "r9", system.MergePatchContentType, `{}`, http.StatusNotFound},
+		{"resource of another user", "r6", system.MergePatchContentType, `{}`, http.StatusNotFound},
+	}
+	for _, test := range tests {
+		w := serveResources(router, "PATCH", system.ResourcesUrl+"/"+test.id, test.contentType, test.body)
+		if w.Code != test.code {
+			t.Errorf("%s: expected %d, got %d %s", test.name, test.code, w.Code, w.Body.String())
+		}
+	}
+
+	// The server keeps managing the href, whatever the patches said
+	resource, err := store.GetResourceById("alice", "r1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resource.Href != system.ResourcesUrl+"/r1" {
+		t.Errorf("expected the href to be kept, got %s", resource.Href)
+	}
+
+	// Failed patches don't store anything
+	before, _ := store.GetResourceById("alice", "r3")
+	serveResources(router, "PATCH", system.ResourcesUrl+"/r3", system.JSONPatchContentType, `[{"op": "test", "path": "/id", "value": "r2"}]`)
+	if after, _ := store.GetResourceById("alice", "r3"); !after.UpdatedAt.Equal(before.UpdatedAt) {
+		t.Errorf("expected r3 not to be updated, got %v after %v", after.UpdatedAt, before.UpdatedAt)
+	}
+
+	w := serveResources(router, "PATCH", system.ResourcesUrl+"/r2", system.MergePatchContentType, `{}`)
+	var output struct {
+		Data map[string]interface{} `json:"data"`
+	}
+	if err = json.Unmarshal(w.Body.Bytes(), &output); err != nil {
+		t.Fatal(err)
+	}
+	if output.Data["id"] != "r2" || output.Data["href"] != system.ResourcesUrl+"/r2" {
+		t.Errorf("unexpected output %v", output.Data)
+	}
+}
+
+func TestResourceSchema(t *testing.T) {
+	expected, err := json.Marshal(validation.SchemaOf(models.Resource{}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, handler := range []http.HandlerFunc{ResourceSchema, ResourceOptions} {
+		w := httptest.NewRecorder()
+		handler(w, httptest.NewRequest("GET", system.ResourcesUrl+"/$schema", nil))
+		if w.Header().Get("Content-Type") != SchemaContentType || w.Body.String() != string(expected) {
+			t.Errorf("expected the schema of Resource, got %s %s", w.Header().Get("Content-Type"), w.Body.String())
+		}
+	}
+
+	// The published schema rejects what the validate tags reject
+	var published struct {
+		Properties map[string]struct {
+			Then struct {
+				MaxLength int `json:"maxLength"`
+			} `json:"then"`
+		} `json:"properties"`
+	}
+	if err = json.Unmarshal(expected, &published); err != nil {
+		t.Fatal(err)
+	}
+	if published.Properties["href"].Then.MaxLength != 255 {
+		t.Errorf("expected href to be limited to 255 characters, got %s", expected)
+	}
+	document := []byte(`{"href": "` + strings.Repeat("a", 256) + `"}`)
+	var resource models.Resource
+	if err = json.Unmarshal(document, &resource); err != nil {
+		t.Fatal(err)
+	}
+	if validation.Validate(resource) == nil || validation.SchemaOf(resource).Validate(document) == nil {
+		t.Errorf("expected a long href to be rejected by both")
+	}
+}
@@ -0,0 +1,145 @@
+/*
+Copyright (c) 2015, Alberto Corsín Lafuente
+All rights reserved.
+
+Redistribution and use in source and binary forms, with or without
+modification, are permitted provided that the following conditions are met:
+
+1. Redistributions of source code must retain the above copyright notice,
+   this list of conditions and the following disclaimer.
+
+2. Redistributions in binary form must reproduce the above copyright notice,
+   this list of conditions and the following disclaimer in the documentation
+   and/or other materials provided with the distribution.
+
+3. Neither the name of the copyright holder nor the names of its contributors
+   may be used to endorse or promote products derived from this software
+   without specific prior written permission.
+
+THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND
+ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED
+WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE
+FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER
+CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY,
+OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+*/
+package api
+
+import (
+	"github.com/acorsinl/casimiro/auth"
+	"github.com/acorsinl/casimiro/problem"
+	"github.com/acorsinl/casimiro/system"
+	"net/http"
+)
+
+var localAuth *auth.Local
+
+// SetLocalAuth sets the authenticator of local users used by the /auth
+// handlers
+func SetLocalAuth(local *auth.Local) {
+	localAuth = local
+}
+
+// registration is the body of POST /auth/register
+type registration struct {
+	Username string `json:"username" validate:"required,max=255" pattern:"^[A-Za-z0-9._@+-]+$"`
+	Password string `json:"password" validate:"required,min=8"`
+}
+
+// credentials is the body of POST /auth/login
+type credentials struct {
+	Username string `json:"username" validate:"required"`
+	Password string `json:"password" validate:"required"`
+}
+
+// refresh is the body of POST /auth/refresh
+type refresh struct {
+	RefreshToken string `json:"refreshToken" validate:"required"`
+}
+
+// Register creates a local user, its id owns the resources it creates
+func Register(w http.ResponseWriter, r *http.Request) {
+	request := new(registration)
+	if err := decodeBody(r, request); err != nil {
+		problem.Write(w, r, err)
+		return
+	}
+
+	user, err := localAuth.Register(request.Username, request.Password)
+	if err != nil {
+		problem.Write(w, r, storeProblem(err))
+		return
+	}
+
+	data := make(map[string]interface{})
+	data["id"] = user.Id
+	data["username"] = user.Username
+	data["createdAt"] = user.CreatedAt
+	system.APISingleResult(http.StatusCreated, "User registered", data, w)
+}
+
+// Login starts a session for a local user given its username and password
+func Login(w http.ResponseWriter, r *http.Request) {
+	request := new(credentials)
+	if err := decodeBody(r, request); err != nil {
+		problem.Write(w, r, err)
+		return
+	}
+
+	tokens, err := localAuth.Login(request.Username, request.Password)
+	if err != nil {
+		problem.Write(w, r, storeProblem(err))
+		return
+	}
+
+	w.Header().Set("Cache-Control", "no-store")
+	system.APISingleResult(http.StatusOK, "Logged in", tokensData(tokens), w)
+}
+
+// Refresh gives new tokens for a refresh token, which can't be used again
+func Refresh(w http.ResponseWriter, r *http.Request) {
+	request := new(refresh)
+	if err := decodeBody(r, request); err != nil {
+		problem.Write(w, r, err)
+		return
+	}
+
+	tokens, err := localAuth.Refresh(request.RefreshToken)
+	if err != nil {
+		problem.Write(w, r, storeProblem(err))
+		return
+	}
+
+	w.Header().Set("Cache-Control", "no-store")
+	system.APISingleResult(http.StatusOK, "Session refreshed", tokensData(tokens), w)
+}
+
+// Logout ends the session of the access token of the request
+func Logout(w http.ResponseWriter, r *http.Request) {
+	if err := localAuth.Logout(r); err != nil {
+		problem.Write(w, r, storeProblem(err))
+		return
+	}
+
+	system.APIReturn(http.StatusOK, "Logged out", w)
+}
+
+// AuthOptions returns the Access-Control tier headers for the /auth urls
+func AuthOptions(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Access-Control-Allow-Methods", "POST, OPTIONS")
+	w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Content-Length, Accept-Encoding, Authorization")
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+}
+
+func tokensData(tokens *auth.Tokens) map[string]interface{} {
+	data := make(map[string]interface{})
+	data["accessToken"] = tokens.AccessToken
+	data["tokenType"] = tokens.TokenType
+	data["expiresIn"] = tokens.ExpiresIn
+	data["refreshToken"] = tokens.RefreshToken
+	return data
+}
@@ -0,0 +1,148 @@
+/*
+Copyright (c) 2015, Alberto Corsín Lafuente
+All rights reserved.
+
+Redistribution and use in source and binary forms, with or without
+modification, are permitted provided that the following conditions are met:
+
+1. Redistributions of source code must retain the above copyright notice,
+   this list of conditions and the following disclaimer.
+
+2. Redistributions in binary form must reproduce the above copyright notice,
+   this list of conditions and the following disclaimer in the documentation
+   and/or other materials provided with the distribution.
+
+3. Neither the name of the copyright holder nor the names of its contributors
+   may be used to endorse or promote products derived from this software
+   without specific prior written permission.
+
+THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND
+ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED
+WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE
+FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER
+CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY,
+OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+*/
+package db
+
+import (
+	"database/sql"
+	"errors"
+	"github.com/acorsinl/casimiro/models"
+	_ "github.com/mattn/go-sqlite3"
+	"testing"
+	"time"
+)
+
+func TestMigrateSQLite(t *testing.T) {
+	_, dsn, _ := models.ParseDBUri("sqlite::memory:")
+	database, err := sql.Open("sqlite3", dsn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer database.Close()
+	database.SetMaxOpenConns(1)
+
+	migrator, err := NewMigrator(database, models.SQLite)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = migrator.Up(); err != nil {
+		t.Fatal(err)
+	}
+	if current, err := migrator.Current(); err != nil || current != migrator.Latest() {
+		t.Fatalf("expected version %d, got %d %v", migrator.Latest(), current, err)
+	}
+
+	if err = migrator.Down(); err != nil {
+		t.Fatal(err)
+	}
+	if current, _ := migrator.Current(); current != migrator.Latest()-1 {
+		t.Errorf("expected version %d after down, got %d", migrator.Latest()-1, current)
+	}
+	if err = migrator.To(0); err != nil {
+		t.Fatal(err)
+	}
+	if err = migrator.To(9999); err != ErrUnknownVersion {
+		t.Errorf("expected ErrUnknownVersion, got %v", err)
+	}
+	if err = migrator.Up(); err != nil {
+		t.Fatal(err)
+	}
+
+	status, err := migrator.Status()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, migration := range status {
+		if !migration.Applied {
+			t.Errorf("migration %d isn't applied", migration.Version)
+		}
+	}
+}
+
+func TestBookkeepingUpsert(t *testing.T) {
+	database, err := sql.Open("sqlite3", ":memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer database.Close()
+	database.SetMaxOpenConns(1)
+
+	// A migration applied by another instance meanwhile is recorded once
+	migration := Migration{Version: 1, Name: "create_things", Up: "CREATE TABLE IF NOT EXISTS things (id INTEGER)"}
+	migrator := &Migrator{DB: database, Dialect: models.SQLite, Migrations: []Migration{migration}}
+	if _, err = migrator.applied(); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 2; i++ {
+		if err = migrator.run(migration, true); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	var count int
+	if err = database.QueryRow("SELECT COUNT(*) FROM schema_migrations WHERE version = 1").Scan(&count); err != nil || count != 1 {
+		t.Errorf("expected the version recorded once, got %d %v", count, err)
+	}
+}
+
+func TestSQLiteForeignKeys(t *testing.T) {
+	m := &models.Model{}
+	m.InitDB("sqlite::memory:")
+	defer m.DBSession.Close()
+
+	migrator, err := NewMigrator(m.DBSession, m.Dialect)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = migrator.Up(); err != nil {
+		t.Fatal(err)
+	}
+
+	expires := time.Now().Add(time.Hour)
+	session := &models.Session{Id: "s1", UserId: "missing", AccessHash: "a1", RefreshHash: "r1",
+		AccessExpiresAt: expires, ExpiresAt: expires}
+	if err = m.InsertSession(session); !errors.Is(err, models.ErrInvalidReference) {
+		t.Fatalf("expected ErrInvalidReference, got %v", err)
+	}
+
+	// Sessions are deleted along with their user
+	if err = m.InsertUser(&models.User{Id: "u1", Username: "alice", PasswordHash: "x"}); err != nil {
+		t.Fatal(err)
+	}
+	session.UserId = "u1"
+	if err = m.InsertSession(session); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = m.DBSession.Exec("DELETE FROM users WHERE id = 'u1'"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = m.GetSessionByAccessHash("a1"); !errors.Is(err, models.ErrNotFound) {
+		t.Fatalf("expected the session to be deleted, got %v", err)
+	}
+}
@@ -0,0 +1,9 @@
+CREATE TABLE resources (
+	id VARCHAR(36) NOT NULL PRIMARY KEY,
+	user_id VARCHAR(255) NOT NULL,
+	href VARCHAR(255) NOT NULL DEFAULT '',
+	created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+	updated_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX resources_user_created ON resources (user_id, created_at);
@@ -0,0 +1,15 @@
+CREATE TABLE api_keys (
+	id VARCHAR(36) NOT NULL PRIMARY KEY,
+	user_id VARCHAR(255) NOT NULL,
+	name VARCHAR(255) NOT NULL DEFAULT '',
+	prefix VARCHAR(16) NOT NULL,
+	hash VARCHAR(64) NOT NULL,
+	scopes VARCHAR(1024) NOT NULL DEFAULT '',
+	expires_at TIMESTAMP NULL,
+	revoked_at TIMESTAMP NULL,
+	created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+	updated_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE UNIQUE INDEX api_keys_hash ON api_keys (hash);
+CREATE INDEX api_keys_user_created ON api_keys (user_id, created_at);
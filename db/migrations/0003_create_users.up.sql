@@ -0,0 +1,26 @@
+CREATE TABLE users (
+	id VARCHAR(36) NOT NULL PRIMARY KEY,
+	username VARCHAR(255) NOT NULL,
+	password_hash VARCHAR(255) NOT NULL,
+	failed_logins INTEGER NOT NULL DEFAULT 0,
+	locked_until TIMESTAMP NULL,
+	created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+	updated_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE UNIQUE INDEX users_username ON users (username);
+
+CREATE TABLE sessions (
+	id VARCHAR(36) NOT NULL PRIMARY KEY,
+	user_id VARCHAR(36) NOT NULL,
+	access_hash VARCHAR(64) NOT NULL,
+	refresh_hash VARCHAR(64) NOT NULL,
+	access_expires_at TIMESTAMP NOT NULL,
+	expires_at TIMESTAMP NOT NULL,
+	created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+	updated_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+	FOREIGN KEY (user_id) REFERENCES users (id) ON DELETE CASCADE
+);
+
+CREATE UNIQUE INDEX sessions_access_hash ON sessions (access_hash);
+CREATE UNIQUE INDEX sessions_refresh_hash ON sessions (refresh_hash);
@@ -1 +0,0 @@
---- At some point this file will contain a database schema
\ No newline at end of file
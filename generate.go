@@ -0,0 +1,59 @@
+/*
+Copyright (c) 2015, Alberto Corsín Lafuente
+All rights reserved.
+
+Redistribution and use in source and binary forms, with or without
+modification, are permitted provided that the following conditions are met:
+
+1. Redistributions of source code must retain the above copyright notice,
+   this list of conditions and the following disclaimer.
+
+2. Redistributions in binary form must reproduce the above copyright notice,
+   this list of conditions and the following disclaimer in the documentation
+   and/or other materials provided with the distribution.
+
+3. Neither the name of the copyright holder nor the names of its contributors
+   may be used to endorse or promote products derived from this software
+   without specific prior written permission.
+
+THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND
+ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED
+WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE
+FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER
+CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY,
+OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+*/
+package main
+
+import (
+	"github.com/acorsinl/casimiro/scaffold"
+	"log"
+)
+
+const generateUsage = "Usage: casimiro generate resource <Name> field:type ..."
+
+// Generate runs the generate subcommand, creating the files of a new
+// resource in the Casimiro tree of the current directory
+func Generate(args []string) {
+	if len(args) < 2 || args[0] != "resource" {
+		log.Fatal(generateUsage)
+	}
+
+	resource, err := scaffold.NewResource(args[1], args[2:])
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	files, err := scaffold.Generate(".", resource)
+	for _, file := range files {
+		log.Println("Generated " + file)
+	}
+	if err != nil {
+		log.Fatal(err)
+	}
+	log.Printf("%s generated, run `casimiro migrate up` to create the %s table", resource.Name, resource.Table)
+}
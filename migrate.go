@@ -0,0 +1,123 @@
+/*
+Copyright (c) 2015, Alberto Corsín Lafuente
+All rights reserved.
+
+Redistribution and use in source and binary forms, with or without
+modification, are permitted provided that the following conditions are met:
+
+1. Redistributions of source code must retain the above copyright notice,
+   this list of conditions and the following disclaimer.
+
+2. Redistributions in binary form must reproduce the above copyright notice,
+   this list of conditions and the following disclaimer in the documentation
+   and/or other materials provided with the distribution.
+
+3. Neither the name of the copyright holder nor the names of its contributors
+   may be used to endorse or promote products derived from this software
+   without specific prior written permission.
+
+THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND
+ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED
+WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE
+FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER
+CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY,
+OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+*/
+package main
+
+import (
+	"fmt"
+	"github.com/acorsinl/casimiro/db"
+	"github.com/acorsinl/casimiro/models"
+	"log"
+	"strconv"
+)
+
+const migrateUsage = "Usage: casimiro migrate up|down|status|to N"
+
+// Migrate runs the migrate subcommand against the SQL database in dbUri
+func Migrate(dbUri string, args []string) {
+	if len(args) == 0 {
+		log.Fatal(migrateUsage)
+	}
+
+	migrator := newMigrator(models.NewResourceStore(dbUri))
+	if migrator == nil {
+		log.Fatal("Migrations are only available for SQL databases")
+	}
+
+	var err error
+	switch {
+	case args[0] == "up" && len(args) == 1:
+		err = migrator.Up()
+	case args[0] == "down" && len(args) == 1:
+		err = migrator.Down()
+	case args[0] == "to" && len(args) == 2:
+		version, convErr := strconv.Atoi(args[1])
+		if convErr != nil {
+			log.Fatal(migrateUsage)
+		}
+		err = migrator.To(version)
+	case args[0] == "status" && len(args) == 1:
+		err = printMigrationStatus(migrator)
+	default:
+		log.Fatal(migrateUsage)
+	}
+
+	if err != nil {
+		log.Fatal(err)
+	}
+	if args[0] != "status" {
+		current, err := migrator.Current()
+		if err != nil {
+			log.Fatal(err)
+		}
+		log.Printf("Database schema at version %d", current)
+	}
+}
+
+// RunMigrations applies pending migrations when store is a SQL database
+func RunMigrations(store models.ResourceStore) {
+	migrator := newMigrator(store)
+	if migrator == nil {
+		return
+	}
+
+	if err := migrator.Up(); err != nil {
+		log.Fatal(err)
+	}
+	log.Printf("Database schema at version %d", migrator.Latest())
+}
+
+func newMigrator(store models.ResourceStore) *db.Migrator {
+	model, ok := store.(*models.Model)
+	if !ok {
+		return nil
+	}
+
+	migrator, err := db.NewMigrator(model.DBSession, model.Dialect)
+	if err != nil {
+		log.Fatal(err)
+	}
+	return migrator
+}
+
+func printMigrationStatus(migrator *db.Migrator) error {
+	status, err := migrator.Status()
+	if err != nil {
+		return err
+	}
+
+	for _, migration := range status {
+		applied := "pending"
+		if migration.Applied {
+			applied = "applied " + migration.AppliedAt.Format("2006-01-02 15:04:05")
+		}
+		fmt.Printf("%04d %-40s %s\n", migration.Version, migration.Name, applied)
+	}
+	return nil
+}
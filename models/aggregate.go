@@ -0,0 +1,217 @@
+/*
+Copyright (c) 2015, Alberto Corsín Lafuente
+All rights reserved.
+
+Redistribution and use in source and binary forms, with or without
+modification, are permitted provided that the following conditions are met:
+
+1. Redistributions of source code must retain the above copyright notice,
+   this list of conditions and the following disclaimer.
+
+2. Redistributions in binary form must reproduce the above copyright notice,
+   this list of conditions and the following disclaimer in the documentation
+   and/or other materials provided with the distribution.
+
+3. Neither the name of the copyright holder nor the names of its contributors
+   may be used to endorse or promote products derived from this software
+   without specific prior written permission.
+
+THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND
+ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED
+WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE
+FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER
+CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY,
+OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+*/
+
+package models
+
+import (
+	"fmt"
+	"github.com/acorsinl/casimiro/system"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ResourceGroupable are the Resource fields that can be used in $groupby
+var ResourceGroupable = map[string]bool{
+	"createdAt": true,
+	"updatedAt": true,
+}
+
+// ResourceAggregatable are the Resource fields that can be used in the
+// functions of $aggregate. Resource has no number fields, so sum and avg
+// are rejected until one is added here as system.FilterNumber.
+var ResourceAggregatable = map[string]system.FilterType{
+	"createdAt": system.FilterTime,
+	"updatedAt": system.FilterTime,
+}
+
+// AggregateQuery computes Aggregates over the rows matching Filter, one
+// result per distinct value of the GroupBy fields or a single one if empty.
+// Results are sorted by the GroupBy fields.
+type AggregateQuery struct {
+	Filter     system.Filter
+	GroupBy    []string
+	Aggregates []system.Aggregate
+}
+
+// aggregateType returns the type of the values computed by aggregate
+func aggregateType(aggregate system.Aggregate) system.FilterType {
+	if aggregate.Function == "min" || aggregate.Function == "max" {
+		return ResourceFilters[aggregate.Field]
+	}
+	return system.FilterNumber
+}
+
+// aggregateResult names the values of a result row, which holds the GroupBy
+// fields followed by the Aggregates
+func aggregateResult(query AggregateQuery, values []interface{}) map[string]interface{} {
+	result := make(map[string]interface{})
+	for i, field := range query.GroupBy {
+		result[field] = aggregateValue(values[i], ResourceFilters[field])
+	}
+	for i, aggregate := range query.Aggregates {
+		result[aggregate.Alias] = aggregateValue(values[len(query.GroupBy)+i], aggregateType(aggregate))
+	}
+	return result
+}
+
+// aggregateValue converts the values drivers return as text, as SQLite does
+// for timestamps and MySQL for decimals, to fieldType
+func aggregateValue(value interface{}, fieldType system.FilterType) interface{} {
+	if bytes, ok := value.([]byte); ok {
+		value = string(bytes)
+	}
+	text, ok := value.(string)
+	if !ok {
+		return value
+	}
+
+	switch fieldType {
+	case system.FilterTime:
+		for _, layout := range []string{timestampFormat, time.RFC3339Nano} {
+			if t, err := time.Parse(layout, text); err == nil {
+				return t
+			}
+		}
+	case system.FilterNumber:
+		if number, err := strconv.ParseFloat(text, 64); err == nil {
+			return number
+		}
+	}
+	return value
+}
+
+// sqlAggregate returns the SQL expression computing aggregate
+func sqlAggregate(aggregate system.Aggregate, columns map[string]string) string {
+	if aggregate.Function == "count" {
+		return "COUNT(*)"
+	}
+	return strings.ToUpper(aggregate.Function) + "(" + columns[aggregate.Field] + ")"
+}
+
+// aggregateResources computes query over resources in memory
+func aggregateResources(resources []Resource, query AggregateQuery) []map[string]interface{} {
+	type group struct {
+		keys   []interface{}
+		count  int
+		values []interface{}
+		sums   []float64
+	}
+	var groups []*group
+	groupsByKey := make(map[string]*group)
+	newGroup := func(keys []interface{}) *group {
+		g := &group{keys: keys, values: make([]interface{}, len(query.Aggregates)), sums: make([]float64, len(query.Aggregates))}
+		groups = append(groups, g)
+		return g
+	}
+	if len(query.GroupBy) == 0 {
+		newGroup(nil)
+	}
+
+	for index := range resources {
+		field := resourceField(&resources[index])
+		keys := make([]interface{}, len(query.GroupBy))
+		for i, name := range query.GroupBy {
+			keys[i] = field(name)
+		}
+
+		var g *group
+		if len(query.GroupBy) == 0 {
+			g = groups[0]
+		} else {
+			key := groupKey(keys)
+			if g = groupsByKey[key]; g == nil {
+				g = newGroup(keys)
+				groupsByKey[key] = g
+			}
+		}
+
+		g.count++
+		for i, aggregate := range query.Aggregates {
+			if aggregate.Function == "count" {
+				continue
+			}
+			value := field(aggregate.Field)
+			switch aggregate.Function {
+			case "sum", "avg":
+				number, _ := value.(float64)
+				g.sums[i] += number
+			case "min":
+				if g.values[i] == nil || compareValues(value, g.values[i]) < 0 {
+					g.values[i] = value
+				}
+			case "max":
+				if g.values[i] == nil || compareValues(value, g.values[i]) > 0 {
+					g.values[i] = value
+				}
+			}
+		}
+	}
+
+	sort.Slice(groups, func(i, j int) bool {
+		for k := range groups[i].keys {
+			if n := compareValues(groups[i].keys[k], groups[j].keys[k]); n != 0 {
+				return n < 0
+			}
+		}
+		return false
+	})
+
+	results := make([]map[string]interface{}, len(groups))
+	for index, g := range groups {
+		values := append([]interface{}{}, g.keys...)
+		for i, aggregate := range query.Aggregates {
+			switch {
+			case aggregate.Function == "count":
+				g.values[i] = g.count
+			case g.count == 0:
+			case aggregate.Function == "sum":
+				g.values[i] = g.sums[i]
+			case aggregate.Function == "avg":
+				g.values[i] = g.sums[i] / float64(g.count)
+			}
+		}
+		results[index] = aggregateResult(query, append(values, g.values...))
+	}
+	return results
+}
+
+// groupKey identifies the group of a row by its GroupBy values
+func groupKey(keys []interface{}) string {
+	parts := make([]string, len(keys))
+	for i, key := range keys {
+		if t, ok := key.(time.Time); ok {
+			key = t.UnixNano()
+		}
+		parts[i] = fmt.Sprintf("%#v", key)
+	}
+	return strings.Join(parts, ",")
+}
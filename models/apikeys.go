@@ -0,0 +1,201 @@
+/*
+Copyright (c) 2015, Alberto Corsín Lafuente
+All rights reserved.
+
+Redistribution and use in source and binary forms, with or without
+modification, are permitted provided that the following conditions are met:
+
+1. Redistributions of source code must retain the above copyright notice,
+   this list of conditions and the following disclaimer.
+
+2. Redistributions in binary form must reproduce the above copyright notice,
+   this list of conditions and the following disclaimer in the documentation
+   and/or other materials provided with the distribution.
+
+3. Neither the name of the copyright holder nor the names of its contributors
+   may be used to endorse or promote products derived from this software
+   without specific prior written permission.
+
+THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND
+ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED
+WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE
+FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER
+CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY,
+OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+*/
+
+package models
+
+import (
+	"database/sql"
+	"strings"
+	"time"
+)
+
+// APIKey lets its owner call the API without the upper layer, with the
+// scopes it was given. Only a hash of the key is stored, Prefix tells keys
+// apart to their owner.
+type APIKey struct {
+	Id        string     `json:"id"`
+	UserId    string     `json:"-"`
+	Name      string     `json:"name"`
+	Prefix    string     `json:"prefix"`
+	Hash      string     `json:"-"`
+	Scopes    []string   `json:"scopes"`
+	ExpiresAt *time.Time `json:"expiresAt"`
+	RevokedAt *time.Time `json:"revokedAt"`
+	CreatedAt time.Time  `json:"createdAt"`
+	UpdatedAt time.Time  `json:"updatedAt"`
+}
+
+// APIKeyScopesLength is the longest the scopes of a key can be, joined by
+// spaces as SQL databases store them
+const APIKeyScopesLength = 1024
+
+// Active tells whether key can be used at time now
+func (key *APIKey) Active(now time.Time) bool {
+	return key.RevokedAt == nil && (key.ExpiresAt == nil || now.Before(*key.ExpiresAt))
+}
+
+// APIKeyStore is the set of operations needed to manage and check API keys.
+// Model, MemoryModel and MongoModel implement it.
+type APIKeyStore interface {
+	InsertAPIKey(key *APIKey) error
+	GetAPIKeyById(userId, keyId string) (*APIKey, error)
+	GetAPIKeys(userId string, offset, limit int) ([]APIKey, error)
+	// GetAPIKeyByHash returns the key with hash, whoever owns it
+	GetAPIKeyByHash(hash string) (*APIKey, error)
+	// RotateAPIKey replaces the prefix and hash of a key of userId that is
+	// active at now, returning ErrNotFound if there isn't such a key
+	RotateAPIKey(userId, keyId, prefix, hash string, now time.Time) error
+	// RevokeAPIKey revokes at now a key of userId, returning ErrNotFound if
+	// there isn't such a key or it is already revoked
+	RevokeAPIKey(userId, keyId string, now time.Time) error
+}
+
+var _ APIKeyStore = (*Model)(nil)
+
+const apiKeyColumns = "id, user_id, name, prefix, hash, scopes, expires_at, revoked_at, created_at, updated_at"
+
+func (m *Model) InsertAPIKey(key *APIKey) error {
+	stmt := "INSERT INTO api_keys (id, user_id, name, prefix, hash, scopes, expires_at, revoked_at) VALUES (?, ?, ?, ?, ?, ?, ?, ?)"
+	query, err := m.prepare(stmt)
+	if err != nil {
+		return err
+	}
+	defer query.Close()
+
+	_, err = query.Exec(key.Id, key.UserId, key.Name, key.Prefix, key.Hash, strings.Join(key.Scopes, " "), m.nullTime(key.ExpiresAt), m.nullTime(key.RevokedAt))
+	if err != nil {
+		return classifyError(err)
+	}
+
+	return m.loadTimestamps("api_keys", key.Id, &key.CreatedAt, &key.UpdatedAt)
+}
+
+func (m *Model) GetAPIKeyById(userId, keyId string) (*APIKey, error) {
+	return m.getAPIKey("user_id = ? AND id = ?", userId, keyId)
+}
+
+func (m *Model) GetAPIKeyByHash(hash string) (*APIKey, error) {
+	return m.getAPIKey("hash = ?", hash)
+}
+
+func (m *Model) getAPIKey(condition string, args ...interface{}) (*APIKey, error) {
+	stmt := "SELECT " + apiKeyColumns + " FROM api_keys WHERE " + condition
+	query, err := m.prepare(stmt)
+	if err != nil {
+		return nil, err
+	}
+	defer query.Close()
+
+	key, err := scanAPIKey(query.QueryRow(args...))
+	if err != nil {
+		return nil, classifyError(err)
+	}
+	return key, nil
+}
+
+func (m *Model) GetAPIKeys(userId string, offset, limit int) ([]APIKey, error) {
+	var keys []APIKey
+
+	paging, pagingArgs := m.Dialect.Paginate(offset, limit)
+	stmt := "SELECT " + apiKeyColumns + " FROM api_keys WHERE user_id = ? ORDER BY created_at, id" + paging
+	query, err := m.prepare(stmt)
+	if err != nil {
+		return nil, err
+	}
+	defer query.Close()
+
+	rows, err := query.Query(append([]interface{}{userId}, pagingArgs...)...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		key, err := scanAPIKey(rows)
+		if err != nil {
+			return nil, err
+		}
+		keys = append(keys, *key)
+	}
+	return keys, rows.Err()
+}
+
+func (m *Model) RotateAPIKey(userId, keyId, prefix, hash string, now time.Time) error {
+	stmt := "UPDATE api_keys SET prefix = ?, hash = ?, updated_at = CURRENT_TIMESTAMP" +
+		" WHERE id = ? AND user_id = ? AND revoked_at IS NULL AND (expires_at IS NULL OR expires_at > ?)"
+	return m.updateAPIKey(stmt, prefix, hash, keyId, userId, m.Dialect.TimeValue(now.UTC()))
+}
+
+func (m *Model) RevokeAPIKey(userId, keyId string, now time.Time) error {
+	stmt := "UPDATE api_keys SET revoked_at = ?, updated_at = CURRENT_TIMESTAMP WHERE id = ? AND user_id = ? AND revoked_at IS NULL"
+	return m.updateAPIKey(stmt, m.Dialect.TimeValue(now.UTC()), keyId, userId)
+}
+
+func (m *Model) updateAPIKey(stmt string, args ...interface{}) error {
+	query, err := m.prepare(stmt)
+	if err != nil {
+		return err
+	}
+	defer query.Close()
+
+	result, err := query.Exec(args...)
+	if err != nil {
+		return classifyError(err)
+	}
+
+	return affectedRow(result)
+}
+
+// nullTime returns the bind argument of an optional timestamp
+func (m *Model) nullTime(t *time.Time) interface{} {
+	if t == nil {
+		return nil
+	}
+	return m.Dialect.TimeValue(t.UTC())
+}
+
+func scanAPIKey(row interface{ Scan(...interface{}) error }) (*APIKey, error) {
+	var key APIKey
+	var scopes string
+	var expiresAt, revokedAt sql.NullTime
+
+	err := row.Scan(&key.Id, &key.UserId, &key.Name, &key.Prefix, &key.Hash, &scopes, &expiresAt, &revokedAt, &key.CreatedAt, &key.UpdatedAt)
+	if err != nil {
+		return nil, err
+	}
+	key.Scopes = strings.Fields(scopes)
+	if expiresAt.Valid {
+		key.ExpiresAt = &expiresAt.Time
+	}
+	if revokedAt.Valid {
+		key.RevokedAt = &revokedAt.Time
+	}
+	return &key, nil
+}
@@ -0,0 +1,206 @@
+/*
+Copyright (c) 2015, Alberto Corsín Lafuente
+All rights reserved.
+
+Redistribution and use in source and binary forms, with or without
+modification, are permitted provided that the following conditions are met:
+
+1. Redistributions of source code must retain the above copyright notice,
+   this list of conditions and the following disclaimer.
+
+2. Redistributions in binary form must reproduce the above copyright notice,
+   this list of conditions and the following disclaimer in the documentation
+   and/or other materials provided with the distribution.
+
+3. Neither the name of the copyright holder nor the names of its contributors
+   may be used to endorse or promote products derived from this software
+   without specific prior written permission.
+
+THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND
+ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED
+WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE
+FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER
+CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY,
+OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+*/
+
+package models
+
+import (
+	"github.com/go-sql-driver/mysql"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Dialect hides the SQL syntax differences between the supported database
+// engines. Queries in this package are written with "?" placeholders and
+// passed through Rebind before being prepared.
+type Dialect interface {
+	// DriverName is the database/sql driver name to open connections with
+	DriverName() string
+	// Placeholder returns the bind parameter for the n-th argument, from 1
+	Placeholder(n int) string
+	// Paginate returns the LIMIT/OFFSET clause and its arguments
+	Paginate(offset, limit int) (string, []interface{})
+	// Upsert returns an INSERT statement for columns that updates the
+	// updates columns when a row with the same conflict columns exists
+	Upsert(table string, columns, conflict, updates []string) string
+	// Returning returns a RETURNING clause for columns, or an empty string
+	// if the engine can't return values from INSERT/UPDATE statements
+	Returning(columns ...string) string
+	// LockRows returns the clause locking the rows read by a SELECT until
+	// the end of the transaction
+	LockRows() string
+	// TimeValue returns the bind argument to compare a timestamp column
+	// with t
+	TimeValue(t time.Time) interface{}
+	// Quote returns identifier quoted, so it can be a keyword of the engine
+	Quote(identifier string) string
+}
+
+// timestampFormat is how timestamps without time zone are written for
+// engines that would otherwise convert them
+const timestampFormat = "2006-01-02 15:04:05.999999999"
+
+// ParseDBUri returns the dialect and driver connection string for dbUri.
+// postgres:// and postgresql:// uris use PostgreSQL, sqlite: uris use SQLite,
+// mysql:// uris and plain go-sql-driver DSNs use MySQL.
+func ParseDBUri(dbUri string) (Dialect, string, error) {
+	switch {
+	case strings.HasPrefix(dbUri, "postgres://"), strings.HasPrefix(dbUri, "postgresql://"):
+		return PostgreSQL, dbUri, nil
+	case strings.HasPrefix(dbUri, "sqlite:"):
+		return SQLite, sqlitePath(dbUri), nil
+	case strings.HasPrefix(dbUri, "mysql://"):
+		dbUri = strings.TrimPrefix(dbUri, "mysql://")
+	}
+
+	// Timestamps are scanned into time.Time, which the MySQL driver only
+	// does when asked to.
+	config, err := mysql.ParseDSN(dbUri)
+	if err != nil {
+		return nil, "", err
+	}
+	config.ParseTime = true
+	// Report matched rather than changed rows, so updates that store the
+	// same values aren't taken for updates of missing rows
+	config.ClientFoundRows = true
+	return MySQL, config.FormatDSN(), nil
+}
+
+// Rebind replaces the "?" placeholders in query with the ones used by d
+func Rebind(d Dialect, query string) string {
+	if d.Placeholder(1) == "?" {
+		return query
+	}
+
+	var b strings.Builder
+	n := 0
+	for _, c := range query {
+		if c == '?' {
+			n++
+			b.WriteString(d.Placeholder(n))
+			continue
+		}
+		b.WriteRune(c)
+	}
+	return b.String()
+}
+
+var (
+	MySQL      Dialect = mysqlDialect{}
+	PostgreSQL Dialect = postgresDialect{}
+)
+
+type mysqlDialect struct{}
+
+func (mysqlDialect) DriverName() string {
+	return "mysql"
+}
+
+func (mysqlDialect) Placeholder(n int) string {
+	return "?"
+}
+
+func (mysqlDialect) Paginate(offset, limit int) (string, []interface{}) {
+	return " LIMIT ?, ?", []interface{}{offset, limit}
+}
+
+func (mysqlDialect) Upsert(table string, columns, conflict, updates []string) string {
+	set := make([]string, len(updates))
+	for i, column := range updates {
+		set[i] = column + " = VALUES(" + column + ")"
+	}
+	return insertStatement(table, columns) + " ON DUPLICATE KEY UPDATE " + strings.Join(set, ", ")
+}
+
+func (mysqlDialect) Returning(columns ...string) string {
+	return ""
+}
+
+func (mysqlDialect) LockRows() string {
+	return " FOR UPDATE"
+}
+
+func (mysqlDialect) TimeValue(t time.Time) interface{} {
+	return t
+}
+
+func (mysqlDialect) Quote(identifier string) string {
+	return "`" + strings.Replace(identifier, "`", "``", -1) + "`"
+}
+
+type postgresDialect struct{}
+
+func (postgresDialect) DriverName() string {
+	return "postgres"
+}
+
+func (postgresDialect) Placeholder(n int) string {
+	return "$" + strconv.Itoa(n)
+}
+
+func (postgresDialect) Paginate(offset, limit int) (string, []interface{}) {
+	return " LIMIT ? OFFSET ?", []interface{}{limit, offset}
+}
+
+func (postgresDialect) Upsert(table string, columns, conflict, updates []string) string {
+	set := make([]string, len(updates))
+	for i, column := range updates {
+		set[i] = column + " = EXCLUDED." + column
+	}
+	return insertStatement(table, columns) + " ON CONFLICT (" + strings.Join(conflict, ", ") + ") DO UPDATE SET " + strings.Join(set, ", ")
+}
+
+func (postgresDialect) Returning(columns ...string) string {
+	return " RETURNING " + strings.Join(columns, ", ")
+}
+
+func (postgresDialect) LockRows() string {
+	return " FOR UPDATE"
+}
+
+// TimeValue returns t without time zone, as the columns are TIMESTAMP
+// and comparing them with a TIMESTAMPTZ depends on the session time zone
+func (postgresDialect) TimeValue(t time.Time) interface{} {
+	return t.Format(timestampFormat)
+}
+
+func (postgresDialect) Quote(identifier string) string {
+	return quoteIdentifier(identifier)
+}
+
+// quoteIdentifier quotes identifier the standard SQL way
+func quoteIdentifier(identifier string) string {
+	return `"` + strings.Replace(identifier, `"`, `""`, -1) + `"`
+}
+
+func insertStatement(table string, columns []string) string {
+	marks := strings.TrimSuffix(strings.Repeat("?, ", len(columns)), ", ")
+	return "INSERT INTO " + table + " (" + strings.Join(columns, ", ") + ") VALUES (" + marks + ")"
+}
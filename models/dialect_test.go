@@ -0,0 +1,176 @@
+/*
+Copyright (c) 2015, Alberto Corsín Lafuente
+All rights reserved.
+
+Redistribution and use in source and binary forms, with or without
+modification, are permitted provided that the following conditions are met:
+
+1. Redistributions of source code must retain the above copyright notice,
+   this list of conditions and the following disclaimer.
+
+2. Redistributions in binary form must reproduce the above copyright notice,
+   this list of conditions and the following disclaimer in the documentation
+   and/or other materials provided with the distribution.
+
+3. Neither the name of the copyright holder nor the names of its contributors
+   may be used to endorse or promote products derived from this software
+   without specific prior written permission.
+
+THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND
+ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED
+WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE
+FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER
+CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY,
+OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+*/
+
+package models
+
+import (
+	"database/sql"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseDBUri(t *testing.T) {
+	tests := []struct {
+		uri     string
+		dialect Dialect
+		dsn     string
+	}{
+		{"postgres://u:p@db/casimiro", PostgreSQL, "postgres://u:p@db/casimiro"},
+		{"postgresql://u:p@db/casimiro", PostgreSQL, "postgresql://u:p@db/casimiro"},
+		{"sqlite:///var/lib/casimiro.db", SQLite, "/var/lib/casimiro.db?_foreign_keys=1"},
+		{"sqlite:///var/lib/casimiro.db?_timeout=5000", SQLite, "/var/lib/casimiro.db?_timeout=5000&_foreign_keys=1"},
+		{"sqlite::memory:", SQLite, ":memory:?_foreign_keys=1"},
+	}
+	for _, test := range tests {
+		dialect, dsn, err := ParseDBUri(test.uri)
+		if err != nil || dialect != test.dialect || dsn != test.dsn {
+			t.Errorf("%s: unexpected %T %q %v", test.uri, dialect, dsn, err)
+		}
+	}
+
+	for _, uri := range []string{"mysql://u:p@tcp(db:3306)/casimiro", "u:p@tcp(db:3306)/casimiro"} {
+		dialect, dsn, err := ParseDBUri(uri)
+		if err != nil || dialect != MySQL {
+			t.Errorf("%s: unexpected %T %v", uri, dialect, err)
+			continue
+		}
+		for _, option := range []string{"parseTime=true", "clientFoundRows=true", "/casimiro"} {
+			if !strings.Contains(dsn, option) {
+				t.Errorf("%s: %q doesn't contain %s", uri, dsn, option)
+			}
+		}
+	}
+
+	if _, _, err := ParseDBUri("mysql://not a dsn"); err == nil {
+		t.Error("expected an error for an invalid MySQL dsn")
+	}
+}
+
+func TestRebind(t *testing.T) {
+	query := "SELECT id FROM resources WHERE user_id = ? AND id IN (?, ?)"
+	if rebound := Rebind(MySQL, query); rebound != query {
+		t.Errorf("MySQL: unexpected %s", rebound)
+	}
+	if rebound := Rebind(SQLite, query); rebound != query {
+		t.Errorf("SQLite: unexpected %s", rebound)
+	}
+	expected := "SELECT id FROM resources WHERE user_id = $1 AND id IN ($2, $3)"
+	if rebound := Rebind(PostgreSQL, query); rebound != expected {
+		t.Errorf("PostgreSQL: unexpected %s", rebound)
+	}
+}
+
+func TestDialects(t *testing.T) {
+	at := time.Date(2024, 1, 2, 3, 4, 5, 6000, time.UTC)
+	tests := []struct {
+		dialect   Dialect
+		driver    string
+		paginate  string
+		args      []interface{}
+		upsert    string
+		returning string
+		lock      string
+		time      interface{}
+		name      string
+		quoted    string
+	}{
+		{
+			MySQL, "mysql", " LIMIT ?, ?", []interface{}{20, 10},
+			"INSERT INTO t (id, name, size) VALUES (?, ?, ?) ON DUPLICATE KEY UPDATE name = VALUES(name), size = VALUES(size)",
+			"", " FOR UPDATE", at, "a`b", "`a``b`",
+		},
+		{
+			PostgreSQL, "postgres", " LIMIT ? OFFSET ?", []interface{}{10, 20},
+			"INSERT INTO t (id, name, size) VALUES (?, ?, ?) ON CONFLICT (id) DO UPDATE SET name = EXCLUDED.name, size = EXCLUDED.size",
+			" RETURNING created_at, updated_at", " FOR UPDATE", "2024-01-02 03:04:05.000006", `a"b`, `"a""b"`,
+		},
+		{
+			SQLite, "sqlite3", " LIMIT ? OFFSET ?", []interface{}{10, 20},
+			"INSERT INTO t (id, name, size) VALUES (?, ?, ?) ON CONFLICT (id) DO UPDATE SET name = excluded.name, size = excluded.size",
+			"", "", "2024-01-02 03:04:05.000006", `a"b`, `"a""b"`,
+		},
+	}
+	for _, test := range tests {
+		name := test.driver
+		if driver := test.dialect.DriverName(); driver != test.driver {
+			t.Errorf("%s: unexpected driver %s", name, driver)
+		}
+		if paginate, args := test.dialect.Paginate(20, 10); paginate != test.paginate || !reflect.DeepEqual(args, test.args) {
+			t.Errorf("%s: unexpected paging %q %v", name, paginate, args)
+		}
+		if upsert := test.dialect.Upsert("t", []string{"id", "name", "size"}, []string{"id"}, []string{"name", "size"}); upsert != test.upsert {
+			t.Errorf("%s: unexpected upsert %s", name, upsert)
+		}
+		if returning := test.dialect.Returning("created_at", "updated_at"); returning != test.returning {
+			t.Errorf("%s: unexpected returning %q", name, returning)
+		}
+		if lock := test.dialect.LockRows(); lock != test.lock {
+			t.Errorf("%s: unexpected lock %q", name, lock)
+		}
+		if value := test.dialect.TimeValue(at); value != test.time {
+			t.Errorf("%s: unexpected time value %v", name, value)
+		}
+		if quoted := test.dialect.Quote(test.name); quoted != test.quoted {
+			t.Errorf("%s: unexpected quoting %s", name, quoted)
+		}
+	}
+}
+
+func TestSQLiteUpsert(t *testing.T) {
+	db, err := sql.Open("sqlite3", ":memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	if _, err = db.Exec("CREATE TABLE t (id TEXT PRIMARY KEY, name TEXT NOT NULL, size INTEGER NOT NULL)"); err != nil {
+		t.Fatal(err)
+	}
+
+	upsert := SQLite.Upsert("t", []string{"id", "name", "size"}, []string{"id"}, []string{"name"})
+	for _, args := range [][]interface{}{{"a", "first", 1}, {"a", "second", 2}, {"b", "other", 3}} {
+		if _, err = db.Exec(upsert, args...); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	var name string
+	var size, count int
+	if err = db.QueryRow("SELECT name, size FROM t WHERE id = 'a'").Scan(&name, &size); err != nil {
+		t.Fatal(err)
+	}
+	if name != "second" || size != 1 {
+		t.Errorf("expected the name updated and the size kept, got %s %d", name, size)
+	}
+	if err = db.QueryRow("SELECT COUNT(*) FROM t").Scan(&count); err != nil || count != 2 {
+		t.Errorf("expected 2 rows, got %d %v", count, err)
+	}
+}
@@ -0,0 +1,150 @@
+/*
+Copyright (c) 2015, Alberto Corsín Lafuente
+All rights reserved.
+
+Redistribution and use in source and binary forms, with or without
+modification, are permitted provided that the following conditions are met:
+
+1. Redistributions of source code must retain the above copyright notice,
+   this list of conditions and the following disclaimer.
+
+2. Redistributions in binary form must reproduce the above copyright notice,
+   this list of conditions and the following disclaimer in the documentation
+   and/or other materials provided with the distribution.
+
+3. Neither the name of the copyright holder nor the names of its contributors
+   may be used to endorse or promote products derived from this software
+   without specific prior written permission.
+
+THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND
+ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED
+WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE
+FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER
+CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY,
+OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+*/
+
+package models
+
+import (
+	"database/sql"
+	"errors"
+	"github.com/go-sql-driver/mysql"
+	"github.com/lib/pq"
+	"github.com/mattn/go-sqlite3"
+	"go.mongodb.org/mongo-driver/mongo"
+	"strings"
+)
+
+// Storage errors the api package maps to responses. Errors returned by the
+// stores are matched against them with errors.Is, the driver error stays
+// available through errors.As.
+var (
+	ErrNotFound         = errors.New("Resource not found")
+	ErrDuplicate        = errors.New("Resource already exists")
+	ErrInvalidReference = errors.New("Resource references a missing one")
+	ErrReferenced       = errors.New("Resource is referenced by others")
+	ErrRetry            = errors.New("Storage busy, try again")
+)
+
+// storeError classifies a driver error as one of the errors above
+type storeError struct {
+	kind  error
+	cause error
+}
+
+func (e *storeError) Error() string {
+	return e.kind.Error() + ": " + e.cause.Error()
+}
+
+func (e *storeError) Is(target error) bool {
+	return target == e.kind
+}
+
+func (e *storeError) Unwrap() error {
+	return e.cause
+}
+
+// errNoRows is returned by every store when a row doesn't exist, it also
+// matches sql.ErrNoRows
+var errNoRows = &storeError{ErrNotFound, sql.ErrNoRows}
+
+// classifyError turns the driver errors the api can act on into storage
+// errors, other errors are returned unchanged:
+//
+//	no rows                            ErrNotFound
+//	duplicate key (MySQL 1062)         ErrDuplicate
+//	row still referenced (MySQL 1451)  ErrReferenced
+//	missing reference (MySQL 1452)     ErrInvalidReference
+//	deadlock, lock timeout (1213/1205) ErrRetry
+//
+// and their PostgreSQL, SQLite and MongoDB equivalents. SQLite doesn't tell
+// both foreign key violations apart, they are all ErrInvalidReference.
+func classifyError(err error) error {
+	var mysqlErr *mysql.MySQLError
+	var pqErr *pq.Error
+	var sqliteErr sqlite3.Error
+
+	var kind error
+	switch {
+	case err == nil:
+		return nil
+	case errors.Is(err, sql.ErrNoRows), errors.Is(err, mongo.ErrNoDocuments):
+		kind = ErrNotFound
+	case mongo.IsDuplicateKeyError(err):
+		kind = ErrDuplicate
+	case errors.As(err, &mysqlErr):
+		switch mysqlErr.Number {
+		case 1062:
+			kind = ErrDuplicate
+		case 1451:
+			kind = ErrReferenced
+		case 1452:
+			kind = ErrInvalidReference
+		case 1205, 1213:
+			kind = ErrRetry
+		}
+	case errors.As(err, &pqErr):
+		switch pqErr.Code {
+		case "23505":
+			kind = ErrDuplicate
+		case "23503":
+			kind = ErrInvalidReference
+			if strings.Contains(pqErr.Detail, "is still referenced") {
+				kind = ErrReferenced
+			}
+		case "40001", "40P01", "55P03":
+			kind = ErrRetry
+		}
+	case errors.As(err, &sqliteErr):
+		switch {
+		case sqliteErr.ExtendedCode == sqlite3.ErrConstraintPrimaryKey, sqliteErr.ExtendedCode == sqlite3.ErrConstraintUnique:
+			kind = ErrDuplicate
+		case sqliteErr.ExtendedCode == sqlite3.ErrConstraintForeignKey:
+			kind = ErrInvalidReference
+		case sqliteErr.Code == sqlite3.ErrBusy, sqliteErr.Code == sqlite3.ErrLocked:
+			kind = ErrRetry
+		}
+	}
+
+	if kind == nil {
+		return err
+	}
+	return &storeError{kind, err}
+}
+
+// affectedRow returns ErrNotFound when result didn't change any row
+func affectedRow(result sql.Result) error {
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if affected == 0 {
+		return errNoRows
+	}
+	return nil
+}
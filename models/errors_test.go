@@ -0,0 +1,72 @@
+/*
+Copyright (c) 2015, Alberto Corsín Lafuente
+All rights reserved.
+
+Redistribution and use in source and binary forms, with or without
+modification, are permitted provided that the following conditions are met:
+
+1. Redistributions of source code must retain the above copyright notice,
+   this list of conditions and the following disclaimer.
+
+2. Redistributions in binary form must reproduce the above copyright notice,
+   this list of conditions and the following disclaimer in the documentation
+   and/or other materials provided with the distribution.
+
+3. Neither the name of the copyright holder nor the names of its contributors
+   may be used to endorse or promote products derived from this software
+   without specific prior written permission.
+
+THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND
+ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED
+WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE
+FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER
+CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY,
+OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+*/
+
+package models
+
+import (
+	"database/sql"
+	"errors"
+	"github.com/go-sql-driver/mysql"
+	"github.com/lib/pq"
+	"testing"
+)
+
+func TestClassifyError(t *testing.T) {
+	tests := []struct {
+		err  error
+		kind error
+	}{
+		{sql.ErrNoRows, ErrNotFound},
+		{&mysql.MySQLError{Number: 1062}, ErrDuplicate},
+		{&mysql.MySQLError{Number: 1451}, ErrReferenced},
+		{&mysql.MySQLError{Number: 1452}, ErrInvalidReference},
+		{&mysql.MySQLError{Number: 1213}, ErrRetry},
+		{&pq.Error{Code: "23505"}, ErrDuplicate},
+		{&pq.Error{Code: "23503", Detail: `Key (id)=(1) is still referenced from table "items".`}, ErrReferenced},
+		{&pq.Error{Code: "23503", Detail: `Key (parent_id)=(1) is not present in table "items".`}, ErrInvalidReference},
+		{&pq.Error{Code: "40P01"}, ErrRetry},
+	}
+	for _, test := range tests {
+		err := classifyError(test.err)
+		if !errors.Is(err, test.kind) {
+			t.Errorf("%v: expected %v, got %v", test.err, test.kind, err)
+		}
+		if !errors.Is(err, test.err) {
+			t.Errorf("%v: the driver error is lost", test.err)
+		}
+	}
+}
+
+func TestClassifyErrorKeepsOtherErrors(t *testing.T) {
+	other := errors.New("connection refused")
+	if err := classifyError(other); err != other {
+		t.Fatalf("expected the error unchanged, got %v", err)
+	}
+}
@@ -0,0 +1,244 @@
+/*
+Copyright (c) 2015, Alberto Corsín Lafuente
+All rights reserved.
+
+Redistribution and use in source and binary forms, with or without
+modification, are permitted provided that the following conditions are met:
+
+1. Redistributions of source code must retain the above copyright notice,
+   this list of conditions and the following disclaimer.
+
+2. Redistributions in binary form must reproduce the above copyright notice,
+   this list of conditions and the following disclaimer in the documentation
+   and/or other materials provided with the distribution.
+
+3. Neither the name of the copyright holder nor the names of its contributors
+   may be used to endorse or promote products derived from this software
+   without specific prior written permission.
+
+THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND
+ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED
+WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE
+FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER
+CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY,
+OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+*/
+
+package models
+
+import (
+	"github.com/acorsinl/casimiro/system"
+	"go.mongodb.org/mongo-driver/bson"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// ResourceFilters are the Resource fields that can be used in $filter
+var ResourceFilters = map[string]system.FilterType{
+	"id":        system.FilterString,
+	"createdAt": system.FilterTime,
+	"updatedAt": system.FilterTime,
+}
+
+// resourceFieldColumns maps the fields used in queries to their SQL columns
+var resourceFieldColumns = map[string]string{
+	"id":        "id",
+	"createdAt": "created_at",
+	"updatedAt": "updated_at",
+}
+
+// resourceFieldKeys maps the fields used in queries to their MongoDB keys
+var resourceFieldKeys = map[string]string{
+	"id":        "_id",
+	"createdAt": "created_at",
+	"updatedAt": "updated_at",
+}
+
+var sqlOperators = map[string]string{
+	"eq": "=", "ne": "<>", "gt": ">", "lt": "<", "ge": ">=", "le": "<=",
+}
+
+var mongoOperators = map[string]string{
+	"ne": "$ne", "gt": "$gt", "lt": "$lt", "ge": "$gte", "le": "$lte",
+}
+
+// likeEscaper escapes the LIKE wildcards with '!', which unlike a backslash
+// needs no escaping itself in any of the supported dialects
+var likeEscaper = strings.NewReplacer("!", "!!", "%", "!%", "_", "!_")
+
+// sqlFilter compiles filter into a SQL condition with "?" placeholders.
+// Columns only come from the columns map, values always go in args.
+func sqlFilter(filter system.Filter, columns map[string]string, dialect Dialect) (string, []interface{}) {
+	switch f := filter.(type) {
+	case system.FilterAnd:
+		left, leftArgs := sqlFilter(f.Left, columns, dialect)
+		right, rightArgs := sqlFilter(f.Right, columns, dialect)
+		return "(" + left + " AND " + right + ")", append(leftArgs, rightArgs...)
+	case system.FilterOr:
+		left, leftArgs := sqlFilter(f.Left, columns, dialect)
+		right, rightArgs := sqlFilter(f.Right, columns, dialect)
+		return "(" + left + " OR " + right + ")", append(leftArgs, rightArgs...)
+	case system.FilterNot:
+		condition, args := sqlFilter(f.Filter, columns, dialect)
+		return "NOT " + condition, args
+	case system.FilterCompare:
+		column := columns[f.Field]
+		if f.Value == nil {
+			if f.Operator == "eq" {
+				return "(" + column + " IS NULL)", nil
+			}
+			return "(" + column + " IS NOT NULL)", nil
+		}
+		return "(" + column + " " + sqlOperators[f.Operator] + " ?)", []interface{}{sqlValue(f.Value, dialect)}
+	case system.FilterIn:
+		args := make([]interface{}, len(f.Values))
+		for i, value := range f.Values {
+			args[i] = sqlValue(value, dialect)
+		}
+		placeholders := strings.TrimSuffix(strings.Repeat("?, ", len(args)), ", ")
+		return "(" + columns[f.Field] + " IN (" + placeholders + "))", args
+	case system.FilterFunction:
+		// Both sides are lowered, as LIKE is case sensitive in PostgreSQL
+		// but not in MySQL or SQLite
+		pattern := likeEscaper.Replace(strings.ToLower(f.Value)) + "%"
+		if f.Function == "contains" {
+			pattern = "%" + pattern
+		}
+		return "(LOWER(" + columns[f.Field] + ") LIKE ? ESCAPE '!')", []interface{}{pattern}
+	}
+	return "", nil
+}
+
+func sqlValue(value interface{}, dialect Dialect) interface{} {
+	if t, ok := value.(time.Time); ok {
+		return dialect.TimeValue(t)
+	}
+	return value
+}
+
+// mongoFilter compiles filter into a MongoDB query document
+func mongoFilter(filter system.Filter, keys map[string]string) bson.M {
+	switch f := filter.(type) {
+	case system.FilterAnd:
+		return bson.M{"$and": bson.A{mongoFilter(f.Left, keys), mongoFilter(f.Right, keys)}}
+	case system.FilterOr:
+		return bson.M{"$or": bson.A{mongoFilter(f.Left, keys), mongoFilter(f.Right, keys)}}
+	case system.FilterNot:
+		return bson.M{"$nor": bson.A{mongoFilter(f.Filter, keys)}}
+	case system.FilterCompare:
+		if f.Operator == "eq" {
+			return bson.M{keys[f.Field]: f.Value}
+		}
+		return bson.M{keys[f.Field]: bson.M{mongoOperators[f.Operator]: f.Value}}
+	case system.FilterIn:
+		return bson.M{keys[f.Field]: bson.M{"$in": f.Values}}
+	case system.FilterFunction:
+		pattern := "^" + regexp.QuoteMeta(f.Value)
+		if f.Function == "contains" {
+			pattern = regexp.QuoteMeta(f.Value)
+		}
+		return bson.M{keys[f.Field]: bson.M{"$regex": pattern, "$options": "i"}}
+	}
+	return bson.M{}
+}
+
+// matchFilter evaluates filter in memory, field returns the value of a
+// filterable field with the type given in the allow-list
+func matchFilter(filter system.Filter, field func(name string) interface{}) bool {
+	switch f := filter.(type) {
+	case system.FilterAnd:
+		return matchFilter(f.Left, field) && matchFilter(f.Right, field)
+	case system.FilterOr:
+		return matchFilter(f.Left, field) || matchFilter(f.Right, field)
+	case system.FilterNot:
+		return !matchFilter(f.Filter, field)
+	case system.FilterCompare:
+		value := field(f.Field)
+		if f.Value == nil || value == nil {
+			return (f.Value == nil && value == nil) == (f.Operator == "eq")
+		}
+		order := compareValues(value, f.Value)
+		switch f.Operator {
+		case "eq":
+			return order == 0
+		case "ne":
+			return order != 0
+		case "gt":
+			return order > 0
+		case "lt":
+			return order < 0
+		case "ge":
+			return order >= 0
+		case "le":
+			return order <= 0
+		}
+	case system.FilterIn:
+		value := field(f.Field)
+		for _, candidate := range f.Values {
+			if value != nil && compareValues(value, candidate) == 0 {
+				return true
+			}
+		}
+	case system.FilterFunction:
+		value, _ := field(f.Field).(string)
+		value, search := strings.ToLower(value), strings.ToLower(f.Value)
+		if f.Function == "contains" {
+			return strings.Contains(value, search)
+		}
+		return strings.HasPrefix(value, search)
+	}
+	return false
+}
+
+// compareValues orders two values of the same filter type
+func compareValues(a, b interface{}) int {
+	switch a := a.(type) {
+	case string:
+		return strings.Compare(a, b.(string))
+	case float64:
+		switch b := b.(float64); {
+		case a < b:
+			return -1
+		case a > b:
+			return 1
+		}
+	case bool:
+		b := b.(bool)
+		switch {
+		case a == b:
+			return 0
+		case b:
+			return -1
+		default:
+			return 1
+		}
+	case time.Time:
+		switch b := b.(time.Time); {
+		case a.Before(b):
+			return -1
+		case a.After(b):
+			return 1
+		}
+	}
+	return 0
+}
+
+// resourceField returns the value of one of the ResourceFilters
+func resourceField(resource *Resource) func(name string) interface{} {
+	return func(name string) interface{} {
+		switch name {
+		case "id":
+			return resource.Id
+		case "createdAt":
+			return resource.CreatedAt
+		case "updatedAt":
+			return resource.UpdatedAt
+		}
+		return nil
+	}
+}
@@ -0,0 +1,125 @@
+/*
+Copyright (c) 2015, Alberto Corsín Lafuente
+All rights reserved.
+
+Redistribution and use in source and binary forms, with or without
+modification, are permitted provided that the following conditions are met:
+
+1. Redistributions of source code must retain the above copyright notice,
+   this list of conditions and the following disclaimer.
+
+2. Redistributions in binary form must reproduce the above copyright notice,
+   this list of conditions and the following disclaimer in the documentation
+   and/or other materials provided with the distribution.
+
+3. Neither the name of the copyright holder nor the names of its contributors
+   may be used to endorse or promote products derived from this software
+   without specific prior written permission.
+
+THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND
+ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED
+WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE
+FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER
+CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY,
+OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+*/
+
+package models
+
+import (
+	"database/sql"
+	"github.com/acorsinl/casimiro/system"
+	_ "github.com/mattn/go-sqlite3"
+	"go.mongodb.org/mongo-driver/bson"
+	"reflect"
+	"testing"
+)
+
+var functionFilterTests = []struct {
+	expression string
+	matches    []string
+}{
+	{"contains(id, 'BC')", []string{"abcd", "xAbC"}},
+	{"contains(id, 'bc')", []string{"abcd", "xAbC"}},
+	{"startswith(id, 'AB')", []string{"abcd"}},
+	{"startswith(id, 'x')", []string{"xAbC"}},
+	{"contains(id, '%')", []string{"50%"}},
+}
+
+var functionFilterIds = []string{"abcd", "xAbC", "50%", "other"}
+
+func TestFunctionFiltersIgnoreCaseInMemory(t *testing.T) {
+	for _, test := range functionFilterTests {
+		filter, err := system.ParseFilter(test.expression, ResourceFilters)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		var matches []string
+		for _, id := range functionFilterIds {
+			resource := Resource{Id: id}
+			if matchFilter(filter, resourceField(&resource)) {
+				matches = append(matches, id)
+			}
+		}
+		if !reflect.DeepEqual(matches, test.matches) {
+			t.Errorf("%s: expected %v, got %v", test.expression, test.matches, matches)
+		}
+	}
+}
+
+func TestFunctionFiltersIgnoreCaseInSQL(t *testing.T) {
+	db, err := sql.Open("sqlite3", ":memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	if _, err = db.Exec("CREATE TABLE resources (id TEXT PRIMARY KEY)"); err != nil {
+		t.Fatal(err)
+	}
+	for _, id := range functionFilterIds {
+		if _, err = db.Exec("INSERT INTO resources (id) VALUES (?)", id); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	for _, test := range functionFilterTests {
+		filter, err := system.ParseFilter(test.expression, ResourceFilters)
+		if err != nil {
+			t.Fatal(err)
+		}
+		condition, args := sqlFilter(filter, resourceFieldColumns, SQLite)
+		rows, err := db.Query(Rebind(SQLite, "SELECT id FROM resources WHERE "+condition+" ORDER BY rowid"), args...)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		var matches []string
+		for rows.Next() {
+			var id string
+			if err = rows.Scan(&id); err != nil {
+				t.Fatal(err)
+			}
+			matches = append(matches, id)
+		}
+		rows.Close()
+		if !reflect.DeepEqual(matches, test.matches) {
+			t.Errorf("%s: expected %v, got %v", test.expression, test.matches, matches)
+		}
+	}
+}
+
+func TestFunctionFiltersIgnoreCaseInMongo(t *testing.T) {
+	filter, err := system.ParseFilter("contains(id, 'a.B')", ResourceFilters)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := bson.M{"_id": bson.M{"$regex": `a\.B`, "$options": "i"}}
+	if query := mongoFilter(filter, resourceFieldKeys); !reflect.DeepEqual(query, expected) {
+		t.Errorf("expected %v, got %v", expected, query)
+	}
+}
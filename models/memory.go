@@ -0,0 +1,220 @@
+/*
+Copyright (c) 2015, Alberto Corsín Lafuente
+All rights reserved.
+
+Redistribution and use in source and binary forms, with or without
+modification, are permitted provided that the following conditions are met:
+
+1. Redistributions of source code must retain the above copyright notice,
+   this list of conditions and the following disclaimer.
+
+2. Redistributions in binary form must reproduce the above copyright notice,
+   this list of conditions and the following disclaimer in the documentation
+   and/or other materials provided with the distribution.
+
+3. Neither the name of the copyright holder nor the names of its contributors
+   may be used to endorse or promote products derived from this software
+   without specific prior written permission.
+
+THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND
+ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED
+WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE
+FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER
+CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY,
+OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+*/
+
+package models
+
+import (
+	"errors"
+	"github.com/acorsinl/casimiro/system"
+	"sort"
+	"sync"
+	"time"
+)
+
+var ErrDuplicateResource = &storeError{ErrDuplicate, errors.New("Duplicate resource id")}
+
+// MemoryModel keeps resources in memory. It is safe for concurrent use and
+// behaves like Model: lookups of missing or foreign resources return
+// ErrNotFound, also for updates and deletes.
+type MemoryModel struct {
+	mutex     sync.RWMutex
+	resources map[string]Resource
+	apiKeys   map[string]APIKey
+	users     map[string]User
+	sessions  map[string]Session
+}
+
+var _ ResourceStore = (*MemoryModel)(nil)
+
+func NewMemoryModel() *MemoryModel {
+	return &MemoryModel{
+		resources: make(map[string]Resource),
+		apiKeys:   make(map[string]APIKey),
+		users:     make(map[string]User),
+		sessions:  make(map[string]Session),
+	}
+}
+
+func (m *MemoryModel) InsertResource(resource *Resource) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	if _, ok := m.resources[resource.Id]; ok {
+		return ErrDuplicateResource
+	}
+
+	now := time.Now().UTC()
+	resource.CreatedAt = now
+	resource.UpdatedAt = now
+	m.resources[resource.Id] = *resource
+	return nil
+}
+
+func (m *MemoryModel) GetResourceById(userId, resourceId string) (*Resource, error) {
+	m.mutex.RLock()
+	defer m.mutex.RUnlock()
+
+	resource, ok := m.resources[resourceId]
+	if !ok || resource.UserId != userId {
+		return &Resource{}, errNoRows
+	}
+	return &resource, nil
+}
+
+func (m *MemoryModel) GetResources(userId string, list ListQuery) ([]Resource, error) {
+	var owned []Resource
+	m.mutex.RLock()
+	for _, resource := range m.resources {
+		if resource.UserId == userId && (list.Filter == nil || matchFilter(list.Filter, resourceField(&resource))) {
+			owned = append(owned, resource)
+		}
+	}
+	m.mutex.RUnlock()
+
+	order := ResourceOrder(list.Order)
+	sort.Slice(owned, func(i, j int) bool {
+		return CursorOf(&owned[i], order).Compare(CursorOf(&owned[j], order), order) < 0
+	})
+
+	start, end := list.Offset, len(owned)
+	switch {
+	case list.After != nil:
+		start = sort.Search(len(owned), func(i int) bool {
+			return list.After.Compare(CursorOf(&owned[i], order), order) < 0
+		})
+	case list.Before != nil:
+		end = sort.Search(len(owned), func(i int) bool {
+			return CursorOf(&owned[i], order).Compare(list.Before, order) >= 0
+		})
+		start = end - list.Limit
+	}
+	if start < 0 {
+		start = 0
+	}
+	if start > end {
+		start = end
+	}
+	if end-start > list.Limit {
+		end = start + list.Limit
+	}
+
+	resources := make([]Resource, 0, end-start)
+	for _, resource := range owned[start:end] {
+		resource.Href = system.ResourcesUrl + "/" + resource.Id
+		resources = append(resources, resource)
+	}
+	return resources, nil
+}
+
+func (m *MemoryModel) CountResources(userId string, filter system.Filter) (int, error) {
+	m.mutex.RLock()
+	defer m.mutex.RUnlock()
+
+	count := 0
+	for _, resource := range m.resources {
+		if resource.UserId == userId && (filter == nil || matchFilter(filter, resourceField(&resource))) {
+			count++
+		}
+	}
+	return count, nil
+}
+
+func (m *MemoryModel) AggregateResources(userId string, query AggregateQuery) ([]map[string]interface{}, error) {
+	var owned []Resource
+	m.mutex.RLock()
+	for _, resource := range m.resources {
+		if resource.UserId == userId && (query.Filter == nil || matchFilter(query.Filter, resourceField(&resource))) {
+			owned = append(owned, resource)
+		}
+	}
+	m.mutex.RUnlock()
+
+	return aggregateResources(owned, query), nil
+}
+
+func (m *MemoryModel) ResourceExists(resourceId string) (bool, error) {
+	m.mutex.RLock()
+	defer m.mutex.RUnlock()
+
+	_, ok := m.resources[resourceId]
+	return ok, nil
+}
+
+func (m *MemoryModel) DeleteResourceById(userId, resourceId string) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	resource, ok := m.resources[resourceId]
+	if !ok || resource.UserId != userId {
+		return errNoRows
+	}
+
+	delete(m.resources, resourceId)
+	return nil
+}
+
+func (m *MemoryModel) ModifyResource(userId, resourceId string, modify func(resource *Resource) error) (*Resource, error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	stored, ok := m.resources[resourceId]
+	if !ok || stored.UserId != userId {
+		return nil, errNoRows
+	}
+
+	resource := stored
+	if err := modify(&resource); err != nil {
+		return nil, err
+	}
+
+	stored.Href = resource.Href
+	stored.UpdatedAt = time.Now().UTC()
+	m.resources[resourceId] = stored
+	return &stored, nil
+}
+
+func (m *MemoryModel) UpdateResource(resource *Resource, userId string) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	stored, ok := m.resources[resource.Id]
+	if !ok || stored.UserId != userId {
+		return errNoRows
+	}
+
+	stored.Href = resource.Href
+	stored.UpdatedAt = time.Now().UTC()
+	m.resources[resource.Id] = stored
+
+	resource.UserId = stored.UserId
+	resource.CreatedAt = stored.CreatedAt
+	resource.UpdatedAt = stored.UpdatedAt
+	return nil
+}
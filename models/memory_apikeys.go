@@ -0,0 +1,157 @@
+/*
+Copyright (c) 2015, Alberto Corsín Lafuente
+All rights reserved.
+
+Redistribution and use in source and binary forms, with or without
+modification, are permitted provided that the following conditions are met:
+
+1. Redistributions of source code must retain the above copyright notice,
+   this list of conditions and the following disclaimer.
+
+2. Redistributions in binary form must reproduce the above copyright notice,
+   this list of conditions and the following disclaimer in the documentation
+   and/or other materials provided with the distribution.
+
+3. Neither the name of the copyright holder nor the names of its contributors
+   may be used to endorse or promote products derived from this software
+   without specific prior written permission.
+
+THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND
+ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED
+WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE
+FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER
+CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY,
+OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+*/
+
+package models
+
+import (
+	"sort"
+	"time"
+)
+
+var _ APIKeyStore = (*MemoryModel)(nil)
+
+func (m *MemoryModel) InsertAPIKey(key *APIKey) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	if _, ok := m.apiKeys[key.Id]; ok {
+		return ErrDuplicateResource
+	}
+	for _, stored := range m.apiKeys {
+		if stored.Hash == key.Hash {
+			return ErrDuplicateResource
+		}
+	}
+
+	now := time.Now().UTC()
+	key.CreatedAt = now
+	key.UpdatedAt = now
+	m.apiKeys[key.Id] = copyAPIKey(key)
+	return nil
+}
+
+func (m *MemoryModel) GetAPIKeyById(userId, keyId string) (*APIKey, error) {
+	m.mutex.RLock()
+	defer m.mutex.RUnlock()
+
+	key, ok := m.apiKeys[keyId]
+	if !ok || key.UserId != userId {
+		return nil, errNoRows
+	}
+	key = copyAPIKey(&key)
+	return &key, nil
+}
+
+func (m *MemoryModel) GetAPIKeyByHash(hash string) (*APIKey, error) {
+	m.mutex.RLock()
+	defer m.mutex.RUnlock()
+
+	for _, key := range m.apiKeys {
+		if key.Hash == hash {
+			key = copyAPIKey(&key)
+			return &key, nil
+		}
+	}
+	return nil, errNoRows
+}
+
+func (m *MemoryModel) GetAPIKeys(userId string, offset, limit int) ([]APIKey, error) {
+	m.mutex.RLock()
+	defer m.mutex.RUnlock()
+
+	var keys []APIKey
+	for _, key := range m.apiKeys {
+		if key.UserId == userId {
+			keys = append(keys, copyAPIKey(&key))
+		}
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		if !keys[i].CreatedAt.Equal(keys[j].CreatedAt) {
+			return keys[i].CreatedAt.Before(keys[j].CreatedAt)
+		}
+		return keys[i].Id < keys[j].Id
+	})
+
+	if offset >= len(keys) {
+		return nil, nil
+	}
+	keys = keys[offset:]
+	if limit < len(keys) {
+		keys = keys[:limit]
+	}
+	return keys, nil
+}
+
+func (m *MemoryModel) RotateAPIKey(userId, keyId, prefix, hash string, now time.Time) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	key, ok := m.apiKeys[keyId]
+	if !ok || key.UserId != userId || !key.Active(now) {
+		return errNoRows
+	}
+
+	key.Prefix = prefix
+	key.Hash = hash
+	key.UpdatedAt = time.Now().UTC()
+	m.apiKeys[keyId] = key
+	return nil
+}
+
+func (m *MemoryModel) RevokeAPIKey(userId, keyId string, now time.Time) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	key, ok := m.apiKeys[keyId]
+	if !ok || key.UserId != userId || key.RevokedAt != nil {
+		return errNoRows
+	}
+
+	revokedAt := now.UTC()
+	key.RevokedAt = &revokedAt
+	key.UpdatedAt = time.Now().UTC()
+	m.apiKeys[keyId] = key
+	return nil
+}
+
+// copyAPIKey returns a copy of key not sharing its scopes and times
+func copyAPIKey(key *APIKey) APIKey {
+	copied := *key
+	copied.Scopes = append([]string(nil), key.Scopes...)
+	if key.ExpiresAt != nil {
+		expiresAt := *key.ExpiresAt
+		copied.ExpiresAt = &expiresAt
+	}
+	if key.RevokedAt != nil {
+		revokedAt := *key.RevokedAt
+		copied.RevokedAt = &revokedAt
+	}
+	return copied
+}
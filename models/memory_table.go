@@ -0,0 +1,154 @@
+/*
+Copyright (c) 2015, Alberto Corsín Lafuente
+All rights reserved.
+
+Redistribution and use in source and binary forms, with or without
+modification, are permitted provided that the following conditions are met:
+
+1. Redistributions of source code must retain the above copyright notice,
+   this list of conditions and the following disclaimer.
+
+2. Redistributions in binary form must reproduce the above copyright notice,
+   this list of conditions and the following disclaimer in the documentation
+   and/or other materials provided with the distribution.
+
+3. Neither the name of the copyright holder nor the names of its contributors
+   may be used to endorse or promote products derived from this software
+   without specific prior written permission.
+
+THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND
+ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED
+WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE
+FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER
+CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY,
+OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+*/
+
+package models
+
+import (
+	"sync"
+)
+
+// MemoryTable is a Store keeping items in memory, with the same semantics
+// as MemoryModel
+type MemoryTable[T any] struct {
+	mutex   sync.RWMutex
+	columns []column
+	items   map[string]T
+	owners  map[string]string
+	order   []string
+}
+
+func NewMemoryTable[T any]() *MemoryTable[T] {
+	return &MemoryTable[T]{
+		columns: columns[T](),
+		items:   make(map[string]T),
+		owners:  make(map[string]string),
+	}
+}
+
+func (t *MemoryTable[T]) Insert(userId string, item *T) error {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	id := ItemId(item)
+	if _, ok := t.items[id]; ok {
+		return ErrDuplicateResource
+	}
+
+	setOwner(t.columns, item, userId)
+	t.items[id] = *item
+	t.owners[id] = userId
+	t.order = append(t.order, id)
+	return nil
+}
+
+func (t *MemoryTable[T]) GetById(userId, id string) (*T, error) {
+	t.mutex.RLock()
+	defer t.mutex.RUnlock()
+
+	item, ok := t.items[id]
+	if !ok || t.owners[id] != userId {
+		return nil, errNoRows
+	}
+	return &item, nil
+}
+
+func (t *MemoryTable[T]) List(userId string, offset, limit int) ([]T, error) {
+	var items []T
+	t.mutex.RLock()
+	defer t.mutex.RUnlock()
+
+	skipped := 0
+	for _, id := range t.order {
+		if len(items) >= limit {
+			break
+		}
+		if t.owners[id] != userId {
+			continue
+		}
+		if skipped < offset {
+			skipped++
+			continue
+		}
+		items = append(items, t.items[id])
+	}
+
+	return items, nil
+}
+
+func (t *MemoryTable[T]) Update(userId string, item *T) error {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	id := ItemId(item)
+	if _, ok := t.items[id]; !ok || t.owners[id] != userId {
+		return errNoRows
+	}
+
+	setOwner(t.columns, item, userId)
+	t.items[id] = *item
+	return nil
+}
+
+func (t *MemoryTable[T]) Modify(userId, id string, modify func(item *T) error) (*T, error) {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	item, ok := t.items[id]
+	if !ok || t.owners[id] != userId {
+		return nil, errNoRows
+	}
+
+	if err := modify(&item); err != nil {
+		return nil, err
+	}
+	SetItemId(&item, id)
+	setOwner(t.columns, &item, userId)
+	t.items[id] = item
+	return &item, nil
+}
+
+func (t *MemoryTable[T]) Delete(userId, id string) error {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	if _, ok := t.items[id]; !ok || t.owners[id] != userId {
+		return errNoRows
+	}
+
+	delete(t.items, id)
+	delete(t.owners, id)
+	for i, stored := range t.order {
+		if stored == id {
+			t.order = append(t.order[:i], t.order[i+1:]...)
+			break
+		}
+	}
+	return nil
+}
@@ -0,0 +1,186 @@
+/*
+Copyright (c) 2015, Alberto Corsín Lafuente
+All rights reserved.
+
+Redistribution and use in source and binary forms, with or without
+modification, are permitted provided that the following conditions are met:
+
+1. Redistributions of source code must retain the above copyright notice,
+   this list of conditions and the following disclaimer.
+
+2. Redistributions in binary form must reproduce the above copyright notice,
+   this list of conditions and the following disclaimer in the documentation
+   and/or other materials provided with the distribution.
+
+3. Neither the name of the copyright holder nor the names of its contributors
+   may be used to endorse or promote products derived from this software
+   without specific prior written permission.
+
+THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND
+ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED
+WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE
+FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER
+CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY,
+OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+*/
+
+package models
+
+import (
+	"time"
+)
+
+var _ UserStore = (*MemoryModel)(nil)
+
+func (m *MemoryModel) InsertUser(user *User) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	for _, stored := range m.users {
+		if stored.Id == user.Id || stored.Username == user.Username {
+			return ErrDuplicateResource
+		}
+	}
+
+	now := time.Now().UTC()
+	user.CreatedAt = now
+	user.UpdatedAt = now
+	m.users[user.Id] = copyUser(user)
+	return nil
+}
+
+func (m *MemoryModel) GetUserById(userId string) (*User, error) {
+	m.mutex.RLock()
+	defer m.mutex.RUnlock()
+
+	user, ok := m.users[userId]
+	if !ok {
+		return nil, errNoRows
+	}
+	user = copyUser(&user)
+	return &user, nil
+}
+
+func (m *MemoryModel) GetUserByName(username string) (*User, error) {
+	m.mutex.RLock()
+	defer m.mutex.RUnlock()
+
+	for _, user := range m.users {
+		if user.Username == username {
+			user = copyUser(&user)
+			return &user, nil
+		}
+	}
+	return nil, errNoRows
+}
+
+func (m *MemoryModel) RecordLoginAttempt(userId string, maxAttempts int, now, lockedUntil time.Time) (bool, error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	user, ok := m.users[userId]
+	if !ok || (user.LockedUntil != nil && now.Before(*user.LockedUntil)) {
+		return false, nil
+	}
+
+	user.FailedLogins++
+	if user.FailedLogins >= maxAttempts {
+		lockedUntil = lockedUntil.UTC()
+		user.LockedUntil = &lockedUntil
+		user.FailedLogins = 0
+	}
+	user.UpdatedAt = time.Now().UTC()
+	m.users[userId] = user
+	return true, nil
+}
+
+func (m *MemoryModel) ResetLoginAttempts(userId string) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	user, ok := m.users[userId]
+	if !ok {
+		return errNoRows
+	}
+
+	user.FailedLogins = 0
+	user.LockedUntil = nil
+	user.UpdatedAt = time.Now().UTC()
+	m.users[userId] = user
+	return nil
+}
+
+func (m *MemoryModel) InsertSession(session *Session) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	if _, ok := m.sessions[session.Id]; ok {
+		return ErrDuplicateResource
+	}
+
+	now := time.Now().UTC()
+	session.CreatedAt = now
+	session.UpdatedAt = now
+	m.sessions[session.Id] = *session
+	return nil
+}
+
+func (m *MemoryModel) GetSessionByAccessHash(hash string) (*Session, error) {
+	return m.findSession(func(session *Session) bool { return session.AccessHash == hash })
+}
+
+func (m *MemoryModel) GetSessionByRefreshHash(hash string) (*Session, error) {
+	return m.findSession(func(session *Session) bool { return session.RefreshHash == hash })
+}
+
+func (m *MemoryModel) findSession(match func(session *Session) bool) (*Session, error) {
+	m.mutex.RLock()
+	defer m.mutex.RUnlock()
+
+	for _, session := range m.sessions {
+		if match(&session) {
+			return &session, nil
+		}
+	}
+	return nil, errNoRows
+}
+
+func (m *MemoryModel) RotateSession(session *Session, refreshHash string) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	stored, ok := m.sessions[session.Id]
+	if !ok || stored.RefreshHash != refreshHash {
+		return errNoRows
+	}
+
+	session.CreatedAt = stored.CreatedAt
+	session.UpdatedAt = time.Now().UTC()
+	m.sessions[session.Id] = *session
+	return nil
+}
+
+func (m *MemoryModel) DeleteSession(sessionId string) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	if _, ok := m.sessions[sessionId]; !ok {
+		return errNoRows
+	}
+	delete(m.sessions, sessionId)
+	return nil
+}
+
+// copyUser returns a copy of user not sharing its lock time
+func copyUser(user *User) User {
+	copied := *user
+	if user.LockedUntil != nil {
+		lockedUntil := *user.LockedUntil
+		copied.LockedUntil = &lockedUntil
+	}
+	return copied
+}
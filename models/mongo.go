@@ -0,0 +1,407 @@
+/*
+Copyright (c) 2015, Alberto Corsín Lafuente
+All rights reserved.
+
+Redistribution and use in source and binary forms, with or without
+modification, are permitted provided that the following conditions are met:
+
+1. Redistributions of source code must retain the above copyright notice,
+   this list of conditions and the following disclaimer.
+
+2. Redistributions in binary form must reproduce the above copyright notice,
+   this list of conditions and the following disclaimer in the documentation
+   and/or other materials provided with the distribution.
+
+3. Neither the name of the copyright holder nor the names of its contributors
+   may be used to endorse or promote products derived from this software
+   without specific prior written permission.
+
+THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND
+ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED
+WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE
+FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER
+CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY,
+OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+*/
+
+package models
+
+import (
+	"context"
+	"fmt"
+	"github.com/acorsinl/casimiro/system"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"go.mongodb.org/mongo-driver/x/mongo/driver/connstring"
+	"log"
+	"time"
+)
+
+const (
+	MongoDefaultDatabase = "casimiro"
+	MongoResources       = "resources"
+	mongoTimeout         = 10 * time.Second
+)
+
+// MongoModel stores resources in a MongoDB collection. It implements
+// ResourceStore with the same semantics as Model.
+type MongoModel struct {
+	Client    *mongo.Client
+	Resources *mongo.Collection
+	APIKeys   *mongo.Collection
+	Users     *mongo.Collection
+	Sessions  *mongo.Collection
+}
+
+type mongoResource struct {
+	Id        string    `bson:"_id"`
+	UserId    string    `bson:"user_id"`
+	Href      string    `bson:"href"`
+	CreatedAt time.Time `bson:"created_at"`
+	UpdatedAt time.Time `bson:"updated_at"`
+	// Version is increased on every update, ModifyResource compares it
+	Version int64 `bson:"version"`
+}
+
+var _ ResourceStore = (*MongoModel)(nil)
+
+// InitDB connects to the MongoDB server given in dbUri and makes sure the
+// indexes needed by the queries below exist. The database name is taken
+// from the uri path, MongoDefaultDatabase is used if empty.
+func (m *MongoModel) InitDB(dbUri string) {
+	cs, err := connstring.ParseAndValidate(dbUri)
+	if err != nil {
+		log.Fatal("Can't parse MongoDB uri")
+	}
+	database := cs.Database
+	if database == "" {
+		database = MongoDefaultDatabase
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), mongoTimeout)
+	defer cancel()
+
+	client, err := mongo.Connect(ctx, options.Client().ApplyURI(dbUri))
+	if err != nil {
+		log.Fatal("Can't open database")
+	}
+
+	if err = client.Ping(ctx, nil); err != nil {
+		log.Fatal("Can't connect to database")
+	}
+
+	m.Client = client
+	m.Resources = client.Database(database).Collection(MongoResources)
+	m.APIKeys = client.Database(database).Collection(MongoAPIKeys)
+	m.Users = client.Database(database).Collection(MongoUsers)
+	m.Sessions = client.Database(database).Collection(MongoSessions)
+	if err = m.EnsureIndexes(ctx); err != nil {
+		log.Fatal("Can't create database indexes")
+	}
+
+	log.Println("Database connection stablished")
+}
+
+// EnsureIndexes creates the indexes used to list resources by owner, and
+// those of API keys, users and sessions
+func (m *MongoModel) EnsureIndexes(ctx context.Context) error {
+	_, err := m.Resources.Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys:    bson.D{{Key: "user_id", Value: 1}, {Key: "created_at", Value: 1}, {Key: "_id", Value: 1}},
+		Options: options.Index().SetName("user_created"),
+	})
+	if err != nil {
+		return err
+	}
+	if err = m.ensureAPIKeyIndexes(ctx); err != nil {
+		return err
+	}
+	return m.ensureUserIndexes(ctx)
+}
+
+func (m *MongoModel) InsertResource(resource *Resource) error {
+	ctx, cancel := context.WithTimeout(context.Background(), mongoTimeout)
+	defer cancel()
+
+	now := mongoNow()
+	_, err := m.Resources.InsertOne(ctx, mongoResource{
+		Id:        resource.Id,
+		UserId:    resource.UserId,
+		Href:      resource.Href,
+		CreatedAt: now,
+		UpdatedAt: now,
+	})
+	if err != nil {
+		return classifyError(err)
+	}
+
+	resource.CreatedAt = now
+	resource.UpdatedAt = now
+	return nil
+}
+
+func (m *MongoModel) GetResourceById(userId, resourceId string) (*Resource, error) {
+	var doc mongoResource
+	ctx, cancel := context.WithTimeout(context.Background(), mongoTimeout)
+	defer cancel()
+
+	err := m.Resources.FindOne(ctx, bson.M{"_id": resourceId, "user_id": userId}).Decode(&doc)
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			return &Resource{}, errNoRows
+		}
+		return &Resource{}, err
+	}
+
+	return doc.resource(), nil
+}
+
+func (m *MongoModel) GetResources(userId string, list ListQuery) ([]Resource, error) {
+	var resources []Resource
+	ctx, cancel := context.WithTimeout(context.Background(), mongoTimeout)
+	defer cancel()
+
+	var conditions bson.A
+	if list.Filter != nil {
+		conditions = append(conditions, mongoFilter(list.Filter, resourceFieldKeys))
+	}
+	order := ResourceOrder(list.Order)
+	opts := options.Find().SetLimit(int64(list.Limit))
+	switch {
+	case list.After != nil:
+		conditions = append(conditions, mongoKeyset(list.After, order, false, resourceFieldKeys))
+	case list.Before != nil:
+		if !list.Before.End {
+			conditions = append(conditions, mongoKeyset(list.Before, order, true, resourceFieldKeys))
+		}
+	default:
+		opts.SetSkip(int64(list.Offset))
+	}
+	opts.SetSort(mongoSort(order, list.Before != nil, resourceFieldKeys))
+	if list.Select != nil {
+		opts.SetProjection(mongoProjection(selectedFields(list.Select, order)))
+	}
+
+	filter := bson.M{"user_id": userId}
+	if len(conditions) > 0 {
+		filter["$and"] = conditions
+	}
+	cursor, err := m.Resources.Find(ctx, filter, opts)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	for cursor.Next(ctx) {
+		var doc mongoResource
+		if err := cursor.Decode(&doc); err != nil {
+			return nil, err
+		}
+		resources = append(resources, *doc.resource())
+	}
+	if err = cursor.Err(); err != nil {
+		return nil, err
+	}
+
+	if list.Before != nil {
+		reverseResources(resources)
+	}
+	return resources, nil
+}
+
+func (m *MongoModel) CountResources(userId string, filter system.Filter) (int, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), mongoTimeout)
+	defer cancel()
+
+	query := bson.M{"user_id": userId}
+	if filter != nil {
+		query["$and"] = bson.A{mongoFilter(filter, resourceFieldKeys)}
+	}
+	count, err := m.Resources.CountDocuments(ctx, query)
+	return int(count), err
+}
+
+// AggregateResources runs query as an aggregation pipeline. Group keys
+// and values are named g0, g1... and a0, a1... inside the pipeline since
+// aliases aren't valid as field names.
+func (m *MongoModel) AggregateResources(userId string, query AggregateQuery) ([]map[string]interface{}, error) {
+	var results []map[string]interface{}
+	ctx, cancel := context.WithTimeout(context.Background(), mongoTimeout)
+	defer cancel()
+
+	match := bson.M{"user_id": userId}
+	if query.Filter != nil {
+		match["$and"] = bson.A{mongoFilter(query.Filter, resourceFieldKeys)}
+	}
+
+	id := bson.M{}
+	sort := bson.D{}
+	for i, field := range query.GroupBy {
+		id[fmt.Sprintf("g%d", i)] = "$" + resourceFieldKeys[field]
+		sort = append(sort, bson.E{Key: fmt.Sprintf("_id.g%d", i), Value: 1})
+	}
+	group := bson.M{"_id": id}
+	for i, aggregate := range query.Aggregates {
+		if aggregate.Function == "count" {
+			group[fmt.Sprintf("a%d", i)] = bson.M{"$sum": 1}
+		} else {
+			group[fmt.Sprintf("a%d", i)] = bson.M{"$" + aggregate.Function: "$" + resourceFieldKeys[aggregate.Field]}
+		}
+	}
+
+	pipeline := mongo.Pipeline{{{Key: "$match", Value: match}}, {{Key: "$group", Value: group}}}
+	if len(sort) > 0 {
+		pipeline = append(pipeline, bson.D{{Key: "$sort", Value: sort}})
+	}
+	cursor, err := m.Resources.Aggregate(ctx, pipeline)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	for cursor.Next(ctx) {
+		var doc bson.M
+		if err := cursor.Decode(&doc); err != nil {
+			return nil, err
+		}
+		keys, _ := doc["_id"].(bson.M)
+		var values []interface{}
+		for i := range query.GroupBy {
+			values = append(values, mongoValue(keys[fmt.Sprintf("g%d", i)]))
+		}
+		for i := range query.Aggregates {
+			values = append(values, mongoValue(doc[fmt.Sprintf("a%d", i)]))
+		}
+		results = append(results, aggregateResult(query, values))
+	}
+	if err = cursor.Err(); err != nil {
+		return nil, err
+	}
+
+	// Like SQL, always answer one row when there is nothing to group by
+	if len(results) == 0 && len(query.GroupBy) == 0 {
+		results = aggregateResources(nil, query)
+	}
+	return results, nil
+}
+
+// mongoValue converts the values decoded from aggregations to the types
+// used by the other stores
+func mongoValue(value interface{}) interface{} {
+	switch value := value.(type) {
+	case primitive.DateTime:
+		return value.Time().UTC()
+	case int32:
+		return int(value)
+	case int64:
+		return int(value)
+	}
+	return value
+}
+
+func (m *MongoModel) ResourceExists(resourceId string) (bool, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), mongoTimeout)
+	defer cancel()
+
+	count, err := m.Resources.CountDocuments(ctx, bson.M{"_id": resourceId}, options.Count().SetLimit(1))
+	if err != nil {
+		return false, err
+	}
+	return count > 0, nil
+}
+
+func (m *MongoModel) DeleteResourceById(userId, resourceId string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), mongoTimeout)
+	defer cancel()
+
+	result, err := m.Resources.DeleteOne(ctx, bson.M{"_id": resourceId, "user_id": userId})
+	if err != nil {
+		return classifyError(err)
+	}
+	if result.DeletedCount == 0 {
+		return errNoRows
+	}
+	return nil
+}
+
+func (m *MongoModel) UpdateResource(resource *Resource, userId string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), mongoTimeout)
+	defer cancel()
+
+	now := mongoNow()
+	result, err := m.Resources.UpdateOne(ctx,
+		bson.M{"_id": resource.Id, "user_id": userId},
+		bson.M{"$set": bson.M{"href": resource.Href, "updated_at": now}, "$inc": bson.M{"version": 1}})
+	if err != nil {
+		return classifyError(err)
+	}
+	if result.MatchedCount == 0 {
+		return errNoRows
+	}
+
+	resource.UpdatedAt = now
+	return nil
+}
+
+// ModifyResource uses optimistic concurrency: the document is only replaced
+// if its version didn't change since it was read, retrying otherwise.
+// updated_at can't be compared, as writes in the same millisecond store the
+// same value.
+func (m *MongoModel) ModifyResource(userId, resourceId string, modify func(resource *Resource) error) (*Resource, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), mongoTimeout)
+	defer cancel()
+
+	for {
+		var doc mongoResource
+		err := m.Resources.FindOne(ctx, bson.M{"_id": resourceId, "user_id": userId}).Decode(&doc)
+		if err != nil {
+			if err == mongo.ErrNoDocuments {
+				return nil, errNoRows
+			}
+			return nil, classifyError(err)
+		}
+
+		resource := doc.resource()
+		if err = modify(resource); err != nil {
+			return nil, err
+		}
+
+		filter := bson.M{"_id": resourceId, "user_id": userId, "version": doc.Version}
+		if doc.Version == 0 {
+			// Documents stored before versions were kept have none
+			filter["version"] = bson.M{"$in": bson.A{0, nil}}
+		}
+		now := mongoNow()
+		result, err := m.Resources.UpdateOne(ctx, filter,
+			bson.M{"$set": bson.M{"href": resource.Href, "updated_at": now}, "$inc": bson.M{"version": 1}})
+		if err != nil {
+			return nil, classifyError(err)
+		}
+		if result.MatchedCount == 1 {
+			resource.Id = resourceId
+			resource.UpdatedAt = now
+			return resource, nil
+		}
+	}
+}
+
+// mongoNow returns the current time as MongoDB stores it, in milliseconds,
+// so what is answered after a write matches later reads
+func mongoNow() time.Time {
+	return time.Now().UTC().Truncate(time.Millisecond)
+}
+
+func (doc *mongoResource) resource() *Resource {
+	return &Resource{
+		Id:        doc.Id,
+		UserId:    doc.UserId,
+		Href:      doc.Href,
+		CreatedAt: doc.CreatedAt,
+		UpdatedAt: doc.UpdatedAt,
+	}
+}
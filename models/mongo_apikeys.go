@@ -0,0 +1,180 @@
+/*
+Copyright (c) 2015, Alberto Corsín Lafuente
+All rights reserved.
+
+Redistribution and use in source and binary forms, with or without
+modification, are permitted provided that the following conditions are met:
+
+1. Redistributions of source code must retain the above copyright notice,
+   this list of conditions and the following disclaimer.
+
+2. Redistributions in binary form must reproduce the above copyright notice,
+   this list of conditions and the following disclaimer in the documentation
+   and/or other materials provided with the distribution.
+
+3. Neither the name of the copyright holder nor the names of its contributors
+   may be used to endorse or promote products derived from this software
+   without specific prior written permission.
+
+THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND
+ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED
+WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE
+FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER
+CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY,
+OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+*/
+
+package models
+
+import (
+	"context"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"time"
+)
+
+const MongoAPIKeys = "api_keys"
+
+type mongoAPIKey struct {
+	Id        string     `bson:"_id"`
+	UserId    string     `bson:"user_id"`
+	Name      string     `bson:"name"`
+	Prefix    string     `bson:"prefix"`
+	Hash      string     `bson:"hash"`
+	Scopes    []string   `bson:"scopes"`
+	ExpiresAt *time.Time `bson:"expires_at"`
+	RevokedAt *time.Time `bson:"revoked_at"`
+	CreatedAt time.Time  `bson:"created_at"`
+	UpdatedAt time.Time  `bson:"updated_at"`
+}
+
+var _ APIKeyStore = (*MongoModel)(nil)
+
+// ensureAPIKeyIndexes creates the indexes used to find keys by hash and to
+// list them by owner
+func (m *MongoModel) ensureAPIKeyIndexes(ctx context.Context) error {
+	_, err := m.APIKeys.Indexes().CreateMany(ctx, []mongo.IndexModel{
+		{
+			Keys:    bson.D{{Key: "hash", Value: 1}},
+			Options: options.Index().SetName("hash").SetUnique(true),
+		},
+		{
+			Keys:    bson.D{{Key: "user_id", Value: 1}, {Key: "created_at", Value: 1}, {Key: "_id", Value: 1}},
+			Options: options.Index().SetName("user_created"),
+		},
+	})
+	return err
+}
+
+func (m *MongoModel) InsertAPIKey(key *APIKey) error {
+	ctx, cancel := context.WithTimeout(context.Background(), mongoTimeout)
+	defer cancel()
+
+	now := mongoNow()
+	key.CreatedAt = now
+	key.UpdatedAt = now
+	if _, err := m.APIKeys.InsertOne(ctx, newMongoAPIKey(key)); err != nil {
+		return classifyError(err)
+	}
+	return nil
+}
+
+func (m *MongoModel) GetAPIKeyById(userId, keyId string) (*APIKey, error) {
+	return m.findAPIKey(bson.M{"_id": keyId, "user_id": userId})
+}
+
+func (m *MongoModel) GetAPIKeyByHash(hash string) (*APIKey, error) {
+	return m.findAPIKey(bson.M{"hash": hash})
+}
+
+func (m *MongoModel) findAPIKey(filter bson.M) (*APIKey, error) {
+	var doc mongoAPIKey
+	ctx, cancel := context.WithTimeout(context.Background(), mongoTimeout)
+	defer cancel()
+
+	if err := m.APIKeys.FindOne(ctx, filter).Decode(&doc); err != nil {
+		return nil, classifyError(err)
+	}
+	return doc.apiKey(), nil
+}
+
+func (m *MongoModel) GetAPIKeys(userId string, offset, limit int) ([]APIKey, error) {
+	var keys []APIKey
+	ctx, cancel := context.WithTimeout(context.Background(), mongoTimeout)
+	defer cancel()
+
+	opts := options.Find().
+		SetSort(bson.D{{Key: "created_at", Value: 1}, {Key: "_id", Value: 1}}).
+		SetSkip(int64(offset)).
+		SetLimit(int64(limit))
+	cursor, err := m.APIKeys.Find(ctx, bson.M{"user_id": userId}, opts)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	for cursor.Next(ctx) {
+		var doc mongoAPIKey
+		if err := cursor.Decode(&doc); err != nil {
+			return nil, err
+		}
+		keys = append(keys, *doc.apiKey())
+	}
+
+	return keys, cursor.Err()
+}
+
+func (m *MongoModel) RotateAPIKey(userId, keyId, prefix, hash string, now time.Time) error {
+	return m.updateAPIKey(
+		bson.M{"_id": keyId, "user_id": userId, "revoked_at": nil, "$or": bson.A{
+			bson.M{"expires_at": nil},
+			bson.M{"expires_at": bson.M{"$gt": now.UTC()}},
+		}},
+		bson.M{"prefix": prefix, "hash": hash})
+}
+
+func (m *MongoModel) RevokeAPIKey(userId, keyId string, now time.Time) error {
+	return m.updateAPIKey(
+		bson.M{"_id": keyId, "user_id": userId, "revoked_at": nil},
+		bson.M{"revoked_at": now.UTC()})
+}
+
+func (m *MongoModel) updateAPIKey(filter, set bson.M) error {
+	ctx, cancel := context.WithTimeout(context.Background(), mongoTimeout)
+	defer cancel()
+
+	set["updated_at"] = mongoNow()
+	result, err := m.APIKeys.UpdateOne(ctx, filter, bson.M{"$set": set})
+	if err != nil {
+		return classifyError(err)
+	}
+	if result.MatchedCount == 0 {
+		return errNoRows
+	}
+	return nil
+}
+
+func newMongoAPIKey(key *APIKey) mongoAPIKey {
+	return mongoAPIKey{
+		Id:        key.Id,
+		UserId:    key.UserId,
+		Name:      key.Name,
+		Prefix:    key.Prefix,
+		Hash:      key.Hash,
+		Scopes:    key.Scopes,
+		ExpiresAt: key.ExpiresAt,
+		RevokedAt: key.RevokedAt,
+		CreatedAt: key.CreatedAt,
+		UpdatedAt: key.UpdatedAt,
+	}
+}
+
+func (doc *mongoAPIKey) apiKey() *APIKey {
+	key := APIKey(*doc)
+	return &key
+}
@@ -0,0 +1,225 @@
+/*
+Copyright (c) 2015, Alberto Corsín Lafuente
+All rights reserved.
+
+Redistribution and use in source and binary forms, with or without
+modification, are permitted provided that the following conditions are met:
+
+1. Redistributions of source code must retain the above copyright notice,
+   this list of conditions and the following disclaimer.
+
+2. Redistributions in binary form must reproduce the above copyright notice,
+   this list of conditions and the following disclaimer in the documentation
+   and/or other materials provided with the distribution.
+
+3. Neither the name of the copyright holder nor the names of its contributors
+   may be used to endorse or promote products derived from this software
+   without specific prior written permission.
+
+THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND
+ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED
+WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE
+FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER
+CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY,
+OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+*/
+
+package models
+
+import (
+	"context"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"reflect"
+)
+
+// MongoTable is a Store keeping items as documents of a MongoDB collection.
+// The id field is stored as _id and the owner in user_id.
+type MongoTable[T any] struct {
+	Collection *mongo.Collection
+	columns    []column
+	document   reflect.Type
+}
+
+func NewMongoTable[T any](collection *mongo.Collection) *MongoTable[T] {
+	t := &MongoTable[T]{Collection: collection, columns: columns[T]()}
+
+	// Documents are decoded into a struct with the fields of T tagged for
+	// bson, so the driver does the type conversions.
+	itemType := reflect.TypeOf((*T)(nil)).Elem()
+	fields := []reflect.StructField{{
+		Name: "MongoUserId",
+		Type: reflect.TypeOf(""),
+		Tag:  `bson:"user_id"`,
+	}}
+	for _, c := range t.columns {
+		if c.name == "user_id" {
+			continue
+		}
+		name := c.name
+		if name == "id" {
+			name = "_id"
+		}
+		field := itemType.Field(c.index)
+		fields = append(fields, reflect.StructField{
+			Name: field.Name,
+			Type: field.Type,
+			Tag:  reflect.StructTag(`bson:"` + name + `"`),
+		})
+	}
+	t.document = reflect.StructOf(fields)
+	return t
+}
+
+func (t *MongoTable[T]) Insert(userId string, item *T) error {
+	ctx, cancel := context.WithTimeout(context.Background(), mongoTimeout)
+	defer cancel()
+
+	_, err := t.Collection.InsertOne(ctx, t.toDocument(userId, item))
+	return classifyError(err)
+}
+
+func (t *MongoTable[T]) GetById(userId, id string) (*T, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), mongoTimeout)
+	defer cancel()
+
+	document := reflect.New(t.document)
+	err := t.Collection.FindOne(ctx, bson.M{"_id": id, "user_id": userId}).Decode(document.Interface())
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			return nil, errNoRows
+		}
+		return nil, err
+	}
+
+	return t.fromDocument(document.Elem()), nil
+}
+
+func (t *MongoTable[T]) List(userId string, offset, limit int) ([]T, error) {
+	var items []T
+	ctx, cancel := context.WithTimeout(context.Background(), mongoTimeout)
+	defer cancel()
+
+	opts := options.Find().
+		SetSort(bson.D{{Key: "_id", Value: 1}}).
+		SetSkip(int64(offset)).
+		SetLimit(int64(limit))
+	cursor, err := t.Collection.Find(ctx, bson.M{"user_id": userId}, opts)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	for cursor.Next(ctx) {
+		document := reflect.New(t.document)
+		if err := cursor.Decode(document.Interface()); err != nil {
+			return nil, err
+		}
+		items = append(items, *t.fromDocument(document.Elem()))
+	}
+
+	return items, cursor.Err()
+}
+
+func (t *MongoTable[T]) Update(userId string, item *T) error {
+	ctx, cancel := context.WithTimeout(context.Background(), mongoTimeout)
+	defer cancel()
+
+	result, err := t.Collection.ReplaceOne(ctx, bson.M{"_id": ItemId(item), "user_id": userId}, t.toDocument(userId, item))
+	if err != nil {
+		return err
+	}
+	if result.MatchedCount == 0 {
+		return errNoRows
+	}
+	return nil
+}
+
+// Modify uses optimistic concurrency: the document is only replaced if it
+// didn't change since it was read, retrying otherwise.
+func (t *MongoTable[T]) Modify(userId, id string, modify func(item *T) error) (*T, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), mongoTimeout)
+	defer cancel()
+
+	for {
+		document := reflect.New(t.document)
+		err := t.Collection.FindOne(ctx, bson.M{"_id": id, "user_id": userId}).Decode(document.Interface())
+		if err != nil {
+			if err == mongo.ErrNoDocuments {
+				return nil, errNoRows
+			}
+			return nil, err
+		}
+
+		item := t.fromDocument(document.Elem())
+		if err = modify(item); err != nil {
+			return nil, err
+		}
+		SetItemId(item, id)
+
+		// Every field of the document read must still be the same
+		filter := bson.M{"_id": id, "user_id": userId}
+		for i, c := range t.columnsWithoutOwner() {
+			if c.name != "id" {
+				filter[c.name] = document.Elem().Field(i + 1).Interface()
+			}
+		}
+		result, err := t.Collection.ReplaceOne(ctx, filter, t.toDocument(userId, item))
+		if err != nil {
+			return nil, classifyError(err)
+		}
+		if result.MatchedCount == 1 {
+			setOwner(t.columns, item, userId)
+			return item, nil
+		}
+	}
+}
+
+func (t *MongoTable[T]) Delete(userId, id string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), mongoTimeout)
+	defer cancel()
+
+	result, err := t.Collection.DeleteOne(ctx, bson.M{"_id": id, "user_id": userId})
+	if err != nil {
+		return err
+	}
+	if result.DeletedCount == 0 {
+		return errNoRows
+	}
+	return nil
+}
+
+func (t *MongoTable[T]) toDocument(userId string, item *T) interface{} {
+	document := reflect.New(t.document).Elem()
+	document.Field(0).SetString(userId)
+	v := reflect.ValueOf(item).Elem()
+	for i, c := range t.columnsWithoutOwner() {
+		document.Field(i + 1).Set(v.Field(c.index))
+	}
+	return document.Interface()
+}
+
+func (t *MongoTable[T]) fromDocument(document reflect.Value) *T {
+	item := new(T)
+	v := reflect.ValueOf(item).Elem()
+	for i, c := range t.columnsWithoutOwner() {
+		v.Field(c.index).Set(document.Field(i + 1))
+	}
+	setOwner(t.columns, item, document.Field(0).String())
+	return item
+}
+
+func (t *MongoTable[T]) columnsWithoutOwner() []column {
+	columns := make([]column, 0, len(t.columns))
+	for _, c := range t.columns {
+		if c.name != "user_id" {
+			columns = append(columns, c)
+		}
+	}
+	return columns
+}
@@ -0,0 +1,315 @@
+/*
+Copyright (c) 2015, Alberto Corsín Lafuente
+All rights reserved.
+
+Redistribution and use in source and binary forms, with or without
+modification, are permitted provided that the following conditions are met:
+
+1. Redistributions of source code must retain the above copyright notice,
+   this list of conditions and the following disclaimer.
+
+2. Redistributions in binary form must reproduce the above copyright notice,
+   this list of conditions and the following disclaimer in the documentation
+   and/or other materials provided with the distribution.
+
+3. Neither the name of the copyright holder nor the names of its contributors
+   may be used to endorse or promote products derived from this software
+   without specific prior written permission.
+
+THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND
+ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED
+WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE
+FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER
+CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY,
+OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+*/
+
+package models
+
+import (
+	"context"
+	"errors"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/integration/mtest"
+	"testing"
+	"time"
+)
+
+// The MongoModel tests run against the mock deployment of the driver, an
+// in-process fake server answering the responses queued by each test, and
+// check the commands MongoModel sends.
+
+func newMockMongo(t *testing.T, test func(mt *mtest.T, m *MongoModel)) {
+	mt := mtest.New(t, mtest.NewOptions().ClientType(mtest.Mock))
+	mt.Run(t.Name(), func(mt *mtest.T) {
+		test(mt, &MongoModel{
+			Client:    mt.Client,
+			Resources: mt.Coll,
+			APIKeys:   mt.DB.Collection(MongoAPIKeys),
+			Users:     mt.DB.Collection(MongoUsers),
+			Sessions:  mt.DB.Collection(MongoSessions),
+		})
+	})
+}
+
+func resourceDocument(id, userId, href string, createdAt time.Time) bson.D {
+	return bson.D{
+		{Key: "_id", Value: id},
+		{Key: "user_id", Value: userId},
+		{Key: "href", Value: href},
+		{Key: "created_at", Value: createdAt},
+		{Key: "updated_at", Value: createdAt},
+	}
+}
+
+func namespace(mt *mtest.T) string {
+	return mt.Coll.Database().Name() + "." + mt.Coll.Name()
+}
+
+func TestMongoEnsureIndexes(t *testing.T) {
+	newMockMongo(t, func(mt *mtest.T, m *MongoModel) {
+		mt.AddMockResponses(mtest.CreateSuccessResponse(), mtest.CreateSuccessResponse(),
+			mtest.CreateSuccessResponse(), mtest.CreateSuccessResponse())
+
+		if err := m.EnsureIndexes(context.Background()); err != nil {
+			t.Fatal(err)
+		}
+		command := mt.GetStartedEvent().Command
+		if command.Lookup("createIndexes").StringValue() != mt.Coll.Name() {
+			t.Fatalf("expected the resource indexes first, got %v", command)
+		}
+		index := command.Lookup("indexes").Array().Index(0).Value().Document()
+		if index.Lookup("name").StringValue() != "user_created" {
+			t.Fatalf("unexpected index %v", index)
+		}
+	})
+}
+
+func TestMongoInsertResource(t *testing.T) {
+	newMockMongo(t, func(mt *mtest.T, m *MongoModel) {
+		mt.AddMockResponses(mtest.CreateSuccessResponse())
+
+		resource := &Resource{Id: "r1", UserId: "alice", Href: "/resources/r1"}
+		if err := m.InsertResource(resource); err != nil {
+			t.Fatal(err)
+		}
+		if !resource.CreatedAt.Equal(resource.CreatedAt.Truncate(time.Millisecond)) {
+			t.Fatalf("expected a time in milliseconds, got %v", resource.CreatedAt)
+		}
+
+		document := mt.GetStartedEvent().Command.Lookup("documents").Array().Index(0).Value().Document()
+		if document.Lookup("_id").StringValue() != "r1" || document.Lookup("user_id").StringValue() != "alice" {
+			t.Fatalf("unexpected document %v", document)
+		}
+		if stored := document.Lookup("created_at").Time(); !stored.Equal(resource.CreatedAt) {
+			t.Fatalf("expected %v to be stored, got %v", resource.CreatedAt, stored)
+		}
+	})
+}
+
+func TestMongoInsertDuplicateResource(t *testing.T) {
+	newMockMongo(t, func(mt *mtest.T, m *MongoModel) {
+		mt.AddMockResponses(mtest.CreateWriteErrorsResponse(mtest.WriteError{Index: 0, Code: 11000, Message: "duplicate key"}))
+
+		err := m.InsertResource(&Resource{Id: "r1", UserId: "alice"})
+		if !errors.Is(err, ErrDuplicate) {
+			t.Fatalf("expected ErrDuplicate, got %v", err)
+		}
+	})
+}
+
+func TestMongoGetResourceById(t *testing.T) {
+	newMockMongo(t, func(mt *mtest.T, m *MongoModel) {
+		createdAt := time.Date(2024, 1, 2, 3, 4, 5, 6000000, time.UTC)
+		mt.AddMockResponses(mtest.CreateCursorResponse(0, namespace(mt), mtest.FirstBatch,
+			resourceDocument("r1", "alice", "/things/r1", createdAt)))
+
+		resource, err := m.GetResourceById("alice", "r1")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if resource.Href != "/things/r1" || !resource.CreatedAt.Equal(createdAt) {
+			t.Fatalf("unexpected resource %+v", resource)
+		}
+
+		filter := mt.GetStartedEvent().Command.Lookup("filter").Document()
+		if filter.Lookup("_id").StringValue() != "r1" || filter.Lookup("user_id").StringValue() != "alice" {
+			t.Fatalf("expected the owner in the filter, got %v", filter)
+		}
+	})
+}
+
+func TestMongoGetMissingResource(t *testing.T) {
+	newMockMongo(t, func(mt *mtest.T, m *MongoModel) {
+		mt.AddMockResponses(mtest.CreateCursorResponse(0, namespace(mt), mtest.FirstBatch))
+
+		if _, err := m.GetResourceById("alice", "r1"); !errors.Is(err, ErrNotFound) {
+			t.Fatalf("expected ErrNotFound, got %v", err)
+		}
+	})
+}
+
+func TestMongoGetResources(t *testing.T) {
+	newMockMongo(t, func(mt *mtest.T, m *MongoModel) {
+		now := time.Now().UTC().Truncate(time.Millisecond)
+		mt.AddMockResponses(mtest.CreateCursorResponse(0, namespace(mt), mtest.FirstBatch,
+			resourceDocument("r1", "alice", "/resources/r1", now),
+			resourceDocument("r2", "alice", "/resources/r2", now)))
+
+		resources, err := m.GetResources("alice", ListQuery{Offset: 20, Limit: 10})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(resources) != 2 || resources[1].Id != "r2" {
+			t.Fatalf("unexpected resources %+v", resources)
+		}
+
+		command := mt.GetStartedEvent().Command
+		if command.Lookup("skip").AsInt64() != 20 || command.Lookup("limit").AsInt64() != 10 {
+			t.Fatalf("expected skip 20 and limit 10, got %v", command)
+		}
+		if command.Lookup("filter", "user_id").StringValue() != "alice" {
+			t.Fatalf("expected the owner in the filter, got %v", command)
+		}
+	})
+}
+
+func TestMongoUpdateMissingResource(t *testing.T) {
+	newMockMongo(t, func(mt *mtest.T, m *MongoModel) {
+		mt.AddMockResponses(mtest.CreateSuccessResponse(bson.E{Key: "n", Value: 0}, bson.E{Key: "nModified", Value: 0}))
+
+		err := m.UpdateResource(&Resource{Id: "r1", Href: "/resources/r1"}, "alice")
+		if !errors.Is(err, ErrNotFound) {
+			t.Fatalf("expected ErrNotFound, got %v", err)
+		}
+	})
+}
+
+func TestMongoDeleteResourceById(t *testing.T) {
+	newMockMongo(t, func(mt *mtest.T, m *MongoModel) {
+		mt.AddMockResponses(
+			mtest.CreateSuccessResponse(bson.E{Key: "n", Value: 1}),
+			mtest.CreateSuccessResponse(bson.E{Key: "n", Value: 0}))
+
+		if err := m.DeleteResourceById("alice", "r1"); err != nil {
+			t.Fatal(err)
+		}
+		if err := m.DeleteResourceById("alice", "r1"); !errors.Is(err, ErrNotFound) {
+			t.Fatalf("expected ErrNotFound, got %v", err)
+		}
+	})
+}
+
+func TestMongoWriteErrorsAreClassified(t *testing.T) {
+	newMockMongo(t, func(mt *mtest.T, m *MongoModel) {
+		duplicate := mtest.WriteError{Index: 0, Code: 11000, Message: "duplicate key"}
+		mt.AddMockResponses(mtest.CreateWriteErrorsResponse(duplicate), mtest.CreateWriteErrorsResponse(duplicate))
+
+		if err := m.UpdateResource(&Resource{Id: "r1"}, "alice"); !errors.Is(err, ErrDuplicate) {
+			t.Fatalf("expected ErrDuplicate on update, got %v", err)
+		}
+		if err := m.DeleteResourceById("alice", "r1"); !errors.Is(err, ErrDuplicate) {
+			t.Fatalf("expected ErrDuplicate on delete, got %v", err)
+		}
+	})
+}
+
+func TestMongoUpdateIncreasesVersion(t *testing.T) {
+	newMockMongo(t, func(mt *mtest.T, m *MongoModel) {
+		mt.AddMockResponses(mtest.CreateSuccessResponse(bson.E{Key: "n", Value: 1}, bson.E{Key: "nModified", Value: 1}))
+
+		if err := m.UpdateResource(&Resource{Id: "r1", Href: "/resources/r1"}, "alice"); err != nil {
+			t.Fatal(err)
+		}
+		update := mt.GetStartedEvent().Command.Lookup("updates").Array().Index(0).Value().Document()
+		if update.Lookup("u", "$inc", "version").AsInt64() != 1 {
+			t.Fatalf("expected the version to be increased, got %v", update)
+		}
+	})
+}
+
+func TestMongoModifyResourceComparesVersion(t *testing.T) {
+	newMockMongo(t, func(mt *mtest.T, m *MongoModel) {
+		now := time.Now().UTC().Truncate(time.Millisecond)
+		read := func(version int64) bson.D {
+			return append(resourceDocument("r1", "alice", "/resources/r1", now), bson.E{Key: "version", Value: version})
+		}
+		// The first update finds the document changed by another writer in
+		// the same millisecond, so it is read and modified again
+		mt.AddMockResponses(
+			mtest.CreateCursorResponse(0, namespace(mt), mtest.FirstBatch, read(3)),
+			mtest.CreateSuccessResponse(bson.E{Key: "n", Value: 0}, bson.E{Key: "nModified", Value: 0}),
+			mtest.CreateCursorResponse(0, namespace(mt), mtest.FirstBatch, read(4)),
+			mtest.CreateSuccessResponse(bson.E{Key: "n", Value: 1}, bson.E{Key: "nModified", Value: 1}))
+
+		calls := 0
+		resource, err := m.ModifyResource("alice", "r1", func(resource *Resource) error {
+			calls++
+			resource.Href = "/things/r1"
+			return nil
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if calls != 2 || resource.Href != "/things/r1" {
+			t.Fatalf("expected two attempts, got %d and %+v", calls, resource)
+		}
+
+		for _, version := range []int64{3, 4} {
+			mt.GetStartedEvent()
+			update := mt.GetStartedEvent().Command.Lookup("updates").Array().Index(0).Value().Document()
+			if update.Lookup("q", "version").AsInt64() != version {
+				t.Fatalf("expected version %d in the filter, got %v", version, update)
+			}
+			if _, err := update.LookupErr("q", "updated_at"); err == nil {
+				t.Fatalf("expected updated_at not to be compared, got %v", update)
+			}
+			if update.Lookup("u", "$inc", "version").AsInt64() != 1 {
+				t.Fatalf("expected the version to be increased, got %v", update)
+			}
+		}
+	})
+}
+
+func TestMongoModifyUnversionedResource(t *testing.T) {
+	newMockMongo(t, func(mt *mtest.T, m *MongoModel) {
+		mt.AddMockResponses(
+			mtest.CreateCursorResponse(0, namespace(mt), mtest.FirstBatch, resourceDocument("r1", "alice", "/resources/r1", time.Now())),
+			mtest.CreateSuccessResponse(bson.E{Key: "n", Value: 1}, bson.E{Key: "nModified", Value: 1}))
+
+		if _, err := m.ModifyResource("alice", "r1", func(*Resource) error { return nil }); err != nil {
+			t.Fatal(err)
+		}
+		mt.GetStartedEvent()
+		update := mt.GetStartedEvent().Command.Lookup("updates").Array().Index(0).Value().Document()
+		if _, err := update.LookupErr("q", "version", "$in"); err != nil {
+			t.Fatalf("expected documents without version to match, got %v", update)
+		}
+	})
+}
+
+func TestMongoResourceExists(t *testing.T) {
+	newMockMongo(t, func(mt *mtest.T, m *MongoModel) {
+		mt.AddMockResponses(
+			mtest.CreateCursorResponse(0, namespace(mt), mtest.FirstBatch, bson.D{{Key: "n", Value: 1}}),
+			mtest.CreateCursorResponse(0, namespace(mt), mtest.FirstBatch))
+
+		if exists, err := m.ResourceExists("r1"); err != nil || !exists {
+			t.Fatalf("expected r1 to exist, got %v, %v", exists, err)
+		}
+		if exists, err := m.ResourceExists("r2"); err != nil || exists {
+			t.Fatalf("expected r2 not to exist, got %v, %v", exists, err)
+		}
+	})
+}
+
+func TestMongoErrorsAreClassified(t *testing.T) {
+	if !errors.Is(classifyError(mongo.ErrNoDocuments), ErrNotFound) {
+		t.Fatal("expected ErrNoDocuments to be ErrNotFound")
+	}
+}
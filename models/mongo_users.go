@@ -0,0 +1,242 @@
+/*
+Copyright (c) 2015, Alberto Corsín Lafuente
+All rights reserved.
+
+Redistribution and use in source and binary forms, with or without
+modification, are permitted provided that the following conditions are met:
+
+1. Redistributions of source code must retain the above copyright notice,
+   this list of conditions and the following disclaimer.
+
+2. Redistributions in binary form must reproduce the above copyright notice,
+   this list of conditions and the following disclaimer in the documentation
+   and/or other materials provided with the distribution.
+
+3. Neither the name of the copyright holder nor the names of its contributors
+   may be used to endorse or promote products derived from this software
+   without specific prior written permission.
+
+THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND
+ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED
+WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE
+FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER
+CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY,
+OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+*/
+
+package models
+
+import (
+	"context"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"time"
+)
+
+const (
+	MongoUsers    = "users"
+	MongoSessions = "sessions"
+)
+
+type mongoUser struct {
+	Id           string     `bson:"_id"`
+	Username     string     `bson:"username"`
+	PasswordHash string     `bson:"password_hash"`
+	FailedLogins int        `bson:"failed_logins"`
+	LockedUntil  *time.Time `bson:"locked_until"`
+	CreatedAt    time.Time  `bson:"created_at"`
+	UpdatedAt    time.Time  `bson:"updated_at"`
+}
+
+type mongoSession struct {
+	Id              string    `bson:"_id"`
+	UserId          string    `bson:"user_id"`
+	AccessHash      string    `bson:"access_hash"`
+	RefreshHash     string    `bson:"refresh_hash"`
+	AccessExpiresAt time.Time `bson:"access_expires_at"`
+	ExpiresAt       time.Time `bson:"expires_at"`
+	CreatedAt       time.Time `bson:"created_at"`
+	UpdatedAt       time.Time `bson:"updated_at"`
+}
+
+var _ UserStore = (*MongoModel)(nil)
+
+// ensureUserIndexes creates the indexes used to find users by name and
+// sessions by token. Expired sessions are removed by MongoDB.
+func (m *MongoModel) ensureUserIndexes(ctx context.Context) error {
+	_, err := m.Users.Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys:    bson.D{{Key: "username", Value: 1}},
+		Options: options.Index().SetName("username").SetUnique(true),
+	})
+	if err != nil {
+		return err
+	}
+
+	_, err = m.Sessions.Indexes().CreateMany(ctx, []mongo.IndexModel{
+		{
+			Keys:    bson.D{{Key: "access_hash", Value: 1}},
+			Options: options.Index().SetName("access_hash").SetUnique(true),
+		},
+		{
+			Keys:    bson.D{{Key: "refresh_hash", Value: 1}},
+			Options: options.Index().SetName("refresh_hash").SetUnique(true),
+		},
+		{
+			Keys:    bson.D{{Key: "expires_at", Value: 1}},
+			Options: options.Index().SetName("expires_at").SetExpireAfterSeconds(0),
+		},
+	})
+	return err
+}
+
+func (m *MongoModel) InsertUser(user *User) error {
+	ctx, cancel := context.WithTimeout(context.Background(), mongoTimeout)
+	defer cancel()
+
+	now := mongoNow()
+	user.CreatedAt = now
+	user.UpdatedAt = now
+	if _, err := m.Users.InsertOne(ctx, mongoUser(*user)); err != nil {
+		return classifyError(err)
+	}
+	return nil
+}
+
+func (m *MongoModel) GetUserById(userId string) (*User, error) {
+	return m.getUser(bson.M{"_id": userId})
+}
+
+func (m *MongoModel) GetUserByName(username string) (*User, error) {
+	return m.getUser(bson.M{"username": username})
+}
+
+func (m *MongoModel) getUser(filter bson.M) (*User, error) {
+	var doc mongoUser
+	ctx, cancel := context.WithTimeout(context.Background(), mongoTimeout)
+	defer cancel()
+
+	if err := m.Users.FindOne(ctx, filter).Decode(&doc); err != nil {
+		return nil, classifyError(err)
+	}
+	user := User(doc)
+	return &user, nil
+}
+
+func (m *MongoModel) RecordLoginAttempt(userId string, maxAttempts int, now, lockedUntil time.Time) (bool, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), mongoTimeout)
+	defer cancel()
+
+	// The expressions of a $set stage see the document before the update
+	locks := bson.M{"$gte": bson.A{bson.M{"$add": bson.A{"$failed_logins", 1}}, maxAttempts}}
+	result, err := m.Users.UpdateOne(ctx,
+		bson.M{"_id": userId, "$or": bson.A{
+			bson.M{"locked_until": nil},
+			bson.M{"locked_until": bson.M{"$lte": now.UTC()}},
+		}},
+		mongo.Pipeline{{{Key: "$set", Value: bson.M{
+			"locked_until":  bson.M{"$cond": bson.A{locks, lockedUntil.UTC(), "$locked_until"}},
+			"failed_logins": bson.M{"$cond": bson.A{locks, 0, bson.M{"$add": bson.A{"$failed_logins", 1}}}},
+			"updated_at":    mongoNow(),
+		}}}})
+	if err != nil {
+		return false, classifyError(err)
+	}
+	return result.MatchedCount > 0, nil
+}
+
+func (m *MongoModel) ResetLoginAttempts(userId string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), mongoTimeout)
+	defer cancel()
+
+	result, err := m.Users.UpdateOne(ctx,
+		bson.M{"_id": userId},
+		bson.M{"$set": bson.M{
+			"failed_logins": 0,
+			"locked_until":  nil,
+			"updated_at":    mongoNow(),
+		}})
+	if err != nil {
+		return classifyError(err)
+	}
+	if result.MatchedCount == 0 {
+		return errNoRows
+	}
+	return nil
+}
+
+func (m *MongoModel) InsertSession(session *Session) error {
+	ctx, cancel := context.WithTimeout(context.Background(), mongoTimeout)
+	defer cancel()
+
+	now := mongoNow()
+	session.CreatedAt = now
+	session.UpdatedAt = now
+	if _, err := m.Sessions.InsertOne(ctx, mongoSession(*session)); err != nil {
+		return classifyError(err)
+	}
+	return nil
+}
+
+func (m *MongoModel) GetSessionByAccessHash(hash string) (*Session, error) {
+	return m.findSession(bson.M{"access_hash": hash})
+}
+
+func (m *MongoModel) GetSessionByRefreshHash(hash string) (*Session, error) {
+	return m.findSession(bson.M{"refresh_hash": hash})
+}
+
+func (m *MongoModel) findSession(filter bson.M) (*Session, error) {
+	var doc mongoSession
+	ctx, cancel := context.WithTimeout(context.Background(), mongoTimeout)
+	defer cancel()
+
+	if err := m.Sessions.FindOne(ctx, filter).Decode(&doc); err != nil {
+		return nil, classifyError(err)
+	}
+	session := Session(doc)
+	return &session, nil
+}
+
+func (m *MongoModel) RotateSession(session *Session, refreshHash string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), mongoTimeout)
+	defer cancel()
+
+	now := mongoNow()
+	result, err := m.Sessions.UpdateOne(ctx,
+		bson.M{"_id": session.Id, "refresh_hash": refreshHash},
+		bson.M{"$set": bson.M{
+			"access_hash":       session.AccessHash,
+			"refresh_hash":      session.RefreshHash,
+			"access_expires_at": session.AccessExpiresAt,
+			"expires_at":        session.ExpiresAt,
+			"updated_at":        now,
+		}})
+	if err != nil {
+		return classifyError(err)
+	}
+	if result.MatchedCount == 0 {
+		return errNoRows
+	}
+
+	session.UpdatedAt = now
+	return nil
+}
+
+func (m *MongoModel) DeleteSession(sessionId string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), mongoTimeout)
+	defer cancel()
+
+	result, err := m.Sessions.DeleteOne(ctx, bson.M{"_id": sessionId})
+	if err != nil {
+		return err
+	}
+	if result.DeletedCount == 0 {
+		return errNoRows
+	}
+	return nil
+}
@@ -0,0 +1,223 @@
+/*
+Copyright (c) 2015, Alberto Corsín Lafuente
+All rights reserved.
+
+Redistribution and use in source and binary forms, with or without
+modification, are permitted provided that the following conditions are met:
+
+1. Redistributions of source code must retain the above copyright notice,
+   this list of conditions and the following disclaimer.
+
+2. Redistributions in binary form must reproduce the above copyright notice,
+   this list of conditions and the following disclaimer in the documentation
+   and/or other materials provided with the distribution.
+
+3. Neither the name of the copyright holder nor the names of its contributors
+   may be used to endorse or promote products derived from this software
+   without specific prior written permission.
+
+THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND
+ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED
+WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE
+FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER
+CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY,
+OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+*/
+
+package models
+
+import (
+	"github.com/acorsinl/casimiro/system"
+	"go.mongodb.org/mongo-driver/bson"
+	"strings"
+	"time"
+)
+
+// ResourceSortable are the Resource fields that can be used in $orderby
+var ResourceSortable = map[string]bool{
+	"id":        true,
+	"createdAt": true,
+	"updatedAt": true,
+}
+
+// ListQuery selects a page of a listing. Rows are sorted by Order, creation
+// time and id if empty; After and Before, when set, select the rows
+// following or preceding a cursor and Offset is then ignored. Filter, when
+// set, only keeps the rows matching it. Select, when set, lists the only
+// fields the caller needs, the others may be left empty.
+type ListQuery struct {
+	Offset int
+	Limit  int
+	After  *Cursor
+	Before *Cursor
+	Filter system.Filter
+	Order  []system.OrderBy
+	Select []string
+}
+
+// ResourceOrder completes order so it sorts rows deterministically: id is
+// added as the last key if missing and keys after it are dropped, since
+// they would never be used.
+func ResourceOrder(order []system.OrderBy) []system.OrderBy {
+	if len(order) == 0 {
+		return []system.OrderBy{{Field: "createdAt"}, {Field: "id"}}
+	}
+	for i, key := range order {
+		if key.Field == "id" {
+			return order[:i+1]
+		}
+	}
+	return append(order[:len(order):len(order)], system.OrderBy{Field: "id"})
+}
+
+// Cursor is the position of a row in a listing sorted by Order, Values
+// holds the row values of each sort key. A cursor with End set is past the
+// last row, so Before it selects the last page.
+type Cursor struct {
+	Order  string        `json:"o,omitempty"`
+	Values []interface{} `json:"v,omitempty"`
+	End    bool          `json:"e,omitempty"`
+}
+
+// CursorOf returns the position of resource in a listing sorted by order
+func CursorOf(resource *Resource, order []system.OrderBy) *Cursor {
+	field := resourceField(resource)
+	cursor := &Cursor{Order: system.OrderByString(order)}
+	for _, key := range order {
+		cursor.Values = append(cursor.Values, field(key.Field))
+	}
+	return cursor
+}
+
+// Bind checks that c was created for a listing sorted by order and restores
+// the types of its values, which are lost when encoding it
+func (c *Cursor) Bind(order []system.OrderBy) error {
+	if c.End {
+		return nil
+	}
+	if c.Order != system.OrderByString(order) || len(c.Values) != len(order) {
+		return system.ErrInvalidCursor
+	}
+
+	for i, key := range order {
+		valid := false
+		switch ResourceFilters[key.Field] {
+		case system.FilterString:
+			_, valid = c.Values[i].(string)
+		case system.FilterNumber:
+			_, valid = c.Values[i].(float64)
+		case system.FilterBool:
+			_, valid = c.Values[i].(bool)
+		case system.FilterTime:
+			if value, ok := c.Values[i].(string); ok {
+				t, err := time.Parse(time.RFC3339Nano, value)
+				c.Values[i], valid = t, err == nil
+			}
+		}
+		if !valid {
+			return system.ErrInvalidCursor
+		}
+	}
+	return nil
+}
+
+// Compare orders the rows at c and other in a listing sorted by order
+func (c *Cursor) Compare(other *Cursor, order []system.OrderBy) int {
+	if c.End || other.End {
+		switch {
+		case c.End == other.End:
+			return 0
+		case c.End:
+			return 1
+		}
+		return -1
+	}
+
+	for i, key := range order {
+		if n := compareValues(c.Values[i], other.Values[i]); n != 0 {
+			if key.Desc {
+				return -n
+			}
+			return n
+		}
+	}
+	return 0
+}
+
+// sqlOrderBy returns the ORDER BY clause for order, with every direction
+// flipped when reverse is set
+func sqlOrderBy(order []system.OrderBy, reverse bool, columns map[string]string) string {
+	keys := make([]string, len(order))
+	for i, key := range order {
+		keys[i] = columns[key.Field]
+		if key.Desc != reverse {
+			keys[i] += " DESC"
+		}
+	}
+	return " ORDER BY " + strings.Join(keys, ", ")
+}
+
+// sqlKeyset returns the condition selecting the rows after cursor in a
+// listing sorted by order, or the ones before it if before is set
+func sqlKeyset(cursor *Cursor, order []system.OrderBy, before bool, columns map[string]string, dialect Dialect) (string, []interface{}) {
+	var conditions []string
+	var args []interface{}
+
+	for i, key := range order {
+		var terms []string
+		for j := 0; j < i; j++ {
+			terms = append(terms, columns[order[j].Field]+" = ?")
+			args = append(args, sqlValue(cursor.Values[j], dialect))
+		}
+		operator := " > ?"
+		if key.Desc != before {
+			operator = " < ?"
+		}
+		terms = append(terms, columns[key.Field]+operator)
+		args = append(args, sqlValue(cursor.Values[i], dialect))
+		conditions = append(conditions, "("+strings.Join(terms, " AND ")+")")
+	}
+	return "(" + strings.Join(conditions, " OR ") + ")", args
+}
+
+// mongoSort returns the sort document for order, with every direction
+// flipped when reverse is set
+func mongoSort(order []system.OrderBy, reverse bool, keys map[string]string) bson.D {
+	sort := bson.D{}
+	for _, key := range order {
+		direction := 1
+		if key.Desc != reverse {
+			direction = -1
+		}
+		sort = append(sort, bson.E{Key: keys[key.Field], Value: direction})
+	}
+	return sort
+}
+
+// mongoKeyset is sqlKeyset for MongoDB queries
+func mongoKeyset(cursor *Cursor, order []system.OrderBy, before bool, keys map[string]string) bson.M {
+	conditions := bson.A{}
+	for i, key := range order {
+		condition := bson.M{}
+		for j := 0; j < i; j++ {
+			condition[keys[order[j].Field]] = cursor.Values[j]
+		}
+		operator := "$gt"
+		if key.Desc != before {
+			operator = "$lt"
+		}
+		condition[keys[key.Field]] = bson.M{operator: cursor.Values[i]}
+		conditions = append(conditions, condition)
+	}
+	return bson.M{"$or": conditions}
+}
+
+func reverseResources(resources []Resource) {
+	for i, j := 0, len(resources)-1; i < j; i, j = i+1, j-1 {
+		resources[i], resources[j] = resources[j], resources[i]
+	}
+}
@@ -0,0 +1,174 @@
+/*
+Copyright (c) 2015, Alberto Corsín Lafuente
+All rights reserved.
+
+Redistribution and use in source and binary forms, with or without
+modification, are permitted provided that the following conditions are met:
+
+1. Redistributions of source code must retain the above copyright notice,
+   this list of conditions and the following disclaimer.
+
+2. Redistributions in binary form must reproduce the above copyright notice,
+   this list of conditions and the following disclaimer in the documentation
+   and/or other materials provided with the distribution.
+
+3. Neither the name of the copyright holder nor the names of its contributors
+   may be used to endorse or promote products derived from this software
+   without specific prior written permission.
+
+THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND
+ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED
+WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE
+FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER
+CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY,
+OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+*/
+
+package models
+
+import (
+	"github.com/acorsinl/casimiro/system"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// Every resource is created at the same time, only id tells them apart
+var keysetIds = []string{"r3", "r1", "r5", "r2", "r4"}
+
+func keysetStores(t *testing.T) map[string]ResourceStore {
+	createdAt := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+
+	sqlStore := &Model{}
+	sqlStore.InitDB("sqlite::memory:")
+	t.Cleanup(func() { sqlStore.DBSession.Close() })
+	_, err := sqlStore.DBSession.Exec(`CREATE TABLE resources (
+		id VARCHAR(36) NOT NULL PRIMARY KEY,
+		user_id VARCHAR(255) NOT NULL,
+		href VARCHAR(255) NOT NULL DEFAULT '',
+		created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+		updated_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP)`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	memoryStore := NewMemoryModel()
+	for _, id := range keysetIds {
+		_, err = sqlStore.DBSession.Exec("INSERT INTO resources (id, user_id, created_at, updated_at) VALUES (?, 'alice', ?, ?)",
+			id, SQLite.TimeValue(createdAt), SQLite.TimeValue(createdAt))
+		if err != nil {
+			t.Fatal(err)
+		}
+		memoryStore.resources[id] = Resource{Id: id, UserId: "alice", CreatedAt: createdAt, UpdatedAt: createdAt}
+	}
+	return map[string]ResourceStore{"sql": sqlStore, "memory": memoryStore}
+}
+
+// pageIds walks a listing two rows at a time, forwards from the first page
+// or backwards from the last one
+func pageIds(t *testing.T, store ResourceStore, order []system.OrderBy, backwards bool) []string {
+	var ids []string
+	list := ListQuery{Limit: 2, Order: order}
+	if backwards {
+		list.Before = &Cursor{End: true}
+	}
+
+	for i := 0; i < len(keysetIds); i++ {
+		resources, err := store.GetResources("alice", list)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(resources) == 0 {
+			return ids
+		}
+
+		var page []string
+		for _, resource := range resources {
+			page = append(page, resource.Id)
+		}
+		if backwards {
+			ids = append(page, ids...)
+			list.Before = CursorOf(&resources[0], ResourceOrder(order))
+		} else {
+			ids = append(ids, page...)
+			list.After = CursorOf(&resources[len(resources)-1], ResourceOrder(order))
+		}
+	}
+	t.Fatalf("expected the listing to end, got %v", ids)
+	return nil
+}
+
+func TestKeysetBreaksTiesOnId(t *testing.T) {
+	orders := map[string][]system.OrderBy{
+		"default":        nil,
+		"createdAt desc": {{Field: "createdAt", Desc: true}},
+		"updatedAt":      {{Field: "updatedAt"}, {Field: "createdAt"}},
+	}
+	expected := []string{"r1", "r2", "r3", "r4", "r5"}
+
+	for name, store := range keysetStores(t) {
+		for orderName, order := range orders {
+			if ids := pageIds(t, store, order, false); !reflect.DeepEqual(ids, expected) {
+				t.Errorf("%s, %s: expected %v forwards, got %v", name, orderName, expected, ids)
+			}
+			if ids := pageIds(t, store, order, true); !reflect.DeepEqual(ids, expected) {
+				t.Errorf("%s, %s: expected %v backwards, got %v", name, orderName, expected, ids)
+			}
+		}
+
+		ids := pageIds(t, store, []system.OrderBy{{Field: "id", Desc: true}}, false)
+		if !reflect.DeepEqual(ids, []string{"r5", "r4", "r3", "r2", "r1"}) {
+			t.Errorf("%s: expected ids in descending order, got %v", name, ids)
+		}
+	}
+}
+
+func TestResourceOrder(t *testing.T) {
+	tests := []struct {
+		order    []system.OrderBy
+		expected string
+	}{
+		{nil, "createdAt,id"},
+		{[]system.OrderBy{{Field: "updatedAt", Desc: true}}, "updatedAt desc,id"},
+		{[]system.OrderBy{{Field: "id", Desc: true}, {Field: "createdAt"}}, "id desc"},
+	}
+	for _, test := range tests {
+		if order := system.OrderByString(ResourceOrder(test.order)); order != test.expected {
+			t.Errorf("expected %s, got %s", test.expected, order)
+		}
+	}
+}
+
+func TestCursorBind(t *testing.T) {
+	order := ResourceOrder([]system.OrderBy{{Field: "updatedAt", Desc: true}})
+	createdAt := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+	cursor := CursorOf(&Resource{Id: "r1", UpdatedAt: createdAt}, order)
+
+	// Cursors lose the value types once encoded
+	token, err := system.EncodeCursor(cursor)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var decoded Cursor
+	if err = system.DecodeCursor(token, &decoded); err != nil {
+		t.Fatal(err)
+	}
+	if err = decoded.Bind(order); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(decoded, *cursor) {
+		t.Fatalf("expected %+v, got %+v", *cursor, decoded)
+	}
+
+	if err = decoded.Bind(ResourceOrder(nil)); err != system.ErrInvalidCursor {
+		t.Errorf("expected a cursor of another order to be rejected, got %v", err)
+	}
+	wrongType := Cursor{Order: decoded.Order, Values: []interface{}{"yesterday", "r1"}}
+	if err = wrongType.Bind(order); err != system.ErrInvalidCursor {
+		t.Errorf("expected a cursor with invalid values to be rejected, got %v", err)
+	}
+}
@@ -34,45 +34,75 @@ import (
 	"database/sql"
 	"github.com/acorsinl/casimiro/system"
 	"log"
+	"strings"
+	"time"
 )
 
 type Model struct {
 	DBSession *sql.DB
+	Dialect   Dialect
 }
 
 func (m *Model) InitDB(dbUri string) {
-	db, err := sql.Open("mysql", dbUri)
+	dialect, dsn, err := ParseDBUri(dbUri)
+	if err != nil {
+		log.Fatal("Can't parse database uri")
+	}
+
+	db, err := sql.Open(dialect.DriverName(), dsn)
 	if err != nil {
 		log.Fatal("Can't open database")
 	}
 
+	// Every connection to :memory: gets its own empty database
+	if dialect == SQLite && strings.HasPrefix(dsn, ":memory:") {
+		db.SetMaxOpenConns(1)
+	}
+
 	if err = db.Ping(); err != nil {
 		log.Fatal("Can't connect to database")
 	}
 
 	log.Println("Database connection stablished")
 	m.DBSession = db
+	m.Dialect = dialect
+}
+
+// prepare prepares stmt, written with "?" placeholders, for the model dialect
+func (m *Model) prepare(stmt string) (*sql.Stmt, error) {
+	return m.DBSession.Prepare(Rebind(m.Dialect, stmt))
 }
 
 type Resource struct {
-	Id   string `json:"id"`
-	Href string `json:"href"`
+	Id        string    `json:"id"`
+	UserId    string    `json:"-"`
+	Href      string    `json:"href" validate:"max=255"`
+	CreatedAt time.Time `json:"createdAt"`
+	UpdatedAt time.Time `json:"updatedAt"`
 }
 
+const resourceColumns = "id, user_id, href, created_at, updated_at"
+
 func (m *Model) InsertResource(resource *Resource) error {
-	stmt := ""
-	query, err := m.DBSession.Prepare(stmt)
+	stmt := "INSERT INTO resources (id, user_id, href) VALUES (?, ?, ?)"
+	returning := m.Dialect.Returning("created_at", "updated_at")
+	query, err := m.prepare(stmt + returning)
 	if err != nil {
 		return err
 	}
 	defer query.Close()
 
-	_, err = query.Exec(resource)
+	if returning != "" {
+		err = query.QueryRow(resource.Id, resource.UserId, resource.Href).Scan(&resource.CreatedAt, &resource.UpdatedAt)
+		return classifyError(err)
+	}
+
+	_, err = query.Exec(resource.Id, resource.UserId, resource.Href)
 	if err != nil {
-		return err
+		return classifyError(err)
 	}
 
-	return nil
+	return m.loadTimestamps("resources", resource.Id, &resource.CreatedAt, &resource.UpdatedAt)
 }
 
 func (m *Model) InsertResourceWithTransaction(resource *Resource) error {
@@ -81,89 +111,189 @@ func (m *Model) InsertResourceWithTransaction(resource *Resource) error {
 		return err
 	}
 
-	stmt := ""
-	query, err := m.DBSession.Prepare(stmt)
+	stmt := "INSERT INTO resources (id, user_id, href) VALUES (?, ?, ?)"
+	query, err := tx.Prepare(Rebind(m.Dialect, stmt))
 	if err != nil {
 		tx.Rollback()
 		return err
 	}
 	defer query.Close()
 
-	_, err = query.Exec(resource)
+	_, err = query.Exec(resource.Id, resource.UserId, resource.Href)
 	if err != nil {
 		tx.Rollback()
-		return err
+		return classifyError(err)
 	}
 
-	tx.Commit()
-
-	return nil
+	return classifyError(tx.Commit())
 }
 
 func (m *Model) GetResourceById(userId, resourceId string) (*Resource, error) {
 	var resource Resource
 
-	stmt := ""
-	query, err := m.DBSession.Prepare(stmt)
+	stmt := "SELECT " + resourceColumns + " FROM resources WHERE user_id = ? AND id = ?"
+	query, err := m.prepare(stmt)
 	if err != nil {
 		return &Resource{}, err
 	}
 	defer query.Close()
 
-	err = query.QueryRow(userId, resourceId).Scan()
+	err = query.QueryRow(userId, resourceId).Scan(&resource.Id, &resource.UserId, &resource.Href, &resource.CreatedAt, &resource.UpdatedAt)
 	if err != nil {
-		if err == sql.ErrNoRows {
-			return &Resource{}, err
-		}
-		return &Resource{}, err
+		return &Resource{}, classifyError(err)
 	}
 
 	return &resource, nil
 }
 
-func (m *Model) GetResources(userId string, offset, limit int) ([]Resource, error) {
+func (m *Model) GetResources(userId string, list ListQuery) ([]Resource, error) {
 	var resources []Resource
 
-	stmt := "? LIMIT ?, ?"
-	query, err := m.DBSession.Prepare(stmt)
+	order := ResourceOrder(list.Order)
+	columns := resourceColumnList
+	if list.Select != nil {
+		columns = sqlColumns(selectedFields(list.Select, order))
+	}
+
+	stmt := "SELECT " + strings.Join(columns, ", ") + " FROM resources WHERE user_id = ?"
+	args := []interface{}{userId}
+	if list.Filter != nil {
+		condition, filterArgs := sqlFilter(list.Filter, resourceFieldColumns, m.Dialect)
+		stmt += " AND " + condition
+		args = append(args, filterArgs...)
+	}
+	offset := list.Offset
+	switch {
+	case list.After != nil:
+		condition, keysetArgs := sqlKeyset(list.After, order, false, resourceFieldColumns, m.Dialect)
+		stmt += " AND " + condition
+		args = append(args, keysetArgs...)
+		offset = 0
+	case list.Before != nil:
+		if !list.Before.End {
+			condition, keysetArgs := sqlKeyset(list.Before, order, true, resourceFieldColumns, m.Dialect)
+			stmt += " AND " + condition
+			args = append(args, keysetArgs...)
+		}
+		offset = 0
+	}
+	stmt += sqlOrderBy(order, list.Before != nil, resourceFieldColumns)
+
+	paging, pagingArgs := m.Dialect.Paginate(offset, list.Limit)
+	query, err := m.prepare(stmt + paging)
 	if err != nil {
 		return nil, err
 	}
 	defer query.Close()
 
-	rows, err := query.Query(userId, offset, limit)
+	rows, err := query.Query(append(args, pagingArgs...)...)
 	if err != nil {
 		return nil, err
 	}
 	defer rows.Close()
 
 	for rows.Next() {
-		resource := Resource{}
+		resource := Resource{UserId: userId}
 
-		if err := rows.Scan(); err != nil {
+		if err := rows.Scan(resource.pointers(columns)...); err != nil {
 			return nil, err
 		}
 		resource.Href = system.ResourcesUrl + "/" + resource.Id
 		resources = append(resources, resource)
 	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
 
+	if list.Before != nil {
+		reverseResources(resources)
+	}
 	return resources, nil
 }
 
+func (m *Model) CountResources(userId string, filter system.Filter) (int, error) {
+	var count int
+
+	stmt := "SELECT COUNT(*) FROM resources WHERE user_id = ?"
+	args := []interface{}{userId}
+	if filter != nil {
+		condition, filterArgs := sqlFilter(filter, resourceFieldColumns, m.Dialect)
+		stmt += " AND " + condition
+		args = append(args, filterArgs...)
+	}
+	query, err := m.prepare(stmt)
+	if err != nil {
+		return 0, err
+	}
+	defer query.Close()
+
+	err = query.QueryRow(args...).Scan(&count)
+	return count, err
+}
+
+func (m *Model) AggregateResources(userId string, query AggregateQuery) ([]map[string]interface{}, error) {
+	var results []map[string]interface{}
+
+	var groups []string
+	for _, field := range query.GroupBy {
+		groups = append(groups, resourceFieldColumns[field])
+	}
+	selects := append([]string{}, groups...)
+	for _, aggregate := range query.Aggregates {
+		selects = append(selects, sqlAggregate(aggregate, resourceFieldColumns))
+	}
+
+	stmt := "SELECT " + strings.Join(selects, ", ") + " FROM resources WHERE user_id = ?"
+	args := []interface{}{userId}
+	if query.Filter != nil {
+		condition, filterArgs := sqlFilter(query.Filter, resourceFieldColumns, m.Dialect)
+		stmt += " AND " + condition
+		args = append(args, filterArgs...)
+	}
+	if len(groups) > 0 {
+		stmt += " GROUP BY " + strings.Join(groups, ", ") + " ORDER BY " + strings.Join(groups, ", ")
+	}
+
+	statement, err := m.prepare(stmt)
+	if err != nil {
+		return nil, err
+	}
+	defer statement.Close()
+
+	rows, err := statement.Query(args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		values := make([]interface{}, len(selects))
+		pointers := make([]interface{}, len(selects))
+		for i := range values {
+			pointers[i] = &values[i]
+		}
+		if err := rows.Scan(pointers...); err != nil {
+			return nil, err
+		}
+		results = append(results, aggregateResult(query, values))
+	}
+	return results, rows.Err()
+}
+
 func (m *Model) ResourceExists(resourceId string) (bool, error) {
-	var resource Resource
+	var id string
 
-	stmt := ""
-	query, err := m.DBSession.Prepare(stmt)
+	stmt := "SELECT id FROM resources WHERE id = ?"
+	query, err := m.prepare(stmt)
 	if err != nil {
 		return false, err
 	}
 	defer query.Close()
 
-	err = query.QueryRow(resourceId).Scan(&resource)
+	err = query.QueryRow(resourceId).Scan(&id)
 	if err != nil {
 		if err == sql.ErrNoRows {
-			return false, err
+			return false, nil
 		}
 		return false, err
 	}
@@ -171,33 +301,94 @@ func (m *Model) ResourceExists(resourceId string) (bool, error) {
 }
 
 func (m *Model) DeleteResourceById(userId, resourceId string) error {
-	stmt := ""
-	query, err := m.DBSession.Prepare(stmt)
+	stmt := "DELETE FROM resources WHERE user_id = ? AND id = ?"
+	query, err := m.prepare(stmt)
 	if err != nil {
 		return err
 	}
 	defer query.Close()
 
-	_, err = query.Exec(userId, resourceId)
+	result, err := query.Exec(userId, resourceId)
 	if err != nil {
-		return err
+		return classifyError(err)
 	}
 
-	return nil
+	return affectedRow(result)
 }
 
 func (m *Model) UpdateResource(resource *Resource, userId string) error {
-	stmt := ""
-	query, err := m.DBSession.Prepare(stmt)
+	stmt := "UPDATE resources SET href = ?, updated_at = CURRENT_TIMESTAMP WHERE id = ? AND user_id = ?"
+	returning := m.Dialect.Returning("created_at", "updated_at")
+	query, err := m.prepare(stmt + returning)
 	if err != nil {
 		return err
 	}
 	defer query.Close()
 
-	_, err = query.Exec(resource, userId)
+	if returning != "" {
+		err = query.QueryRow(resource.Href, resource.Id, userId).Scan(&resource.CreatedAt, &resource.UpdatedAt)
+		return classifyError(err)
+	}
+
+	result, err := query.Exec(resource.Href, resource.Id, userId)
+	if err != nil {
+		return classifyError(err)
+	}
+	if err = affectedRow(result); err != nil {
+		return err
+	}
+
+	return m.loadTimestamps("resources", resource.Id, &resource.CreatedAt, &resource.UpdatedAt)
+}
+
+func (m *Model) ModifyResource(userId, resourceId string, modify func(resource *Resource) error) (*Resource, error) {
+	var resource Resource
+
+	tx, err := m.DBSession.Begin()
+	if err != nil {
+		return nil, err
+	}
+
+	stmt := "SELECT " + resourceColumns + " FROM resources WHERE user_id = ? AND id = ?" + m.Dialect.LockRows()
+	err = tx.QueryRow(Rebind(m.Dialect, stmt), userId, resourceId).Scan(&resource.Id, &resource.UserId, &resource.Href, &resource.CreatedAt, &resource.UpdatedAt)
+	if err != nil {
+		tx.Rollback()
+		return nil, classifyError(err)
+	}
+
+	if err = modify(&resource); err != nil {
+		tx.Rollback()
+		return nil, err
+	}
+
+	stmt = "UPDATE resources SET href = ?, updated_at = CURRENT_TIMESTAMP WHERE id = ? AND user_id = ?"
+	_, err = tx.Exec(Rebind(m.Dialect, stmt), resource.Href, resourceId, userId)
+	if err != nil {
+		tx.Rollback()
+		return nil, classifyError(err)
+	}
+
+	if err = tx.Commit(); err != nil {
+		return nil, classifyError(err)
+	}
+
+	resource.Id = resourceId
+	return &resource, m.loadTimestamps("resources", resource.Id, &resource.CreatedAt, &resource.UpdatedAt)
+}
+
+// loadTimestamps reads back the timestamps set by the database for the row
+// id of table, on engines without RETURNING support
+func (m *Model) loadTimestamps(table, id string, createdAt, updatedAt *time.Time) error {
+	stmt := "SELECT created_at, updated_at FROM " + table + " WHERE id = ?"
+	query, err := m.prepare(stmt)
 	if err != nil {
 		return err
 	}
+	defer query.Close()
 
-	return nil
+	err = query.QueryRow(id).Scan(createdAt, updatedAt)
+	if err == sql.ErrNoRows {
+		return nil
+	}
+	return err
 }
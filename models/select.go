@@ -0,0 +1,127 @@
+/*
+Copyright (c) 2015, Alberto Corsín Lafuente
+All rights reserved.
+
+Redistribution and use in source and binary forms, with or without
+modification, are permitted provided that the following conditions are met:
+
+1. Redistributions of source code must retain the above copyright notice,
+   this list of conditions and the following disclaimer.
+
+2. Redistributions in binary form must reproduce the above copyright notice,
+   this list of conditions and the following disclaimer in the documentation
+   and/or other materials provided with the distribution.
+
+3. Neither the name of the copyright holder nor the names of its contributors
+   may be used to endorse or promote products derived from this software
+   without specific prior written permission.
+
+THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND
+ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED
+WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE
+FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER
+CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY,
+OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+*/
+
+package models
+
+import (
+	"github.com/acorsinl/casimiro/system"
+	"go.mongodb.org/mongo-driver/bson"
+	"strings"
+)
+
+// ResourceSelectable are the Resource fields that can be used in $select
+var ResourceSelectable = map[string]bool{
+	"id":        true,
+	"href":      true,
+	"createdAt": true,
+	"updatedAt": true,
+}
+
+// DefaultResourceSelect are the fields returned when $select isn't given
+var DefaultResourceSelect = []string{"id", "href"}
+
+// resourceColumnList are the columns in resourceColumns, in the same order
+var resourceColumnList = strings.Split(resourceColumns, ", ")
+
+// Data returns the given fields of resource as sent by the API
+func (resource *Resource) Data(fields []string) map[string]interface{} {
+	data := make(map[string]interface{})
+	for _, field := range fields {
+		switch field {
+		case "id":
+			data["id"] = resource.Id
+		case "href":
+			data["href"] = resource.Href
+		case "createdAt":
+			data["createdAt"] = resource.CreatedAt
+		case "updatedAt":
+			data["updatedAt"] = resource.UpdatedAt
+		}
+	}
+	return data
+}
+
+// pointers returns the fields of resource to scan the given columns into
+func (resource *Resource) pointers(columns []string) []interface{} {
+	pointers := make([]interface{}, len(columns))
+	for i, column := range columns {
+		switch column {
+		case "id":
+			pointers[i] = &resource.Id
+		case "user_id":
+			pointers[i] = &resource.UserId
+		case "href":
+			pointers[i] = &resource.Href
+		case "created_at":
+			pointers[i] = &resource.CreatedAt
+		case "updated_at":
+			pointers[i] = &resource.UpdatedAt
+		}
+	}
+	return pointers
+}
+
+// selectedFields returns the fields a listing must load to return fields
+// and sort by order. href isn't in the returned set since it is built from
+// the id.
+func selectedFields(fields []string, order []system.OrderBy) map[string]bool {
+	selected := map[string]bool{"id": true}
+	for _, key := range order {
+		selected[key.Field] = true
+	}
+	for _, field := range fields {
+		selected[field] = true
+	}
+	delete(selected, "href")
+	return selected
+}
+
+// sqlColumns returns the columns of resourceColumnList needed to load the
+// selected fields
+func sqlColumns(selected map[string]bool) []string {
+	var columns []string
+	for _, column := range resourceColumnList {
+		for field, fieldColumn := range resourceFieldColumns {
+			if selected[field] && fieldColumn == column {
+				columns = append(columns, column)
+			}
+		}
+	}
+	return columns
+}
+
+// mongoProjection returns the projection loading the selected fields
+func mongoProjection(selected map[string]bool) bson.M {
+	projection := bson.M{}
+	for field := range selected {
+		projection[resourceFieldKeys[field]] = 1
+	}
+	return projection
+}
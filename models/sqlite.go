@@ -0,0 +1,97 @@
+/*
+Copyright (c) 2015, Alberto Corsín Lafuente
+All rights reserved.
+
+Redistribution and use in source and binary forms, with or without
+modification, are permitted provided that the following conditions are met:
+
+1. Redistributions of source code must retain the above copyright notice,
+   this list of conditions and the following disclaimer.
+
+2. Redistributions in binary form must reproduce the above copyright notice,
+   this list of conditions and the following disclaimer in the documentation
+   and/or other materials provided with the distribution.
+
+3. Neither the name of the copyright holder nor the names of its contributors
+   may be used to endorse or promote products derived from this software
+   without specific prior written permission.
+
+THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND
+ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED
+WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE
+FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER
+CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY,
+OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+*/
+
+package models
+
+import (
+	"strings"
+	"time"
+)
+
+// SQLite is meant for local development and tests, no database server or
+// setup is needed.
+var SQLite Dialect = sqliteDialect{}
+
+type sqliteDialect struct{}
+
+func (sqliteDialect) DriverName() string {
+	return "sqlite3"
+}
+
+func (sqliteDialect) Placeholder(n int) string {
+	return "?"
+}
+
+func (sqliteDialect) Paginate(offset, limit int) (string, []interface{}) {
+	return " LIMIT ? OFFSET ?", []interface{}{limit, offset}
+}
+
+func (sqliteDialect) Upsert(table string, columns, conflict, updates []string) string {
+	set := make([]string, len(updates))
+	for i, column := range updates {
+		set[i] = column + " = excluded." + column
+	}
+	return insertStatement(table, columns) + " ON CONFLICT (" + strings.Join(conflict, ", ") + ") DO UPDATE SET " + strings.Join(set, ", ")
+}
+
+func (sqliteDialect) Returning(columns ...string) string {
+	return ""
+}
+
+// LockRows returns nothing as SQLite locks the whole database on writes
+func (sqliteDialect) LockRows() string {
+	return ""
+}
+
+// TimeValue returns t formatted like CURRENT_TIMESTAMP, since SQLite
+// compares timestamps as text
+func (sqliteDialect) TimeValue(t time.Time) interface{} {
+	return t.Format(timestampFormat)
+}
+
+func (sqliteDialect) Quote(identifier string) string {
+	return quoteIdentifier(identifier)
+}
+
+// sqlitePath returns the database file of a sqlite:///path/file.db or
+// sqlite::memory: uri, asking the driver to enforce foreign keys, which
+// SQLite ignores unless enabled on every connection
+func sqlitePath(dbUri string) string {
+	path := strings.TrimPrefix(dbUri, "sqlite:")
+	if strings.HasPrefix(dbUri, "sqlite://") {
+		path = strings.TrimPrefix(dbUri, "sqlite://")
+	}
+
+	separator := "?"
+	if strings.Contains(path, "?") {
+		separator = "&"
+	}
+	return path + separator + "_foreign_keys=1"
+}
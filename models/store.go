@@ -0,0 +1,77 @@
+/*
+Copyright (c) 2015, Alberto Corsín Lafuente
+All rights reserved.
+
+Redistribution and use in source and binary forms, with or without
+modification, are permitted provided that the following conditions are met:
+
+1. Redistributions of source code must retain the above copyright notice,
+   this list of conditions and the following disclaimer.
+
+2. Redistributions in binary form must reproduce the above copyright notice,
+   this list of conditions and the following disclaimer in the documentation
+   and/or other materials provided with the distribution.
+
+3. Neither the name of the copyright holder nor the names of its contributors
+   may be used to endorse or promote products derived from this software
+   without specific prior written permission.
+
+THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND
+ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED
+WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE
+FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER
+CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY,
+OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+*/
+
+package models
+
+import (
+	"github.com/acorsinl/casimiro/system"
+	"log"
+	"strings"
+)
+
+// ResourceStore is the set of operations the api package needs to serve
+// resources. Model implements it on top of database/sql, other backends can
+// be plugged in by implementing the same methods.
+type ResourceStore interface {
+	InsertResource(resource *Resource) error
+	GetResourceById(userId, resourceId string) (*Resource, error)
+	GetResources(userId string, query ListQuery) ([]Resource, error)
+	CountResources(userId string, filter system.Filter) (int, error)
+	AggregateResources(userId string, query AggregateQuery) ([]map[string]interface{}, error)
+	UpdateResource(resource *Resource, userId string) error
+	DeleteResourceById(userId, resourceId string) error
+	ResourceExists(resourceId string) (bool, error)
+	// ModifyResource loads a resource, passes it to modify and stores the
+	// result atomically. Nothing is stored if modify returns an error.
+	ModifyResource(userId, resourceId string, modify func(resource *Resource) error) (*Resource, error)
+}
+
+var _ ResourceStore = (*Model)(nil)
+
+// NewResourceStore opens the storage backend matching the scheme of dbUri.
+// memory:// keeps everything in a MemoryModel, MongoDB uris (mongodb://
+// and mongodb+srv://) use MongoModel and anything else is handled by Model
+// with the dialect chosen by ParseDBUri.
+func NewResourceStore(dbUri string) ResourceStore {
+	if dbUri == "memory://" {
+		log.Println("Using in-memory storage, data will be lost on exit")
+		return NewMemoryModel()
+	}
+
+	if strings.HasPrefix(dbUri, "mongodb://") || strings.HasPrefix(dbUri, "mongodb+srv://") {
+		store := &MongoModel{}
+		store.InitDB(dbUri)
+		return store
+	}
+
+	model := &Model{}
+	model.InitDB(dbUri)
+	return model
+}
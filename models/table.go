@@ -0,0 +1,345 @@
+/*
+Copyright (c) 2015, Alberto Corsín Lafuente
+All rights reserved.
+
+Redistribution and use in source and binary forms, with or without
+modification, are permitted provided that the following conditions are met:
+
+1. Redistributions of source code must retain the above copyright notice,
+   this list of conditions and the following disclaimer.
+
+2. Redistributions in binary form must reproduce the above copyright notice,
+   this list of conditions and the following disclaimer in the documentation
+   and/or other materials provided with the distribution.
+
+3. Neither the name of the copyright holder nor the names of its contributors
+   may be used to endorse or promote products derived from this software
+   without specific prior written permission.
+
+THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND
+ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED
+WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE
+FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER
+CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY,
+OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+*/
+
+package models
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+)
+
+// Store is the storage of a resource registered with api.RegisterResource.
+// Items are always scoped to the user owning them. T must be a struct with
+// a string field tagged `db:"id"`; every other field tagged with db is
+// stored in the column (or document key) of that name.
+type Store[T any] interface {
+	Insert(userId string, item *T) error
+	GetById(userId, id string) (*T, error)
+	List(userId string, offset, limit int) ([]T, error)
+	Update(userId string, item *T) error
+	// Modify loads an item, passes it to modify and stores the result
+	// atomically, so concurrent modifications don't overwrite each other.
+	// Errors returned by modify abort it and are returned unchanged.
+	Modify(userId, id string, modify func(item *T) error) (*T, error)
+	Delete(userId, id string) error
+}
+
+// NewTable returns a Store for T in the same backend as store, keeping items
+// in the table or collection called name. SQL tables must be created with a
+// migration: an id primary key, a user_id column and one column per field.
+// Other stores than Model, MongoModel and MemoryModel aren't supported.
+func NewTable[T any](store ResourceStore, name string) (Store[T], error) {
+	switch backend := store.(type) {
+	case *Model:
+		return NewSQLTable[T](backend, name), nil
+	case *MongoModel:
+		return NewMongoTable[T](backend.Resources.Database().Collection(name)), nil
+	case *MemoryModel:
+		return NewMemoryTable[T](), nil
+	}
+	return nil, fmt.Errorf("Unsupported store %T for %s", store, name)
+}
+
+type column struct {
+	name  string
+	index int
+}
+
+// columns returns the db tagged fields of T. It panics if T isn't a struct
+// with a string id column, as that is a programming error.
+func columns[T any]() []column {
+	var columns []column
+	t := reflect.TypeOf((*T)(nil)).Elem()
+	if t.Kind() != reflect.Struct {
+		panic("models: " + t.String() + " is not a struct")
+	}
+
+	hasId := false
+	for i := 0; i < t.NumField(); i++ {
+		name := strings.Split(t.Field(i).Tag.Get("db"), ",")[0]
+		if name == "" || name == "-" || t.Field(i).PkgPath != "" {
+			continue
+		}
+		if name == "id" {
+			if t.Field(i).Type.Kind() != reflect.String {
+				panic("models: id field of " + t.String() + " must be a string")
+			}
+			hasId = true
+		}
+		columns = append(columns, column{name: name, index: i})
+	}
+
+	if !hasId {
+		panic("models: " + t.String() + " has no field tagged `db:\"id\"`")
+	}
+	return columns
+}
+
+// ItemId returns the value of the id field of item
+func ItemId(item interface{}) string {
+	return idField(item).String()
+}
+
+// SetItemId sets the id field of item, which must be a pointer
+func SetItemId(item interface{}, id string) {
+	idField(item).SetString(id)
+}
+
+func idField(item interface{}) reflect.Value {
+	v := reflect.Indirect(reflect.ValueOf(item))
+	for i := 0; i < v.NumField(); i++ {
+		if strings.Split(v.Type().Field(i).Tag.Get("db"), ",")[0] == "id" {
+			return v.Field(i)
+		}
+	}
+	panic("models: " + v.Type().String() + " has no field tagged `db:\"id\"`")
+}
+
+// SQLTable is a Store on top of a table of a SQL database
+type SQLTable[T any] struct {
+	Model   *Model
+	Table   string
+	columns []column
+	names   []string
+}
+
+func NewSQLTable[T any](model *Model, table string) *SQLTable[T] {
+	t := &SQLTable[T]{Model: model, Table: table, columns: columns[T]()}
+	for _, c := range t.columns {
+		if c.name != "user_id" {
+			t.names = append(t.names, c.name)
+		}
+	}
+	return t
+}
+
+func (t *SQLTable[T]) Insert(userId string, item *T) error {
+	stmt := insertStatement(t.table(), t.quote(append([]string{"user_id"}, t.names...)))
+	query, err := t.Model.prepare(stmt)
+	if err != nil {
+		return err
+	}
+	defer query.Close()
+
+	_, err = query.Exec(append([]interface{}{userId}, t.values(item)...)...)
+	return classifyError(err)
+}
+
+func (t *SQLTable[T]) GetById(userId, id string) (*T, error) {
+	item := new(T)
+
+	stmt := "SELECT " + strings.Join(t.quote(t.names), ", ") + " FROM " + t.table() + " WHERE user_id = ? AND id = ?"
+	query, err := t.Model.prepare(stmt)
+	if err != nil {
+		return nil, err
+	}
+	defer query.Close()
+
+	err = query.QueryRow(userId, id).Scan(t.pointers(item)...)
+	if err != nil {
+		return nil, classifyError(err)
+	}
+
+	t.setOwner(item, userId)
+	return item, nil
+}
+
+func (t *SQLTable[T]) List(userId string, offset, limit int) ([]T, error) {
+	var items []T
+
+	paging, args := t.Model.Dialect.Paginate(offset, limit)
+	stmt := "SELECT " + strings.Join(t.quote(t.names), ", ") + " FROM " + t.table() + " WHERE user_id = ? ORDER BY id" + paging
+	query, err := t.Model.prepare(stmt)
+	if err != nil {
+		return nil, err
+	}
+	defer query.Close()
+
+	rows, err := query.Query(append([]interface{}{userId}, args...)...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		item := new(T)
+		if err := rows.Scan(t.pointers(item)...); err != nil {
+			return nil, err
+		}
+		t.setOwner(item, userId)
+		items = append(items, *item)
+	}
+
+	return items, rows.Err()
+}
+
+func (t *SQLTable[T]) Update(userId string, item *T) error {
+	stmt, args := t.updateStatement(userId, item)
+	if stmt == "" {
+		return nil
+	}
+
+	query, err := t.Model.prepare(stmt)
+	if err != nil {
+		return err
+	}
+	defer query.Close()
+
+	result, err := query.Exec(args...)
+	if err != nil {
+		return classifyError(err)
+	}
+	return affectedRow(result)
+}
+
+func (t *SQLTable[T]) Modify(userId, id string, modify func(item *T) error) (*T, error) {
+	item := new(T)
+
+	tx, err := t.Model.DBSession.Begin()
+	if err != nil {
+		return nil, err
+	}
+
+	stmt := "SELECT " + strings.Join(t.quote(t.names), ", ") + " FROM " + t.table() + " WHERE user_id = ? AND id = ?" + t.Model.Dialect.LockRows()
+	err = tx.QueryRow(Rebind(t.Model.Dialect, stmt), userId, id).Scan(t.pointers(item)...)
+	if err != nil {
+		tx.Rollback()
+		return nil, classifyError(err)
+	}
+	t.setOwner(item, userId)
+
+	if err = modify(item); err != nil {
+		tx.Rollback()
+		return nil, err
+	}
+	SetItemId(item, id)
+	t.setOwner(item, userId)
+
+	if stmt, args := t.updateStatement(userId, item); stmt != "" {
+		if _, err = tx.Exec(Rebind(t.Model.Dialect, stmt), args...); err != nil {
+			tx.Rollback()
+			return nil, classifyError(err)
+		}
+	}
+
+	if err = tx.Commit(); err != nil {
+		return nil, classifyError(err)
+	}
+	return item, nil
+}
+
+func (t *SQLTable[T]) Delete(userId, id string) error {
+	stmt := "DELETE FROM " + t.table() + " WHERE user_id = ? AND id = ?"
+	query, err := t.Model.prepare(stmt)
+	if err != nil {
+		return err
+	}
+	defer query.Close()
+
+	result, err := query.Exec(userId, id)
+	if err != nil {
+		return classifyError(err)
+	}
+	return affectedRow(result)
+}
+
+// updateStatement returns the UPDATE of every field of item but its id,
+// along with its arguments. The statement is empty if there is no field.
+func (t *SQLTable[T]) updateStatement(userId string, item *T) (string, []interface{}) {
+	set := make([]string, 0, len(t.names))
+	args := make([]interface{}, 0, len(t.names)+1)
+	for i, value := range t.values(item) {
+		if t.names[i] != "id" {
+			set = append(set, t.Model.Dialect.Quote(t.names[i])+" = ?")
+			args = append(args, value)
+		}
+	}
+	if len(set) == 0 {
+		return "", nil
+	}
+
+	stmt := "UPDATE " + t.table() + " SET " + strings.Join(set, ", ") + " WHERE user_id = ? AND id = ?"
+	return stmt, append(args, userId, ItemId(item))
+}
+
+// table returns the name of the table quoted, as it could be a keyword
+func (t *SQLTable[T]) table() string {
+	return t.Model.Dialect.Quote(t.Table)
+}
+
+// quote returns the column names quoted, they could be keywords too
+func (t *SQLTable[T]) quote(names []string) []string {
+	quoted := make([]string, len(names))
+	for i, name := range names {
+		quoted[i] = t.Model.Dialect.Quote(name)
+	}
+	return quoted
+}
+
+// values returns the field values of item in the order of t.names
+func (t *SQLTable[T]) values(item *T) []interface{} {
+	v := reflect.ValueOf(item).Elem()
+	values := make([]interface{}, 0, len(t.names))
+	for _, c := range t.columns {
+		if c.name != "user_id" {
+			values = append(values, v.Field(c.index).Interface())
+		}
+	}
+	return values
+}
+
+// pointers returns the addresses of the fields of item in the order of t.names
+func (t *SQLTable[T]) pointers(item *T) []interface{} {
+	v := reflect.ValueOf(item).Elem()
+	pointers := make([]interface{}, 0, len(t.names))
+	for _, c := range t.columns {
+		if c.name != "user_id" {
+			pointers = append(pointers, v.Field(c.index).Addr().Interface())
+		}
+	}
+	return pointers
+}
+
+func (t *SQLTable[T]) setOwner(item *T, userId string) {
+	setOwner(t.columns, item, userId)
+}
+
+// setOwner fills the user_id field of item, if it has one
+func setOwner[T any](columns []column, item *T, userId string) {
+	for _, c := range columns {
+		if c.name == "user_id" {
+			field := reflect.ValueOf(item).Elem().Field(c.index)
+			if field.Kind() == reflect.String {
+				field.SetString(userId)
+			}
+		}
+	}
+}
@@ -0,0 +1,98 @@
+/*
+Copyright (c) 2015, Alberto Corsín Lafuente
+All rights reserved.
+
+Redistribution and use in source and binary forms, with or without
+modification, are permitted provided that the following conditions are met:
+
+1. Redistributions of source code must retain the above copyright notice,
+   this list of conditions and the following disclaimer.
+
+2. Redistributions in binary form must reproduce the above copyright notice,
+   this list of conditions and the following disclaimer in the documentation
+   and/or other materials provided with the distribution.
+
+3. Neither the name of the copyright holder nor the names of its contributors
+   may be used to endorse or promote products derived from this software
+   without specific prior written permission.
+
+THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND
+ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED
+WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE
+FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER
+CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY,
+OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+*/
+
+package models
+
+import (
+	"errors"
+	"testing"
+)
+
+// order has a table and columns named like SQL keywords
+type order struct {
+	Id    string `db:"id"`
+	User  string `db:"user"`
+	Order int    `db:"order"`
+}
+
+func TestSQLTableQuotesNames(t *testing.T) {
+	m := &Model{}
+	m.InitDB("sqlite::memory:")
+	defer m.DBSession.Close()
+	_, err := m.DBSession.Exec(`CREATE TABLE "order" (id TEXT PRIMARY KEY, user_id TEXT NOT NULL, "user" TEXT NOT NULL, "order" INTEGER NOT NULL)`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	table, err := NewTable[order](m, "order")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = table.Insert("alice", &order{Id: "o1", User: "bob", Order: 1}); err != nil {
+		t.Fatal(err)
+	}
+	if err = table.Update("alice", &order{Id: "o1", User: "carol", Order: 2}); err != nil {
+		t.Fatal(err)
+	}
+	modified, err := table.Modify("alice", "o1", func(item *order) error {
+		item.Order++
+		return nil
+	})
+	if err != nil || modified.User != "carol" || modified.Order != 3 {
+		t.Fatalf("unexpected modification %+v, %v", modified, err)
+	}
+
+	items, err := table.List("alice", 0, 10)
+	if err != nil || len(items) != 1 || items[0] != *modified {
+		t.Errorf("unexpected list %v, %v", items, err)
+	}
+	if _, err = table.GetById("bob", "o1"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("expected ErrNotFound for another user, got %v", err)
+	}
+	if err = table.Delete("alice", "o1"); err != nil {
+		t.Fatal(err)
+	}
+	if err = table.Delete("alice", "o1"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("expected ErrNotFound deleting twice, got %v", err)
+	}
+}
+
+func TestNewTableStores(t *testing.T) {
+	table, err := NewTable[order](NewMemoryModel(), "order")
+	if _, ok := table.(*MemoryTable[order]); !ok || err != nil {
+		t.Errorf("expected a MemoryTable, got %T, %v", table, err)
+	}
+
+	// Other stores would lose the items on restart
+	unknown := struct{ ResourceStore }{NewMemoryModel()}
+	if _, err = NewTable[order](unknown, "order"); err == nil {
+		t.Error("expected an error for an unsupported store")
+	}
+}
@@ -0,0 +1,256 @@
+/*
+Copyright (c) 2015, Alberto Corsín Lafuente
+All rights reserved.
+
+Redistribution and use in source and binary forms, with or without
+modification, are permitted provided that the following conditions are met:
+
+1. Redistributions of source code must retain the above copyright notice,
+   this list of conditions and the following disclaimer.
+
+2. Redistributions in binary form must reproduce the above copyright notice,
+   this list of conditions and the following disclaimer in the documentation
+   and/or other materials provided with the distribution.
+
+3. Neither the name of the copyright holder nor the names of its contributors
+   may be used to endorse or promote products derived from this software
+   without specific prior written permission.
+
+THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND
+ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED
+WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE
+FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER
+CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY,
+OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+*/
+
+package models
+
+import (
+	"database/sql"
+	"time"
+)
+
+// User is a local account, used when Casimiro authenticates users itself
+type User struct {
+	Id           string
+	Username     string
+	PasswordHash string
+	// FailedLogins counts the login attempts since the last successful one,
+	// the account is locked until LockedUntil after too many
+	FailedLogins int
+	LockedUntil  *time.Time
+	CreatedAt    time.Time
+	UpdatedAt    time.Time
+}
+
+// Session is a login of a user. Its tokens are only stored hashed: the
+// short lived access token authenticates requests and the refresh token
+// gets a new pair of tokens until the session expires.
+type Session struct {
+	Id              string
+	UserId          string
+	AccessHash      string
+	RefreshHash     string
+	AccessExpiresAt time.Time
+	ExpiresAt       time.Time
+	CreatedAt       time.Time
+	UpdatedAt       time.Time
+}
+
+// UserStore is the set of operations needed to authenticate local users.
+// Model, MemoryModel and MongoModel implement it.
+type UserStore interface {
+	InsertUser(user *User) error
+	GetUserById(userId string) (*User, error)
+	GetUserByName(username string) (*User, error)
+	// RecordLoginAttempt counts a login attempt of the user, before its
+	// password is checked, locking the account until lockedUntil when it
+	// makes maxAttempts. It returns false, without counting it, if the
+	// account is locked at now.
+	RecordLoginAttempt(userId string, maxAttempts int, now, lockedUntil time.Time) (bool, error)
+	// ResetLoginAttempts unlocks the user and clears its login attempts
+	ResetLoginAttempts(userId string) error
+	InsertSession(session *Session) error
+	GetSessionByAccessHash(hash string) (*Session, error)
+	GetSessionByRefreshHash(hash string) (*Session, error)
+	// RotateSession stores the new tokens of session if its refresh hash is
+	// still refreshHash, returning ErrNotFound otherwise
+	RotateSession(session *Session, refreshHash string) error
+	DeleteSession(sessionId string) error
+}
+
+var _ UserStore = (*Model)(nil)
+
+const (
+	userColumns    = "id, username, password_hash, failed_logins, locked_until, created_at, updated_at"
+	sessionColumns = "id, user_id, access_hash, refresh_hash, access_expires_at, expires_at, created_at, updated_at"
+)
+
+func (m *Model) InsertUser(user *User) error {
+	stmt := "INSERT INTO users (id, username, password_hash, failed_logins, locked_until) VALUES (?, ?, ?, ?, ?)"
+	query, err := m.prepare(stmt)
+	if err != nil {
+		return err
+	}
+	defer query.Close()
+
+	_, err = query.Exec(user.Id, user.Username, user.PasswordHash, user.FailedLogins, m.nullTime(user.LockedUntil))
+	if err != nil {
+		return classifyError(err)
+	}
+
+	return m.loadTimestamps("users", user.Id, &user.CreatedAt, &user.UpdatedAt)
+}
+
+func (m *Model) GetUserById(userId string) (*User, error) {
+	return m.getUser("id = ?", userId)
+}
+
+func (m *Model) GetUserByName(username string) (*User, error) {
+	return m.getUser("username = ?", username)
+}
+
+func (m *Model) getUser(condition string, args ...interface{}) (*User, error) {
+	var user User
+	var lockedUntil sql.NullTime
+
+	stmt := "SELECT " + userColumns + " FROM users WHERE " + condition
+	query, err := m.prepare(stmt)
+	if err != nil {
+		return nil, err
+	}
+	defer query.Close()
+
+	err = query.QueryRow(args...).Scan(&user.Id, &user.Username, &user.PasswordHash, &user.FailedLogins, &lockedUntil, &user.CreatedAt, &user.UpdatedAt)
+	if err != nil {
+		return nil, classifyError(err)
+	}
+	if lockedUntil.Valid {
+		user.LockedUntil = &lockedUntil.Time
+	}
+	return &user, nil
+}
+
+func (m *Model) RecordLoginAttempt(userId string, maxAttempts int, now, lockedUntil time.Time) (bool, error) {
+	// locked_until is set first as MySQL evaluates the assignments in order
+	stmt := "UPDATE users SET" +
+		" locked_until = CASE WHEN failed_logins + 1 >= ? THEN ? ELSE locked_until END," +
+		" failed_logins = CASE WHEN failed_logins + 1 >= ? THEN 0 ELSE failed_logins + 1 END," +
+		" updated_at = CURRENT_TIMESTAMP" +
+		" WHERE id = ? AND (locked_until IS NULL OR locked_until <= ?)"
+	query, err := m.prepare(stmt)
+	if err != nil {
+		return false, err
+	}
+	defer query.Close()
+
+	result, err := query.Exec(maxAttempts, m.Dialect.TimeValue(lockedUntil.UTC()), maxAttempts, userId, m.Dialect.TimeValue(now.UTC()))
+	if err != nil {
+		return false, classifyError(err)
+	}
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+	return affected > 0, nil
+}
+
+func (m *Model) ResetLoginAttempts(userId string) error {
+	stmt := "UPDATE users SET failed_logins = 0, locked_until = NULL, updated_at = CURRENT_TIMESTAMP WHERE id = ?"
+	query, err := m.prepare(stmt)
+	if err != nil {
+		return err
+	}
+	defer query.Close()
+
+	result, err := query.Exec(userId)
+	if err != nil {
+		return classifyError(err)
+	}
+
+	return affectedRow(result)
+}
+
+func (m *Model) InsertSession(session *Session) error {
+	stmt := "INSERT INTO sessions (id, user_id, access_hash, refresh_hash, access_expires_at, expires_at) VALUES (?, ?, ?, ?, ?, ?)"
+	query, err := m.prepare(stmt)
+	if err != nil {
+		return err
+	}
+	defer query.Close()
+
+	_, err = query.Exec(session.Id, session.UserId, session.AccessHash, session.RefreshHash,
+		m.Dialect.TimeValue(session.AccessExpiresAt.UTC()), m.Dialect.TimeValue(session.ExpiresAt.UTC()))
+	if err != nil {
+		return classifyError(err)
+	}
+
+	return m.loadTimestamps("sessions", session.Id, &session.CreatedAt, &session.UpdatedAt)
+}
+
+func (m *Model) GetSessionByAccessHash(hash string) (*Session, error) {
+	return m.getSession("access_hash = ?", hash)
+}
+
+func (m *Model) GetSessionByRefreshHash(hash string) (*Session, error) {
+	return m.getSession("refresh_hash = ?", hash)
+}
+
+func (m *Model) getSession(condition string, args ...interface{}) (*Session, error) {
+	var session Session
+
+	stmt := "SELECT " + sessionColumns + " FROM sessions WHERE " + condition
+	query, err := m.prepare(stmt)
+	if err != nil {
+		return nil, err
+	}
+	defer query.Close()
+
+	err = query.QueryRow(args...).Scan(&session.Id, &session.UserId, &session.AccessHash, &session.RefreshHash,
+		&session.AccessExpiresAt, &session.ExpiresAt, &session.CreatedAt, &session.UpdatedAt)
+	if err != nil {
+		return nil, classifyError(err)
+	}
+	return &session, nil
+}
+
+func (m *Model) RotateSession(session *Session, refreshHash string) error {
+	stmt := "UPDATE sessions SET access_hash = ?, refresh_hash = ?, access_expires_at = ?, expires_at = ?, updated_at = CURRENT_TIMESTAMP WHERE id = ? AND refresh_hash = ?"
+	query, err := m.prepare(stmt)
+	if err != nil {
+		return err
+	}
+	defer query.Close()
+
+	result, err := query.Exec(session.AccessHash, session.RefreshHash,
+		m.Dialect.TimeValue(session.AccessExpiresAt.UTC()), m.Dialect.TimeValue(session.ExpiresAt.UTC()), session.Id, refreshHash)
+	if err != nil {
+		return classifyError(err)
+	}
+	if err = affectedRow(result); err != nil {
+		return err
+	}
+
+	return m.loadTimestamps("sessions", session.Id, &session.CreatedAt, &session.UpdatedAt)
+}
+
+func (m *Model) DeleteSession(sessionId string) error {
+	stmt := "DELETE FROM sessions WHERE id = ?"
+	query, err := m.prepare(stmt)
+	if err != nil {
+		return err
+	}
+	defer query.Close()
+
+	result, err := query.Exec(sessionId)
+	if err != nil {
+		return classifyError(err)
+	}
+
+	return affectedRow(result)
+}
@@ -0,0 +1,167 @@
+/*
+Copyright (c) 2015, Alberto Corsín Lafuente
+All rights reserved.
+
+Redistribution and use in source and binary forms, with or without
+modification, are permitted provided that the following conditions are met:
+
+1. Redistributions of source code must retain the above copyright notice,
+   this list of conditions and the following disclaimer.
+
+2. Redistributions in binary form must reproduce the above copyright notice,
+   this list of conditions and the following disclaimer in the documentation
+   and/or other materials provided with the distribution.
+
+3. Neither the name of the copyright holder nor the names of its contributors
+   may be used to endorse or promote products derived from this software
+   without specific prior written permission.
+
+THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND
+ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED
+WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE
+FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER
+CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY,
+OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+*/
+package problem
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"strconv"
+)
+
+// ContentType is the media type of problem details (RFC 7807)
+const ContentType = "application/problem+json"
+
+// BaseURI prefixes the type of every problem, point it to where the
+// problem types are documented
+var BaseURI = "/problems/"
+
+// Problem is an error answered as an RFC 7807 problem details object.
+// Handlers return the constructors below and write them with Write.
+type Problem struct {
+	Type     string       `json:"type"`
+	Title    string       `json:"title"`
+	Status   int          `json:"status"`
+	Detail   string       `json:"detail,omitempty"`
+	Instance string       `json:"instance,omitempty"`
+	Errors   []FieldError `json:"errors,omitempty"`
+	// RetryAfter, in seconds, is sent in the Retry-After header when set
+	RetryAfter int `json:"-"`
+	cause      error
+}
+
+// FieldError tells what is wrong with one of the fields of a request
+type FieldError struct {
+	Field   string `json:"field"`
+	Message string `json:"message"`
+}
+
+// New returns a problem of type kind, titled after status
+func New(status int, kind, detail string) *Problem {
+	return &Problem{Type: BaseURI + kind, Title: http.StatusText(status), Status: status, Detail: detail}
+}
+
+func BadRequest(detail string) *Problem {
+	return New(http.StatusBadRequest, "bad-request", detail)
+}
+
+func Unauthorized(detail string) *Problem {
+	return New(http.StatusUnauthorized, "unauthorized", detail)
+}
+
+func Forbidden(detail string) *Problem {
+	return New(http.StatusForbidden, "forbidden", detail)
+}
+
+func NotFound(detail string) *Problem {
+	return New(http.StatusNotFound, "not-found", detail)
+}
+
+func Conflict(detail string) *Problem {
+	return New(http.StatusConflict, "conflict", detail)
+}
+
+func UnsupportedMediaType(detail string) *Problem {
+	return New(http.StatusUnsupportedMediaType, "unsupported-media-type", detail)
+}
+
+// Validation is answered when a request is well formed but its content
+// isn't valid, errors lists the offending fields
+func Validation(detail string, errors ...FieldError) *Problem {
+	problem := New(http.StatusUnprocessableEntity, "validation", detail)
+	problem.Errors = errors
+	return problem
+}
+
+// Unavailable is answered on transient failures, clients are asked to
+// retry after a second
+func Unavailable(detail string) *Problem {
+	problem := New(http.StatusServiceUnavailable, "unavailable", detail)
+	problem.RetryAfter = 1
+	return problem
+}
+
+// Internal wraps an unexpected error. Its text is only logged, clients
+// get a generic detail.
+func Internal(err error) *Problem {
+	problem := New(http.StatusInternalServerError, "internal", "The request couldn't be completed")
+	problem.cause = err
+	return problem
+}
+
+// Wrap keeps err as the cause of p, logged along with it
+func (p *Problem) Wrap(err error) *Problem {
+	p.cause = err
+	return p
+}
+
+func (p *Problem) Error() string {
+	if p.cause != nil {
+		return p.Title + ": " + p.cause.Error()
+	}
+	return p.Title + ": " + p.Detail
+}
+
+func (p *Problem) Unwrap() error {
+	return p.cause
+}
+
+// From returns err as a Problem, errors that aren't one are internal
+func From(err error) *Problem {
+	var problem *Problem
+	if errors.As(err, &problem) {
+		return problem
+	}
+	return Internal(err)
+}
+
+// Write sends err to the client as application/problem+json. The request
+// path is used as the instance and server errors are logged.
+func Write(w http.ResponseWriter, r *http.Request, err error) {
+	problem := *From(err)
+	if problem.Instance == "" {
+		problem.Instance = r.URL.Path
+	}
+	if problem.Status >= http.StatusInternalServerError {
+		log.Printf("%s %s: %v", r.Method, r.URL.Path, problem.Error())
+	}
+
+	output, err := json.Marshal(problem)
+	if err != nil {
+		log.Println("JSON Encoding failed")
+	}
+	w.Header().Set("Content-Type", ContentType)
+	if problem.RetryAfter > 0 {
+		w.Header().Set("Retry-After", strconv.Itoa(problem.RetryAfter))
+	}
+	w.WriteHeader(problem.Status)
+	w.Write(output)
+}
@@ -0,0 +1,384 @@
+/*
+Copyright (c) 2015, Alberto Corsín Lafuente
+All rights reserved.
+
+Redistribution and use in source and binary forms, with or without
+modification, are permitted provided that the following conditions are met:
+
+1. Redistributions of source code must retain the above copyright notice,
+   this list of conditions and the following disclaimer.
+
+2. Redistributions in binary form must reproduce the above copyright notice,
+   this list of conditions and the following disclaimer in the documentation
+   and/or other materials provided with the distribution.
+
+3. Neither the name of the copyright holder nor the names of its contributors
+   may be used to endorse or promote products derived from this software
+   without specific prior written permission.
+
+THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND
+ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED
+WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE
+FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER
+CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY,
+OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+*/
+
+// Package scaffold generates the files needed to serve a new resource
+// following the conventions of the Resource files: model with SQL queries,
+// api handlers and their tests, routes in server.go, the url constant in
+// system and a migration.
+package scaffold
+
+import (
+	"bytes"
+	"embed"
+	"errors"
+	"fmt"
+	"go/format"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"text/template"
+	"unicode"
+)
+
+//go:embed templates/*.tmpl
+var templateFiles embed.FS
+
+var templates = template.Must(template.ParseFS(templateFiles, "templates/*.tmpl"))
+
+// Field types accepted in field:type arguments, with their Go and SQL types
+var fieldTypes = map[string][2]string{
+	"string": {"string", "VARCHAR(255) NOT NULL DEFAULT ''"},
+	"text":   {"string", "TEXT NOT NULL"},
+	"int":    {"int", "INTEGER NOT NULL DEFAULT 0"},
+	"int64":  {"int64", "BIGINT NOT NULL DEFAULT 0"},
+	"float":  {"float64", "DOUBLE PRECISION NOT NULL DEFAULT 0"},
+	"bool":   {"bool", "BOOLEAN NOT NULL DEFAULT FALSE"},
+	"time":   {"time.Time", "TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP"},
+}
+
+// Validation rules matching the column limits of some field types
+var fieldRules = map[string]string{
+	"string": "max=255",
+}
+
+// Fields every generated resource already has
+var reservedFields = map[string]bool{
+	"id": true, "user_id": true, "href": true, "created_at": true, "updated_at": true,
+}
+
+// Keywords reserved by some of the supported engines. Migrations are shared
+// by all of them and can't quote names portably, so tables and columns
+// can't be named like these.
+var reservedWords = map[string]bool{
+	"all": true, "alter": true, "analyze": true, "and": true, "any": true, "array": true,
+	"as": true, "asc": true, "between": true, "both": true, "by": true, "case": true,
+	"cast": true, "check": true, "collate": true, "column": true, "constraint": true,
+	"create": true, "cross": true, "current_date": true, "current_time": true,
+	"current_timestamp": true, "current_user": true, "default": true, "delete": true,
+	"desc": true, "distinct": true, "do": true, "drop": true, "else": true, "end": true,
+	"except": true, "exists": true, "false": true, "fetch": true, "for": true,
+	"foreign": true, "from": true, "grant": true, "group": true, "groups": true,
+	"having": true, "in": true, "index": true, "inner": true, "insert": true,
+	"interval": true, "intersect": true, "into": true, "is": true, "join": true,
+	"key": true, "keys": true, "leading": true, "left": true, "like": true, "limit": true,
+	"match": true, "natural": true, "not": true, "null": true, "offset": true, "on": true,
+	"or": true, "order": true, "outer": true, "over": true, "partition": true,
+	"primary": true, "range": true, "rank": true, "references": true, "right": true,
+	"row": true, "rows": true, "select": true, "set": true, "table": true, "then": true,
+	"to": true, "trailing": true, "true": true, "union": true, "unique": true,
+	"update": true, "user": true, "using": true, "values": true, "when": true,
+	"where": true, "window": true, "with": true,
+}
+
+var identifier = regexp.MustCompile(`^[A-Za-z][A-Za-z0-9_]*$`)
+
+type Field struct {
+	Name     string
+	JSON     string
+	Column   string
+	GoType   string
+	SQLType  string
+	Validate string
+}
+
+type Resource struct {
+	Name      string
+	Plural    string
+	Var       string
+	VarPlural string
+	Table     string
+	UrlConst  string
+	Fields    []Field
+}
+
+// NewResource builds a Resource from its singular name and field:type specs
+func NewResource(name string, specs []string) (*Resource, error) {
+	if !identifier.MatchString(name) {
+		return nil, fmt.Errorf("Invalid resource name %q", name)
+	}
+
+	name = camel(name, true)
+	plural := pluralize(name)
+	resource := &Resource{
+		Name:      name,
+		Plural:    plural,
+		Var:       camel(name, false),
+		VarPlural: camel(plural, false),
+		Table:     snake(plural),
+		UrlConst:  plural + "Url",
+	}
+	if resource.Name == "Resource" {
+		return nil, errors.New("Resource already exists")
+	}
+	if reservedWords[resource.Table] {
+		return nil, fmt.Errorf("Table name %s is an SQL keyword", resource.Table)
+	}
+
+	seen := make(map[string]bool)
+	for _, spec := range specs {
+		parts := strings.SplitN(spec, ":", 2)
+		if len(parts) != 2 || !identifier.MatchString(parts[0]) {
+			return nil, fmt.Errorf("Invalid field %q, expected name:type", spec)
+		}
+		types, ok := fieldTypes[parts[1]]
+		if !ok {
+			return nil, fmt.Errorf("Unknown type %q for field %s", parts[1], parts[0])
+		}
+
+		column := snake(parts[0])
+		if reservedFields[column] || seen[column] {
+			return nil, fmt.Errorf("Field %s is reserved or repeated", parts[0])
+		}
+		if reservedWords[column] {
+			return nil, fmt.Errorf("Field %s is an SQL keyword", parts[0])
+		}
+		seen[column] = true
+
+		resource.Fields = append(resource.Fields, Field{
+			Name:     camel(parts[0], true),
+			JSON:     camel(parts[0], false),
+			Column:   column,
+			GoType:   types[0],
+			SQLType:  types[1],
+			Validate: fieldRules[parts[1]],
+		})
+	}
+	return resource, nil
+}
+
+// ScanArgs returns the Scan destinations for the columns of a select
+func (r *Resource) ScanArgs(v string) string {
+	args := []string{"&" + v + ".Id", "&" + v + ".UserId"}
+	for _, field := range r.Fields {
+		args = append(args, "&"+v+"."+field.Name)
+	}
+	args = append(args, "&"+v+".CreatedAt", "&"+v+".UpdatedAt")
+	return strings.Join(args, ", ")
+}
+
+// Generate writes the files of resource into the Casimiro tree at root and
+// registers it in server.go and system/core.go. It returns the paths it
+// created or modified. Existing files are never overwritten.
+func Generate(root string, resource *Resource) ([]string, error) {
+	version, err := nextMigration(filepath.Join(root, "db", "migrations"))
+	if err != nil {
+		return nil, err
+	}
+
+	migration := fmt.Sprintf("%04d_create_%s", version, resource.Table)
+	files := []struct {
+		template string
+		path     string
+	}{
+		{"model.go.tmpl", filepath.Join("models", resource.Table+".go")},
+		{"controller.go.tmpl", filepath.Join("controllers", "api", resource.Table+".go")},
+		{"controller_test.go.tmpl", filepath.Join("controllers", "api", resource.Table+"_test.go")},
+		{"migration.up.sql.tmpl", filepath.Join("db", "migrations", migration+".up.sql")},
+		{"migration.down.sql.tmpl", filepath.Join("db", "migrations", migration+".down.sql")},
+	}
+
+	for _, file := range files {
+		if _, err := os.Stat(filepath.Join(root, file.path)); err == nil {
+			return nil, fmt.Errorf("%s already exists", file.path)
+		}
+	}
+
+	var written []string
+	for _, file := range files {
+		var buffer bytes.Buffer
+		if err := templates.ExecuteTemplate(&buffer, file.template, resource); err != nil {
+			return written, err
+		}
+		content := buffer.Bytes()
+		if strings.HasSuffix(file.path, ".go") {
+			if content, err = formatSource(content); err != nil {
+				return written, fmt.Errorf("Generated %s is not valid Go: %v", file.path, err)
+			}
+		}
+		if err := os.WriteFile(filepath.Join(root, file.path), content, 0644); err != nil {
+			return written, err
+		}
+		written = append(written, file.path)
+	}
+
+	if err := registerUrl(root, resource); err != nil {
+		return written, err
+	}
+	written = append(written, filepath.Join("system", "core.go"))
+
+	if err := registerRoutes(root, resource); err != nil {
+		return written, err
+	}
+	return append(written, "server.go"), nil
+}
+
+// registerUrl adds the url constant of resource next to ResourcesUrl
+func registerUrl(root string, resource *Resource) error {
+	anchor := "\tResourcesUrl = \"/resources\"\n"
+	line := fmt.Sprintf("\t%s = \"/%s\"\n", resource.UrlConst, strings.ReplaceAll(resource.Table, "_", "-"))
+	return insertAfter(filepath.Join(root, "system", "core.go"), anchor, line)
+}
+
+// registerRoutes sets the store of resource and adds its routes in
+// server.go. Generated models only have SQL queries, so the resource isn't
+// served, with a warning, on other storage backends.
+func registerRoutes(root string, resource *Resource) error {
+	url := "system." + resource.UrlConst
+	item := url + "+\"/{" + resource.Var + "Id}\""
+	routes := fmt.Sprintf(
+		"\tif %[5]s, ok := store.(models.%[4]sStore); ok {\n"+
+			"\t\tapi.Set%[4]sStore(%[5]s)\n"+
+			"\t\tr.HandleFunc(%[1]s, api.Get%[3]s).Methods(\"GET\")\n"+
+			"\t\tr.HandleFunc(%[1]s, api.Add%[4]s).Methods(\"POST\")\n"+
+			"\t\tr.HandleFunc(%[1]s, api.%[4]sOptions).Methods(\"OPTIONS\")\n"+
+			"\t\tr.HandleFunc(%[1]s+\"/$schema\", api.%[4]sSchema).Methods(\"GET\")\n"+
+			"\t\tr.HandleFunc(%[2]s, api.Get%[4]s).Methods(\"GET\")\n"+
+			"\t\tr.HandleFunc(%[2]s, api.Update%[4]s).Methods(\"PUT\")\n"+
+			"\t\tr.HandleFunc(%[2]s, api.Patch%[4]s).Methods(\"PATCH\")\n"+
+			"\t\tr.HandleFunc(%[2]s, api.Delete%[4]s).Methods(\"DELETE\")\n"+
+			"\t\tr.HandleFunc(%[2]s, api.%[4]sOptions).Methods(\"OPTIONS\")\n"+
+			"\t} else {\n"+
+			"\t\tlog.Println(\"Warning: %[6]s are not served, they need a SQL database\")\n"+
+			"\t}\n",
+		url, item, resource.Plural, resource.Name, resource.VarPlural, resource.Table)
+	return insertBefore(filepath.Join(root, "server.go"), "\thttp.Handle(\"/\", r)\n", routes)
+}
+
+func insertAfter(path, anchor, text string) error {
+	return edit(path, anchor, anchor+text)
+}
+
+func insertBefore(path, anchor, text string) error {
+	return edit(path, anchor, text+anchor)
+}
+
+// edit replaces the first occurrence of anchor in the Go file at path and
+// reformats it
+func edit(path, anchor, replacement string) error {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	if !bytes.Contains(content, []byte(anchor)) {
+		return fmt.Errorf("Can't find %q in %s", strings.TrimSpace(anchor), path)
+	}
+
+	content = bytes.Replace(content, []byte(anchor), []byte(replacement), 1)
+	if content, err = formatSource(content); err != nil {
+		return err
+	}
+	return os.WriteFile(path, content, 0644)
+}
+
+// formatSource runs gofmt on src keeping the license header as it is, since
+// gofmt would reindent its numbered list
+func formatSource(src []byte) ([]byte, error) {
+	formatted, err := format.Source(src)
+	if err != nil {
+		return nil, err
+	}
+
+	header := bytes.Index(src, []byte("\npackage "))
+	formattedHeader := bytes.Index(formatted, []byte("\npackage "))
+	if header < 0 || formattedHeader < 0 {
+		return formatted, nil
+	}
+	return append(src[:header:header], formatted[formattedHeader:]...), nil
+}
+
+// nextMigration returns the version for a new migration in dir
+func nextMigration(dir string) (int, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return 0, err
+	}
+
+	var versions []int
+	for _, entry := range entries {
+		prefix := strings.SplitN(entry.Name(), "_", 2)[0]
+		if version, err := strconv.Atoi(prefix); err == nil {
+			versions = append(versions, version)
+		}
+	}
+	if len(versions) == 0 {
+		return 1, nil
+	}
+	sort.Ints(versions)
+	return versions[len(versions)-1] + 1, nil
+}
+
+// camel turns snake_case or camelCase names into CamelCase, or camelCase if
+// upper is false
+func camel(name string, upper bool) string {
+	var b strings.Builder
+	nextUpper := upper
+	for i, c := range name {
+		switch {
+		case c == '_':
+			nextUpper = true
+		case nextUpper:
+			b.WriteRune(unicode.ToUpper(c))
+			nextUpper = false
+		case i == 0 && !upper:
+			b.WriteRune(unicode.ToLower(c))
+		default:
+			b.WriteRune(c)
+		}
+	}
+	return b.String()
+}
+
+// snake turns CamelCase names into snake_case
+func snake(name string) string {
+	var b strings.Builder
+	for i, c := range name {
+		if unicode.IsUpper(c) {
+			if i > 0 && name[i-1] != '_' {
+				b.WriteRune('_')
+			}
+			c = unicode.ToLower(c)
+		}
+		b.WriteRune(c)
+	}
+	return b.String()
+}
+
+func pluralize(name string) string {
+	switch {
+	case strings.HasSuffix(name, "y") && len(name) > 1 && !strings.ContainsRune("aeiou", rune(name[len(name)-2])):
+		return name[:len(name)-1] + "ies"
+	case strings.HasSuffix(name, "s"), strings.HasSuffix(name, "x"), strings.HasSuffix(name, "ch"), strings.HasSuffix(name, "sh"):
+		return name + "es"
+	}
+	return name + "s"
+}
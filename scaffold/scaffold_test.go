@@ -0,0 +1,222 @@
+/*
+Copyright (c) 2015, Alberto Corsín Lafuente
+All rights reserved.
+
+Redistribution and use in source and binary forms, with or without
+modification, are permitted provided that the following conditions are met:
+
+1. Redistributions of source code must retain the above copyright notice,
+   this list of conditions and the following disclaimer.
+
+2. Redistributions in binary form must reproduce the above copyright notice,
+   this list of conditions and the following disclaimer in the documentation
+   and/or other materials provided with the distribution.
+
+3. Neither the name of the copyright holder nor the names of its contributors
+   may be used to endorse or promote products derived from this software
+   without specific prior written permission.
+
+THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND
+ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED
+WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE
+FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER
+CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY,
+OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+*/
+package scaffold
+
+import (
+	"bytes"
+	"flag"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+var update = flag.Bool("update", false, "rewrite the golden files in testdata")
+
+var widgetFields = []string{"name:string", "notes:text", "price:float", "count:int", "total:int64", "active:bool", "releasedAt:time"}
+
+// copyTree copies the files under src to dst, leaving out .git
+func copyTree(t *testing.T, src, dst string) {
+	err := filepath.Walk(src, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		relative, err := filepath.Rel(src, path)
+		if err != nil {
+			return err
+		}
+		if info.IsDir() {
+			if info.Name() == ".git" {
+				return filepath.SkipDir
+			}
+			return os.MkdirAll(filepath.Join(dst, relative), 0755)
+		}
+
+		in, err := os.Open(path)
+		if err != nil {
+			return err
+		}
+		defer in.Close()
+		out, err := os.Create(filepath.Join(dst, relative))
+		if err != nil {
+			return err
+		}
+		defer out.Close()
+		_, err = io.Copy(out, in)
+		return err
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestGenerateGolden(t *testing.T) {
+	root := t.TempDir()
+	for _, dir := range []string{"db", "system", "models", "controllers/api"} {
+		if err := os.MkdirAll(filepath.Join(root, dir), 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	copyTree(t, filepath.Join("..", "db", "migrations"), filepath.Join(root, "db", "migrations"))
+	for _, file := range []string{"server.go", filepath.Join("system", "core.go")} {
+		content, err := os.ReadFile(filepath.Join("..", file))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err = os.WriteFile(filepath.Join(root, file), content, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	resource, err := NewResource("Widget", widgetFields)
+	if err != nil {
+		t.Fatal(err)
+	}
+	written, err := Generate(root, resource)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	golden := map[string]string{
+		filepath.Join("models", "widgets.go"):                             "model.go.golden",
+		filepath.Join("controllers", "api", "widgets.go"):                 "controller.go.golden",
+		filepath.Join("controllers", "api", "widgets_test.go"):            "controller_test.go.golden",
+		filepath.Join("db", "migrations", "0004_create_widgets.up.sql"):   "migration.up.sql.golden",
+		filepath.Join("db", "migrations", "0004_create_widgets.down.sql"): "migration.down.sql.golden",
+	}
+	if len(written) != len(golden)+2 {
+		t.Errorf("unexpected files written %v", written)
+	}
+	for path, name := range golden {
+		content, err := os.ReadFile(filepath.Join(root, path))
+		if err != nil {
+			t.Fatal(err)
+		}
+		goldenPath := filepath.Join("testdata", name)
+		if *update {
+			if err = os.WriteFile(goldenPath, content, 0644); err != nil {
+				t.Fatal(err)
+			}
+			continue
+		}
+		expected, err := os.ReadFile(goldenPath)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(content, expected) {
+			t.Errorf("%s doesn't match %s, run go test -update if the change is intended", path, goldenPath)
+		}
+	}
+
+	server, err := os.ReadFile(filepath.Join(root, "server.go"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, line := range []string{
+		"if widgets, ok := store.(models.WidgetStore); ok {",
+		"api.SetWidgetStore(widgets)",
+		`r.HandleFunc(system.WidgetsUrl+"/{widgetId}", api.PatchWidget).Methods("PATCH")`,
+		`log.Println("Warning: widgets are not served, they need a SQL database")`,
+	} {
+		if !bytes.Contains(server, []byte(line)) {
+			t.Errorf("server.go doesn't contain %s", line)
+		}
+	}
+	core, err := os.ReadFile(filepath.Join(root, "system", "core.go"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Contains(core, []byte(`WidgetsUrl   = "/widgets"`)) && !bytes.Contains(core, []byte(`WidgetsUrl = "/widgets"`)) {
+		t.Error("system/core.go doesn't define WidgetsUrl")
+	}
+
+	if _, err = Generate(root, resource); err == nil || !strings.Contains(err.Error(), "already exists") {
+		t.Errorf("expected an error generating twice, got %v", err)
+	}
+}
+
+func TestNewResourceErrors(t *testing.T) {
+	tests := []struct {
+		name   string
+		fields []string
+	}{
+		{"Resource", nil},
+		{"1Widget", nil},
+		{"Group", nil},
+		{"Widget", []string{"name"}},
+		{"Widget", []string{"name:blob"}},
+		{"Widget", []string{"name:string", "name:text"}},
+		{"Widget", []string{"href:string"}},
+		{"Widget", []string{"order:int"}},
+		{"Widget", []string{"bad-name:int"}},
+	}
+	for _, test := range tests {
+		if _, err := NewResource(test.name, test.fields); err == nil {
+			t.Errorf("%s %v: expected an error", test.name, test.fields)
+		}
+	}
+}
+
+// TestGeneratedCompiles generates a resource in a copy of the module and
+// builds, vets and tests it there
+func TestGeneratedCompiles(t *testing.T) {
+	if testing.Short() {
+		t.Skip("builds a copy of the module")
+	}
+	output, err := exec.Command("go", "env", "GOMOD").Output()
+	module := strings.TrimSpace(string(output))
+	if err != nil || module == "" || module == os.DevNull {
+		t.Skip("not built in module mode")
+	}
+
+	root := t.TempDir()
+	copyTree(t, filepath.Dir(module), root)
+	resource, err := NewResource("Widget", widgetFields)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = Generate(root, resource); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, args := range [][]string{
+		{"build", "./..."},
+		{"vet", "./..."},
+		{"test", "./controllers/api/", "-run", "Widget"},
+	} {
+		command := exec.Command("go", args...)
+		command.Dir = root
+		if output, err := command.CombinedOutput(); err != nil {
+			t.Fatalf("go %s: %v\n%s", strings.Join(args, " "), err, output)
+		}
+	}
+}
@@ -30,55 +30,210 @@ OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
 package main
 
 import (
+	"github.com/acorsinl/casimiro/auth"
 	"github.com/acorsinl/casimiro/controllers/api"
 	"github.com/acorsinl/casimiro/models"
 	"github.com/acorsinl/casimiro/system"
 	_ "github.com/go-sql-driver/mysql"
 	"github.com/gorilla/mux"
+	_ "github.com/lib/pq"
+	_ "github.com/mattn/go-sqlite3"
 	"log"
 	"net/http"
 	"os"
+	"strings"
+	"time"
 )
 
 const (
-	ListenPort = "PORT"
-	DbUri      = "DB_URI"
+	ListenPort   = "PORT"
+	DbUri        = "DB_URI"
+	AutoMigrate  = "AUTO_MIGRATE"
+	CursorSecret = "CURSOR_SECRET"
+	// AuthMode selects how users are authenticated: header (the default)
+	// trusts UserHeader, jwt requires bearer tokens, local keeps users in
+	// the database and introspection checks opaque tokens with an OAuth2
+	// server
+	AuthMode     = "AUTH_MODE"
+	JWTKeys      = "JWT_JWKS_FILE"
+	JWTIssuer    = "JWT_ISSUER"
+	JWTAudience  = "JWT_AUDIENCE"
+	JWTUserClaim = "JWT_USER_CLAIM"
+	JWTLeeway    = "JWT_LEEWAY"
+	// TrustedProxies lists the CIDRs of the proxies in front of the server,
+	// UserHeaderSecret is the HMAC key they sign UserHeader with
+	TrustedProxies   = "TRUSTED_PROXIES"
+	UserHeaderSecret = "USER_HEADER_SECRET"
+	// LocalRegistration set to false closes the registration of local users
+	LocalRegistration = "LOCAL_REGISTRATION"
+	// OAuth2 token introspection endpoint and the credentials to call it
+	IntrospectionURL          = "INTROSPECTION_URL"
+	IntrospectionClientId     = "INTROSPECTION_CLIENT_ID"
+	IntrospectionClientSecret = "INTROSPECTION_CLIENT_SECRET"
 )
 
-//var db *sql.DB
-
 func main() {
 	listPort := os.Getenv(ListenPort)
 	dbUri := os.Getenv(DbUri)
 
+	if len(os.Args) > 1 && os.Args[1] == "generate" {
+		Generate(os.Args[2:])
+		return
+	}
+
+	if len(os.Args) > 1 && os.Args[1] == "migrate" {
+		if dbUri == "" {
+			log.Fatal("Required env vars not found")
+		}
+		Migrate(dbUri, os.Args[2:])
+		return
+	}
+
 	if listPort == "" || dbUri == "" {
 		log.Fatal("Required env vars not found")
 	}
 
-	model := models.Model{}
-	model.InitDB(dbUri)
+	if secret := os.Getenv(CursorSecret); secret != "" {
+		system.SetCursorSecret([]byte(secret))
+	}
+
+	proxies, err := auth.ParseProxies(os.Getenv(TrustedProxies))
+	if err != nil {
+		log.Fatal("Invalid " + TrustedProxies + ": " + err.Error())
+	}
+
+	store := models.NewResourceStore(dbUri)
+	// SQLite databases are local, so their schema is always kept up to date
+	if os.Getenv(AutoMigrate) == "true" || strings.HasPrefix(dbUri, "sqlite:") {
+		RunMigrations(store)
+	}
+	api.SetResourceStore(store)
+
+	// Requests to /auth are authenticated by their handlers, local users
+	// log in there
+	public := http.NewServeMux()
+	authenticator := NewAuthenticator(os.Getenv(AuthMode), proxies, store)
+	if local, ok := authenticator.(*auth.Local); ok {
+		api.SetLocalAuth(local)
+		a := mux.NewRouter()
+		if os.Getenv(LocalRegistration) != "false" {
+			a.HandleFunc(system.AuthUrl+"/register", api.Register).Methods("POST")
+		}
+		a.HandleFunc(system.AuthUrl+"/login", api.Login).Methods("POST")
+		a.HandleFunc(system.AuthUrl+"/refresh", api.Refresh).Methods("POST")
+		a.HandleFunc(system.AuthUrl+"/logout", api.Logout).Methods("POST")
+		a.PathPrefix(system.AuthUrl).HandlerFunc(api.AuthOptions).Methods("OPTIONS")
+		public.Handle(system.AuthUrl+"/", a)
+	}
+	if keys, ok := store.(models.APIKeyStore); ok {
+		api.SetAPIKeyStore(keys)
+		authenticator = &auth.APIKeys{Keys: keys, Fallback: authenticator}
+	}
 
 	r := mux.NewRouter()
 	r.HandleFunc(system.ResourcesUrl, api.GetResources).Methods("GET")
 	r.HandleFunc(system.ResourcesUrl, api.AddResource).Methods("POST")
+	r.HandleFunc(system.ResourcesUrl+"/$aggregate", api.AggregateResources).Methods("GET")
+	r.HandleFunc(system.ResourcesUrl+"/$schema", api.ResourceSchema).Methods("GET")
 	r.HandleFunc(system.ResourcesUrl+"/{resourceId}", api.ResourceOptions).Methods("OPTIONS")
 	r.HandleFunc(system.ResourcesUrl+"/{resourceId}", api.GetResource).Methods("GET")
 	r.HandleFunc(system.ResourcesUrl+"/{resourceId}", api.UpdateResource).Methods("PUT")
 	r.HandleFunc(system.ResourcesUrl+"/{resourceId}", api.PatchResource).Methods("PATCH")
 	r.HandleFunc(system.ResourcesUrl+"/{resourceId}", api.DeleteResource).Methods("DELETE")
 	r.HandleFunc(system.ResourcesUrl+"/{resourceId}", api.ResourceOptions).Methods("OPTIONS")
+	r.HandleFunc(system.APIKeysUrl, api.GetAPIKeys).Methods("GET")
+	r.HandleFunc(system.APIKeysUrl, api.AddAPIKey).Methods("POST")
+	r.HandleFunc(system.APIKeysUrl, api.APIKeyOptions).Methods("OPTIONS")
+	r.HandleFunc(system.APIKeysUrl+"/{keyId}", api.GetAPIKey).Methods("GET")
+	r.HandleFunc(system.APIKeysUrl+"/{keyId}", api.RevokeAPIKey).Methods("DELETE")
+	r.HandleFunc(system.APIKeysUrl+"/{keyId}", api.APIKeyOptions).Methods("OPTIONS")
+	r.HandleFunc(system.APIKeysUrl+"/{keyId}/rotate", api.RotateAPIKey).Methods("POST")
+	r.HandleFunc(system.APIKeysUrl+"/{keyId}/rotate", api.APIKeyOptions).Methods("OPTIONS")
 	http.Handle("/", r)
+	auth.SetScopeResources(routeResources(r))
 
 	log.Println("Server listening on port " + listPort)
-	log.Fatal(http.ListenAndServe(":"+listPort, Log(http.DefaultServeMux)))
+	public.Handle("/", auth.Handler(authenticator, http.DefaultServeMux))
+	log.Fatal(http.ListenAndServe(":"+listPort, Log(proxies, public)))
+}
+
+// routeResources returns the first segments of the paths served by r, the
+// resources API keys can be given scopes of. API keys can't be used on
+// APIKeysUrl, so it isn't one of them.
+func routeResources(r *mux.Router) []string {
+	var resources []string
+	seen := map[string]bool{strings.TrimPrefix(system.APIKeysUrl, "/"): true}
+	r.Walk(func(route *mux.Route, router *mux.Router, ancestors []*mux.Route) error {
+		template, err := route.GetPathTemplate()
+		if err != nil {
+			return nil
+		}
+		resource := strings.SplitN(strings.TrimPrefix(template, "/"), "/", 2)[0]
+		if resource != "" && !seen[resource] {
+			seen[resource] = true
+			resources = append(resources, resource)
+		}
+		return nil
+	})
+	return resources
+}
+
+// NewAuthenticator returns the authenticator of mode, configured from the
+// environment
+func NewAuthenticator(mode string, proxies auth.Proxies, store models.ResourceStore) auth.Authenticator {
+	switch mode {
+	case "", "header":
+		secret := os.Getenv(UserHeaderSecret)
+		if len(proxies) == 0 && secret == "" {
+			log.Println("Warning: " + system.UserHeader + " is trusted from any client, set " + TrustedProxies + " or " + UserHeaderSecret)
+		}
+		return &auth.Header{Proxies: proxies, Secret: []byte(secret)}
+	case "jwt":
+		keys, err := auth.LoadKeys(os.Getenv(JWTKeys))
+		if err != nil {
+			log.Fatal("Can't load JWT keys: ", err)
+		}
+		var leeway time.Duration
+		if value := os.Getenv(JWTLeeway); value != "" {
+			if leeway, err = time.ParseDuration(value); err != nil {
+				log.Fatal("Invalid " + JWTLeeway)
+			}
+		}
+		return &auth.JWT{
+			Keys:      keys,
+			Issuer:    os.Getenv(JWTIssuer),
+			Audience:  os.Getenv(JWTAudience),
+			UserClaim: os.Getenv(JWTUserClaim),
+			Leeway:    leeway,
+		}
+	case "local":
+		users, ok := store.(models.UserStore)
+		if !ok {
+			log.Fatal("The storage backend doesn't support local users")
+		}
+		return auth.NewLocal(users)
+	case "introspection":
+		endpoint := os.Getenv(IntrospectionURL)
+		if endpoint == "" {
+			log.Fatal(IntrospectionURL + " is required")
+		}
+		return auth.NewIntrospection(endpoint, os.Getenv(IntrospectionClientId), os.Getenv(IntrospectionClientSecret))
+	}
+	log.Fatal("Unknown " + AuthMode + " " + mode)
+	return nil
 }
 
-func Log(handler http.Handler) http.Handler {
+// Log logs every request with the address of its client, found through the
+// trusted proxies, and the user the authentication middleware found, empty
+// when it wasn't authenticated. Requests are logged once served, so their
+// user is known.
+func Log(proxies auth.Proxies, handler http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		w.Header().Set("Access-Control-Allow-Methods", "POST, GET, OPTIONS, PUT, DELETE")
 		w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Content-Length, Accept-Encoding")
 		w.Header().Set("Access-Control-Allow-Origin", "*")
-		log.Printf("REQ - %v - %v - %v - %v", r.RemoteAddr, r.Method, r.URL, r.Header.Get("gs-user"))
+		r, userId := system.RecordUserId(r)
 		handler.ServeHTTP(w, r)
+		log.Printf("REQ - %v - %v - %v - %v", proxies.ClientAddress(r), r.Method, r.URL, userId())
 	})
 }
@@ -0,0 +1,90 @@
+/*
+Copyright (c) 2015, Alberto Corsín Lafuente
+All rights reserved.
+
+Redistribution and use in source and binary forms, with or without
+modification, are permitted provided that the following conditions are met:
+
+1. Redistributions of source code must retain the above copyright notice,
+   this list of conditions and the following disclaimer.
+
+2. Redistributions in binary form must reproduce the above copyright notice,
+   this list of conditions and the following disclaimer in the documentation
+   and/or other materials provided with the distribution.
+
+3. Neither the name of the copyright holder nor the names of its contributors
+   may be used to endorse or promote products derived from this software
+   without specific prior written permission.
+
+THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND
+ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED
+WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE
+FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER
+CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY,
+OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+*/
+package system
+
+import (
+	"errors"
+	"regexp"
+	"strings"
+)
+
+var ErrInvalidAggregate = errors.New("$aggregate must be a list of count, sum(field), min(field), max(field) or avg(field), each optionally followed by as alias")
+
+// Aggregate is one of the values computed by an $aggregate parameter,
+// Field is empty for count
+type Aggregate struct {
+	Function string
+	Field    string
+	Alias    string
+}
+
+var aggregatePattern = regexp.MustCompile(`^(count|sum|min|max|avg)(?:\(\s*(\w+)\s*\))?(?:\s+as\s+(\w+))?$`)
+
+// ParseAggregates parses an $aggregate parameter such as
+// "count,max(updatedAt) as newest". sum and avg only work with number
+// fields, min and max with any but bool ones. Values are named after their
+// alias, or the function and field ("maxUpdatedAt") when there is none.
+func ParseAggregates(value string, fields map[string]FilterType) ([]Aggregate, error) {
+	var aggregates []Aggregate
+	aliases := make(map[string]bool)
+
+	for _, item := range strings.Split(value, ",") {
+		match := aggregatePattern.FindStringSubmatch(strings.TrimSpace(item))
+		if match == nil || (match[1] == "count") != (match[2] == "") {
+			return nil, ErrInvalidAggregate
+		}
+
+		aggregate := Aggregate{Function: match[1], Field: match[2], Alias: match[3]}
+		if aggregate.Field != "" {
+			fieldType, ok := fields[aggregate.Field]
+			if !ok {
+				return nil, &FieldListError{"$aggregate", aggregate.Field}
+			}
+			if (aggregate.Function == "sum" || aggregate.Function == "avg") && fieldType != FilterNumber {
+				return nil, errors.New("Can't " + aggregate.Function + " " + aggregate.Field + ", sum and avg only work with number fields")
+			}
+			if fieldType == FilterBool {
+				return nil, errors.New("Can't " + aggregate.Function + " " + aggregate.Field + ", min and max don't work with bool fields")
+			}
+		}
+		if aggregate.Alias == "" {
+			aggregate.Alias = aggregate.Function
+			if aggregate.Field != "" {
+				aggregate.Alias += strings.ToUpper(aggregate.Field[:1]) + aggregate.Field[1:]
+			}
+		}
+		if aliases[aggregate.Alias] {
+			return nil, errors.New("Duplicate $aggregate alias " + aggregate.Alias)
+		}
+		aliases[aggregate.Alias] = true
+		aggregates = append(aggregates, aggregate)
+	}
+	return aggregates, nil
+}
@@ -0,0 +1,75 @@
+/*
+Copyright (c) 2015, Alberto Corsín Lafuente
+All rights reserved.
+
+Redistribution and use in source and binary forms, with or without
+modification, are permitted provided that the following conditions are met:
+
+1. Redistributions of source code must retain the above copyright notice,
+   this list of conditions and the following disclaimer.
+
+2. Redistributions in binary form must reproduce the above copyright notice,
+   this list of conditions and the following disclaimer in the documentation
+   and/or other materials provided with the distribution.
+
+3. Neither the name of the copyright holder nor the names of its contributors
+   may be used to endorse or promote products derived from this software
+   without specific prior written permission.
+
+THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND
+ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED
+WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE
+FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER
+CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY,
+OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+*/
+package system
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestParseAggregates(t *testing.T) {
+	fields := map[string]FilterType{
+		"createdAt": FilterTime,
+		"price":     FilterNumber,
+		"active":    FilterBool,
+	}
+
+	aggregates, err := ParseAggregates("count, sum(price) as total, max(createdAt), avg(price)", fields)
+	if err != nil {
+		t.Fatal(err)
+	}
+	aliases := []string{"count", "total", "maxCreatedAt", "avgPrice"}
+	if len(aggregates) != len(aliases) {
+		t.Fatalf("expected %d aggregates, got %v", len(aliases), aggregates)
+	}
+	for i, alias := range aliases {
+		if aggregates[i].Alias != alias {
+			t.Errorf("expected alias %s, got %s", alias, aggregates[i].Alias)
+		}
+	}
+
+	tests := []struct {
+		value   string
+		message string
+	}{
+		{"sum(createdAt)", "only work with number fields"},
+		{"avg(createdAt)", "only work with number fields"},
+		{"max(active)", "don't work with bool fields"},
+		{"sum(missing)", "missing"},
+		{"count(price)", "must be a list"},
+		{"count,count", "Duplicate"},
+	}
+	for _, test := range tests {
+		_, err := ParseAggregates(test.value, fields)
+		if err == nil || !strings.Contains(err.Error(), test.message) {
+			t.Errorf("%s: expected an error with %q, got %v", test.value, test.message, err)
+		}
+	}
+}
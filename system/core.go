@@ -33,7 +33,14 @@ import ()
 
 const (
 	ResourcesUrl = "/resources"
+	APIKeysUrl   = "/apikeys"
+	AuthUrl      = "/auth"
 	UserHeader   = "gs-user"
 	PagingOffset = 0
 	PagingLimit  = 10
+	// PagingMaxLimit is the largest page size a client can ask for
+	PagingMaxLimit = 100
+	// UserSignatureHeader holds the HMAC signature of UserHeader made by the
+	// proxy, see auth.Header
+	UserSignatureHeader = "gs-user-signature"
 )
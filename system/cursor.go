@@ -0,0 +1,117 @@
+/*
+Copyright (c) 2015, Alberto Corsín Lafuente
+All rights reserved.
+
+Redistribution and use in source and binary forms, with or without
+modification, are permitted provided that the following conditions are met:
+
+1. Redistributions of source code must retain the above copyright notice,
+   this list of conditions and the following disclaimer.
+
+2. Redistributions in binary form must reproduce the above copyright notice,
+   this list of conditions and the following disclaimer in the documentation
+   and/or other materials provided with the distribution.
+
+3. Neither the name of the copyright holder nor the names of its contributors
+   may be used to endorse or promote products derived from this software
+   without specific prior written permission.
+
+THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND
+ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED
+WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE
+FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER
+CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY,
+OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+*/
+package system
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var ErrInvalidCursor = errors.New("Invalid cursor")
+
+// cursorSecret signs paging cursors so clients can't forge positions. A
+// random one is used until SetCursorSecret is called, which makes cursors
+// only valid for the running process.
+var cursorSecret = randomSecret()
+
+// CursorMaxAge is how long cursors are accepted after being made, so old
+// positions can't be replayed forever
+var CursorMaxAge = 24 * time.Hour
+
+// SetCursorSecret sets the key used to sign paging cursors, it must be the
+// same in every instance serving the API
+func SetCursorSecret(secret []byte) {
+	cursorSecret = secret
+}
+
+// EncodeCursor returns an opaque, signed token holding position and the
+// time it was made
+func EncodeCursor(position interface{}) (string, error) {
+	payload, err := json.Marshal(position)
+	if err != nil {
+		return "", err
+	}
+
+	return signedCursor(base64.RawURLEncoding.EncodeToString(payload), time.Now()), nil
+}
+
+// DecodeCursor checks the signature and age of a token created by
+// EncodeCursor and decodes its position into destination
+func DecodeCursor(token string, destination interface{}) error {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return ErrInvalidCursor
+	}
+
+	signature, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil || !hmac.Equal(signature, signCursor(parts[0]+"."+parts[1])) {
+		return ErrInvalidCursor
+	}
+	issued, err := strconv.ParseInt(parts[1], 10, 64)
+	if err != nil || time.Since(time.Unix(issued, 0)) > CursorMaxAge {
+		return ErrInvalidCursor
+	}
+
+	payload, err := base64.RawURLEncoding.DecodeString(parts[0])
+	if err != nil {
+		return ErrInvalidCursor
+	}
+	if err = json.Unmarshal(payload, destination); err != nil {
+		return ErrInvalidCursor
+	}
+	return nil
+}
+
+// signedCursor returns the token for the encoded position made at issued
+func signedCursor(encoded string, issued time.Time) string {
+	signed := encoded + "." + strconv.FormatInt(issued.Unix(), 10)
+	return signed + "." + base64.RawURLEncoding.EncodeToString(signCursor(signed))
+}
+
+func signCursor(encoded string) []byte {
+	mac := hmac.New(sha256.New, cursorSecret)
+	mac.Write([]byte(encoded))
+	return mac.Sum(nil)
+}
+
+func randomSecret() []byte {
+	secret := make([]byte, 32)
+	if _, err := rand.Read(secret); err != nil {
+		panic(err)
+	}
+	return secret
+}
@@ -0,0 +1,111 @@
+/*
+Copyright (c) 2015, Alberto Corsín Lafuente
+All rights reserved.
+
+Redistribution and use in source and binary forms, with or without
+modification, are permitted provided that the following conditions are met:
+
+1. Redistributions of source code must retain the above copyright notice,
+   this list of conditions and the following disclaimer.
+
+2. Redistributions in binary form must reproduce the above copyright notice,
+   this list of conditions and the following disclaimer in the documentation
+   and/or other materials provided with the distribution.
+
+3. Neither the name of the copyright holder nor the names of its contributors
+   may be used to endorse or promote products derived from this software
+   without specific prior written permission.
+
+THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND
+ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED
+WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE
+FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER
+CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY,
+OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+*/
+package system
+
+import (
+	"encoding/base64"
+	"strings"
+	"testing"
+	"time"
+)
+
+type testPosition struct {
+	Id   string `json:"id"`
+	Size int    `json:"size"`
+}
+
+func TestCursorRoundTrip(t *testing.T) {
+	token, err := EncodeCursor(testPosition{"r1", 3})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var position testPosition
+	if err = DecodeCursor(token, &position); err != nil {
+		t.Fatal(err)
+	}
+	if position != (testPosition{"r1", 3}) {
+		t.Fatalf("unexpected position %+v", position)
+	}
+}
+
+func TestTamperedCursors(t *testing.T) {
+	token, err := EncodeCursor(testPosition{"r1", 3})
+	if err != nil {
+		t.Fatal(err)
+	}
+	parts := strings.Split(token, ".")
+	forged := base64.RawURLEncoding.EncodeToString([]byte(`{"id":"r9","size":3}`))
+
+	tokens := map[string]string{
+		"empty":             "",
+		"unsigned":          parts[0],
+		"extra part":        token + ".x",
+		"forged position":   forged + "." + parts[1] + "." + parts[2],
+		"changed issue":     parts[0] + "." + "1" + parts[1] + "." + parts[2],
+		"changed signature": parts[0] + "." + parts[1] + "." + base64.RawURLEncoding.EncodeToString([]byte("signature")),
+		"invalid signature": parts[0] + "." + parts[1] + ".%%",
+		"invalid issue":     signedCursorAt(parts[0], "soon"),
+		"invalid position":  signedCursorAt(base64.RawURLEncoding.EncodeToString([]byte("{")), parts[1]),
+	}
+	for name, token := range tokens {
+		var position testPosition
+		if err := DecodeCursor(token, &position); err != ErrInvalidCursor {
+			t.Errorf("%s: expected ErrInvalidCursor, got %v", name, err)
+		}
+	}
+
+	// Cursors are no longer valid once the secret changes
+	defer SetCursorSecret(cursorSecret)
+	SetCursorSecret([]byte("another secret"))
+	var position testPosition
+	if err := DecodeCursor(token, &position); err != ErrInvalidCursor {
+		t.Errorf("expected ErrInvalidCursor with another secret, got %v", err)
+	}
+}
+
+func TestExpiredCursors(t *testing.T) {
+	encoded := base64.RawURLEncoding.EncodeToString([]byte(`{"id":"r1","size":3}`))
+
+	var position testPosition
+	recent := signedCursor(encoded, time.Now().Add(-CursorMaxAge+time.Minute))
+	if err := DecodeCursor(recent, &position); err != nil || position.Id != "r1" {
+		t.Fatalf("expected a cursor younger than CursorMaxAge to be valid, got %+v %v", position, err)
+	}
+	expired := signedCursor(encoded, time.Now().Add(-CursorMaxAge-time.Minute))
+	if err := DecodeCursor(expired, &position); err != ErrInvalidCursor {
+		t.Fatalf("expected an expired cursor to be rejected, got %v", err)
+	}
+}
+
+// signedCursorAt signs a cursor with any issue time, valid or not
+func signedCursorAt(encoded, issued string) string {
+	signed := encoded + "." + issued
+	return signed + "." + base64.RawURLEncoding.EncodeToString(signCursor(signed))
+}
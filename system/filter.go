@@ -0,0 +1,434 @@
+/*
+Copyright (c) 2015, Alberto Corsín Lafuente
+All rights reserved.
+
+Redistribution and use in source and binary forms, with or without
+modification, are permitted provided that the following conditions are met:
+
+1. Redistributions of source code must retain the above copyright notice,
+   this list of conditions and the following disclaimer.
+
+2. Redistributions in binary form must reproduce the above copyright notice,
+   this list of conditions and the following disclaimer in the documentation
+   and/or other materials provided with the distribution.
+
+3. Neither the name of the copyright holder nor the names of its contributors
+   may be used to endorse or promote products derived from this software
+   without specific prior written permission.
+
+THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND
+ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED
+WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE
+FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER
+CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY,
+OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+*/
+package system
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+)
+
+// FilterType is the type of a filterable field, literals compared with the
+// field must be of the same type
+type FilterType int
+
+const (
+	FilterString FilterType = iota
+	FilterNumber
+	FilterBool
+	FilterTime
+)
+
+// Filter is a parsed $filter expression, one of FilterAnd, FilterOr,
+// FilterNot, FilterCompare, FilterIn or FilterFunction
+type Filter interface {
+	filter()
+}
+
+type FilterAnd struct {
+	Left, Right Filter
+}
+
+type FilterOr struct {
+	Left, Right Filter
+}
+
+type FilterNot struct {
+	Filter Filter
+}
+
+// FilterCompare compares a field with a value using one of the eq, ne, gt,
+// lt, ge and le operators. Value is nil when compared with null.
+type FilterCompare struct {
+	Field    string
+	Operator string
+	Value    interface{}
+}
+
+type FilterIn struct {
+	Field  string
+	Values []interface{}
+}
+
+// FilterFunction is a contains or startswith call on a string field
+type FilterFunction struct {
+	Function string
+	Field    string
+	Value    string
+}
+
+func (FilterAnd) filter()      {}
+func (FilterOr) filter()       {}
+func (FilterNot) filter()      {}
+func (FilterCompare) filter()  {}
+func (FilterIn) filter()       {}
+func (FilterFunction) filter() {}
+
+// FilterMaxDepth is how deeply parentheses and not can be nested in a
+// $filter expression, so clients can't make the parser or the queries built
+// from it recurse without end
+const FilterMaxDepth = 32
+
+// FilterError is returned for invalid $filter expressions
+type FilterError struct {
+	Position int
+	Message  string
+}
+
+func (e *FilterError) Error() string {
+	return fmt.Sprintf("Invalid $filter at position %d: %s", e.Position+1, e.Message)
+}
+
+// ParseFilter parses an OData style $filter expression such as
+//
+//	name eq 'casimiro' and (size gt 10 or not startswith(name, 'c'))
+//
+// Only the fields given can be used, and literals must match their type.
+// Strings are quoted with single quotes, doubled to escape them, and times
+// are quoted RFC 3339 strings.
+func ParseFilter(expression string, fields map[string]FilterType) (Filter, error) {
+	tokens, err := tokenizeFilter(expression)
+	if err != nil {
+		return nil, err
+	}
+
+	p := &filterParser{tokens: tokens, fields: fields, end: len(expression)}
+	filter, err := p.parseOr()
+	if err != nil {
+		return nil, err
+	}
+	if p.position < len(p.tokens) {
+		return nil, p.errorf("unexpected %q", p.tokens[p.position].text)
+	}
+	return filter, nil
+}
+
+const (
+	tokenWord = iota
+	tokenString
+	tokenNumber
+	tokenSymbol
+)
+
+type filterToken struct {
+	kind     int
+	text     string
+	position int
+}
+
+func tokenizeFilter(expression string) ([]filterToken, error) {
+	var tokens []filterToken
+	runes := []rune(expression)
+
+	for i := 0; i < len(runes); {
+		c := runes[i]
+		switch {
+		case unicode.IsSpace(c):
+			i++
+		case c == '(' || c == ')' || c == ',':
+			tokens = append(tokens, filterToken{tokenSymbol, string(c), i})
+			i++
+		case c == '\'':
+			start := i
+			var value strings.Builder
+			for i++; ; i++ {
+				if i >= len(runes) {
+					return nil, &FilterError{start, "unterminated string"}
+				}
+				if runes[i] == '\'' {
+					if i+1 < len(runes) && runes[i+1] == '\'' {
+						value.WriteRune('\'')
+						i++
+						continue
+					}
+					i++
+					break
+				}
+				value.WriteRune(runes[i])
+			}
+			tokens = append(tokens, filterToken{tokenString, value.String(), start})
+		case c == '-' || c == '.' || unicode.IsDigit(c):
+			start := i
+			for i++; i < len(runes) && (runes[i] == '.' || runes[i] == 'e' || runes[i] == 'E' || unicode.IsDigit(runes[i])); i++ {
+			}
+			tokens = append(tokens, filterToken{tokenNumber, string(runes[start:i]), start})
+		case unicode.IsLetter(c) || c == '_':
+			start := i
+			for i++; i < len(runes) && (unicode.IsLetter(runes[i]) || unicode.IsDigit(runes[i]) || runes[i] == '_'); i++ {
+			}
+			tokens = append(tokens, filterToken{tokenWord, string(runes[start:i]), start})
+		default:
+			return nil, &FilterError{i, fmt.Sprintf("unexpected character %q", c)}
+		}
+	}
+	return tokens, nil
+}
+
+type filterParser struct {
+	tokens   []filterToken
+	position int
+	fields   map[string]FilterType
+	end      int
+	depth    int
+}
+
+func (p *filterParser) errorf(format string, args ...interface{}) error {
+	position := p.end
+	if p.position < len(p.tokens) {
+		position = p.tokens[p.position].position
+	}
+	return &FilterError{position, fmt.Sprintf(format, args...)}
+}
+
+func (p *filterParser) peek() *filterToken {
+	if p.position < len(p.tokens) {
+		return &p.tokens[p.position]
+	}
+	return nil
+}
+
+// keyword consumes the next token if it is the given word or symbol
+func (p *filterParser) keyword(word string) bool {
+	token := p.peek()
+	if token != nil && (token.kind == tokenWord || token.kind == tokenSymbol) && token.text == word {
+		p.position++
+		return true
+	}
+	return false
+}
+
+func (p *filterParser) expect(symbol string) error {
+	if !p.keyword(symbol) {
+		return p.errorf("expected %q", symbol)
+	}
+	return nil
+}
+
+func (p *filterParser) parseOr() (Filter, error) {
+	left, err := p.parseAnd()
+	if err != nil {
+		return nil, err
+	}
+	for p.keyword("or") {
+		right, err := p.parseAnd()
+		if err != nil {
+			return nil, err
+		}
+		left = FilterOr{left, right}
+	}
+	return left, nil
+}
+
+func (p *filterParser) parseAnd() (Filter, error) {
+	left, err := p.parseUnary()
+	if err != nil {
+		return nil, err
+	}
+	for p.keyword("and") {
+		right, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		left = FilterAnd{left, right}
+	}
+	return left, nil
+}
+
+func (p *filterParser) parseUnary() (Filter, error) {
+	if p.keyword("not") {
+		if err := p.nest(); err != nil {
+			return nil, err
+		}
+		filter, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		p.depth--
+		return FilterNot{filter}, nil
+	}
+
+	if p.keyword("(") {
+		if err := p.nest(); err != nil {
+			return nil, err
+		}
+		filter, err := p.parseOr()
+		if err != nil {
+			return nil, err
+		}
+		p.depth--
+		return filter, p.expect(")")
+	}
+
+	token := p.peek()
+	if token == nil || token.kind != tokenWord {
+		return nil, p.errorf("expected a field or function")
+	}
+	if token.text == "contains" || token.text == "startswith" {
+		return p.parseFunction()
+	}
+	return p.parseComparison()
+}
+
+// nest enters a nested expression, failing past FilterMaxDepth
+func (p *filterParser) nest() error {
+	p.depth++
+	if p.depth > FilterMaxDepth {
+		return p.errorf("expressions can't be nested more than %d levels", FilterMaxDepth)
+	}
+	return nil
+}
+
+func (p *filterParser) parseFunction() (Filter, error) {
+	function := p.tokens[p.position].text
+	p.position++
+
+	if err := p.expect("("); err != nil {
+		return nil, err
+	}
+	field, fieldType, err := p.parseField()
+	if err != nil {
+		return nil, err
+	}
+	if fieldType != FilterString {
+		return nil, p.errorf("%s only works with string fields", function)
+	}
+	if err := p.expect(","); err != nil {
+		return nil, err
+	}
+	token := p.peek()
+	if token == nil || token.kind != tokenString {
+		return nil, p.errorf("expected a string")
+	}
+	p.position++
+	if err := p.expect(")"); err != nil {
+		return nil, err
+	}
+	return FilterFunction{Function: function, Field: field, Value: token.text}, nil
+}
+
+func (p *filterParser) parseComparison() (Filter, error) {
+	field, fieldType, err := p.parseField()
+	if err != nil {
+		return nil, err
+	}
+
+	if p.keyword("in") {
+		var values []interface{}
+		if err := p.expect("("); err != nil {
+			return nil, err
+		}
+		for {
+			value, err := p.parseLiteral(fieldType)
+			if err != nil {
+				return nil, err
+			}
+			if value == nil {
+				return nil, p.errorf("null can't be used with in")
+			}
+			values = append(values, value)
+			if !p.keyword(",") {
+				break
+			}
+		}
+		return FilterIn{Field: field, Values: values}, p.expect(")")
+	}
+
+	token := p.peek()
+	if token == nil || token.kind != tokenWord {
+		return nil, p.errorf("expected an operator")
+	}
+	operator := token.text
+	switch operator {
+	case "eq", "ne", "gt", "lt", "ge", "le":
+	default:
+		return nil, p.errorf("unknown operator %q", operator)
+	}
+	p.position++
+
+	value, err := p.parseLiteral(fieldType)
+	if err != nil {
+		return nil, err
+	}
+	if value == nil && operator != "eq" && operator != "ne" {
+		return nil, p.errorf("null can only be compared with eq or ne")
+	}
+	return FilterCompare{Field: field, Operator: operator, Value: value}, nil
+}
+
+func (p *filterParser) parseField() (string, FilterType, error) {
+	token := p.peek()
+	if token == nil || token.kind != tokenWord {
+		return "", 0, p.errorf("expected a field")
+	}
+	fieldType, ok := p.fields[token.text]
+	if !ok {
+		return "", 0, p.errorf("%s can't be used in filters", token.text)
+	}
+	p.position++
+	return token.text, fieldType, nil
+}
+
+// parseLiteral parses a literal of the given type, null is returned as nil
+func (p *filterParser) parseLiteral(fieldType FilterType) (interface{}, error) {
+	token := p.peek()
+	if token == nil {
+		return nil, p.errorf("expected a value")
+	}
+	if token.kind == tokenWord && token.text == "null" {
+		p.position++
+		return nil, nil
+	}
+
+	switch {
+	case fieldType == FilterString && token.kind == tokenString:
+		p.position++
+		return token.text, nil
+	case fieldType == FilterTime && token.kind == tokenString:
+		value, err := time.Parse(time.RFC3339Nano, token.text)
+		if err != nil {
+			return nil, p.errorf("expected an RFC 3339 time")
+		}
+		p.position++
+		return value.UTC(), nil
+	case fieldType == FilterNumber && token.kind == tokenNumber:
+		value, err := strconv.ParseFloat(token.text, 64)
+		if err != nil {
+			return nil, p.errorf("invalid number %q", token.text)
+		}
+		p.position++
+		return value, nil
+	case fieldType == FilterBool && token.kind == tokenWord && (token.text == "true" || token.text == "false"):
+		p.position++
+		return token.text == "true", nil
+	}
+	return nil, p.errorf("invalid value %q for the field type", token.text)
+}
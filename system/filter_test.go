@@ -0,0 +1,169 @@
+/*
+Copyright (c) 2015, Alberto Corsín Lafuente
+All rights reserved.
+
+Redistribution and use in source and binary forms, with or without
+modification, are permitted provided that the following conditions are met:
+
+1. Redistributions of source code must retain the above copyright notice,
+   this list of conditions and the following disclaimer.
+
+2. Redistributions in binary form must reproduce the above copyright notice,
+   this list of conditions and the following disclaimer in the documentation
+   and/or other materials provided with the distribution.
+
+3. Neither the name of the copyright holder nor the names of its contributors
+   may be used to endorse or promote products derived from this software
+   without specific prior written permission.
+
+THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND
+ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED
+WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE
+FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER
+CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY,
+OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+*/
+package system
+
+import (
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+var testFilterFields = map[string]FilterType{
+	"name":      FilterString,
+	"size":      FilterNumber,
+	"active":    FilterBool,
+	"createdAt": FilterTime,
+}
+
+func TestParseFilter(t *testing.T) {
+	createdAt := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+	tests := []struct {
+		expression string
+		filter     Filter
+	}{
+		{"name eq 'casimiro'", FilterCompare{"name", "eq", "casimiro"}},
+		{"name eq 'it''s'", FilterCompare{"name", "eq", "it's"}},
+		{"name eq ''", FilterCompare{"name", "eq", ""}},
+		{"name ne 'a and b'", FilterCompare{"name", "ne", "a and b"}},
+		{"size ge -1.5", FilterCompare{"size", "ge", -1.5}},
+		{"active eq true", FilterCompare{"active", "eq", true}},
+		{"createdAt lt '2024-01-02T04:04:05+01:00'", FilterCompare{"createdAt", "lt", createdAt}},
+		{"name eq null", FilterCompare{"name", "eq", nil}},
+		{"createdAt ne null", FilterCompare{"createdAt", "ne", nil}},
+		{"name in ('a', 'b''c')", FilterIn{"name", []interface{}{"a", "b'c"}}},
+		{"size in (1)", FilterIn{"size", []interface{}{1.0}}},
+		{"contains(name, 'x')", FilterFunction{"contains", "name", "x"}},
+		{"startswith(name, 'x')", FilterFunction{"startswith", "name", "x"}},
+		// and binds tighter than or, both associate to the left
+		{"size eq 1 or size eq 2 and size eq 3", FilterOr{
+			FilterCompare{"size", "eq", 1.0},
+			FilterAnd{FilterCompare{"size", "eq", 2.0}, FilterCompare{"size", "eq", 3.0}}}},
+		{"size eq 1 and size eq 2 or size eq 3", FilterOr{
+			FilterAnd{FilterCompare{"size", "eq", 1.0}, FilterCompare{"size", "eq", 2.0}},
+			FilterCompare{"size", "eq", 3.0}}},
+		{"size eq 1 or size eq 2 or size eq 3", FilterOr{
+			FilterOr{FilterCompare{"size", "eq", 1.0}, FilterCompare{"size", "eq", 2.0}},
+			FilterCompare{"size", "eq", 3.0}}},
+		{"(size eq 1 or size eq 2) and size eq 3", FilterAnd{
+			FilterOr{FilterCompare{"size", "eq", 1.0}, FilterCompare{"size", "eq", 2.0}},
+			FilterCompare{"size", "eq", 3.0}}},
+		// not only applies to the expression that follows
+		{"not active eq true and size eq 1", FilterAnd{
+			FilterNot{FilterCompare{"active", "eq", true}},
+			FilterCompare{"size", "eq", 1.0}}},
+		{"not (active eq true and size eq 1)", FilterNot{
+			FilterAnd{FilterCompare{"active", "eq", true}, FilterCompare{"size", "eq", 1.0}}}},
+		{"not not active eq false", FilterNot{FilterNot{FilterCompare{"active", "eq", false}}}},
+	}
+	for _, test := range tests {
+		filter, err := ParseFilter(test.expression, testFilterFields)
+		if err != nil {
+			t.Errorf("%s: %v", test.expression, err)
+			continue
+		}
+		if !reflect.DeepEqual(filter, test.filter) {
+			t.Errorf("%s: expected %#v, got %#v", test.expression, test.filter, filter)
+		}
+	}
+}
+
+func TestParseFilterErrors(t *testing.T) {
+	tests := []struct {
+		expression string
+		position   int
+		message    string
+	}{
+		{"", 1, "expected a field or function"},
+		{"name eq 'open", 9, "unterminated string"},
+		{"name eq \"a\"", 9, "unexpected character"},
+		{"owner eq 'alice'", 1, "owner can't be used in filters"},
+		{"name like 'a'", 6, "unknown operator \"like\""},
+		{"name eq", 8, "expected a value"},
+		{"name eq 1", 9, "invalid value \"1\" for the field type"},
+		{"size eq 'a'", 9, "invalid value"},
+		{"size eq 1.2.3", 9, "invalid number"},
+		{"active eq 1", 11, "invalid value"},
+		{"createdAt gt '2024-01-02'", 14, "expected an RFC 3339 time"},
+		{"size gt null", 13, "null can only be compared with eq or ne"},
+		{"name in ('a', null)", 19, "null can't be used with in"},
+		{"name in ('a' 'b')", 14, "expected \")\""},
+		{"name in ()", 10, "invalid value \")\" for the field type"},
+		{"contains(size, '1')", 14, "contains only works with string fields"},
+		{"contains(name, 1)", 16, "expected a string"},
+		{"(name eq 'a'", 13, "expected \")\""},
+		{"name eq 'a')", 12, "unexpected \")\""},
+		{"name eq 'a' and", 16, "expected a field or function"},
+		{"name eq 'a' name eq 'b'", 13, "unexpected \"name\""},
+	}
+	for _, test := range tests {
+		_, err := ParseFilter(test.expression, testFilterFields)
+		var filterErr *FilterError
+		if !errors.As(err, &filterErr) {
+			t.Errorf("%s: expected a FilterError, got %v", test.expression, err)
+			continue
+		}
+		if filterErr.Position+1 != test.position || !strings.Contains(filterErr.Message, test.message) {
+			t.Errorf("%s: expected %q at %d, got %v", test.expression, test.message, test.position, err)
+		}
+	}
+}
+
+func TestParseFilterDepth(t *testing.T) {
+	nested := func(depth int, open string) string {
+		return strings.Repeat(open, depth) + "size eq 1" + strings.Repeat(")", strings.Count(open, "(")*depth)
+	}
+
+	// not ( opens two levels
+	tests := []struct {
+		open   string
+		levels int
+	}{
+		{"(", FilterMaxDepth},
+		{"not ", FilterMaxDepth},
+		{"not (", FilterMaxDepth / 2},
+	}
+	for _, test := range tests {
+		if _, err := ParseFilter(nested(test.levels, test.open), testFilterFields); err != nil {
+			t.Errorf("%q: expected %d levels to be allowed, got %v", test.open, test.levels, err)
+		}
+		_, err := ParseFilter(nested(test.levels+1, test.open), testFilterFields)
+		if err == nil || !strings.Contains(err.Error(), "nested") {
+			t.Errorf("%q: expected %d levels to fail, got %v", test.open, test.levels+1, err)
+		}
+	}
+
+	// Nesting one after another doesn't add up
+	expression := nested(FilterMaxDepth, "(") + " and " + nested(FilterMaxDepth, "(")
+	if _, err := ParseFilter(expression, testFilterFields); err != nil {
+		t.Errorf("expected sibling expressions to be allowed, got %v", err)
+	}
+}
@@ -0,0 +1,365 @@
+/*
+Copyright (c) 2015, Alberto Corsín Lafuente
+All rights reserved.
+
+Redistribution and use in source and binary forms, with or without
+modification, are permitted provided that the following conditions are met:
+
+1. Redistributions of source code must retain the above copyright notice,
+   this list of conditions and the following disclaimer.
+
+2. Redistributions in binary form must reproduce the above copyright notice,
+   this list of conditions and the following disclaimer in the documentation
+   and/or other materials provided with the distribution.
+
+3. Neither the name of the copyright holder nor the names of its contributors
+   may be used to endorse or promote products derived from this software
+   without specific prior written permission.
+
+THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND
+ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED
+WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE
+FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER
+CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY,
+OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+*/
+package system
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+)
+
+const JSONPatchContentType = "application/json-patch+json"
+
+var (
+	// ErrInvalidPatch is returned for patch documents that aren't valid JSON
+	// Patch, like unknown operations or missing members
+	ErrInvalidPatch = errors.New("Invalid JSON Patch document")
+	// ErrPatchPath is returned when a path doesn't exist in the target or
+	// can't be used with the operation
+	ErrPatchPath = errors.New("Invalid path")
+	// ErrPatchTestFailed is returned when a test operation doesn't match
+	ErrPatchTestFailed = errors.New("Test operation failed")
+)
+
+// JSONPatchError tells which operation of a patch failed and why
+type JSONPatchError struct {
+	Index int
+	Op    string
+	Path  string
+	Err   error
+}
+
+func (e *JSONPatchError) Error() string {
+	return fmt.Sprintf("Operation %d (%s %s): %v", e.Index, e.Op, e.Path, e.Err)
+}
+
+func (e *JSONPatchError) Unwrap() error {
+	return e.Err
+}
+
+type patchOperation struct {
+	Op    string     `json:"op"`
+	Path  *string    `json:"path"`
+	From  *string    `json:"from"`
+	Value patchValue `json:"value"`
+}
+
+// patchValue is the value member of an operation, which may be null, so
+// whether it was given is kept apart from its content
+type patchValue struct {
+	present bool
+	raw     json.RawMessage
+}
+
+func (v *patchValue) UnmarshalJSON(data []byte) error {
+	v.present = true
+	v.raw = append(v.raw[:0], data...)
+	return nil
+}
+
+// ApplyJSONPatch applies a JSON Patch (RFC 6902) to the target document.
+// Operations are applied in order and the whole patch fails if any of them
+// does, errors wrap ErrInvalidPatch, ErrPatchPath or ErrPatchTestFailed.
+func ApplyJSONPatch(target, patch []byte) ([]byte, error) {
+	var document interface{}
+	var operations []patchOperation
+
+	if err := json.Unmarshal(target, &document); err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(patch, &operations); err != nil {
+		return nil, ErrInvalidPatch
+	}
+
+	for index, operation := range operations {
+		var err error
+		if document, err = applyOperation(document, operation); err != nil {
+			path := ""
+			if operation.Path != nil {
+				path = *operation.Path
+			}
+			return nil, &JSONPatchError{Index: index, Op: operation.Op, Path: path, Err: err}
+		}
+	}
+
+	return json.Marshal(document)
+}
+
+func applyOperation(document interface{}, operation patchOperation) (interface{}, error) {
+	if operation.Path == nil {
+		return nil, ErrInvalidPatch
+	}
+	path, err := parsePointer(*operation.Path)
+	if err != nil {
+		return nil, err
+	}
+
+	var value, from interface{}
+	switch operation.Op {
+	case "add", "replace", "test":
+		if !operation.Value.present {
+			return nil, ErrInvalidPatch
+		}
+		if err := json.Unmarshal(operation.Value.raw, &value); err != nil {
+			return nil, ErrInvalidPatch
+		}
+	case "move", "copy":
+		if operation.From == nil {
+			return nil, ErrInvalidPatch
+		}
+		fromPath, err := parsePointer(*operation.From)
+		if err != nil {
+			return nil, err
+		}
+		if from, err = getPointer(document, fromPath); err != nil {
+			return nil, err
+		}
+		if operation.Op == "move" {
+			if isPrefix(fromPath, path) {
+				return nil, ErrPatchPath
+			}
+			if document, err = removePointer(document, fromPath); err != nil {
+				return nil, err
+			}
+		} else {
+			from = deepCopy(from)
+		}
+	case "remove":
+	default:
+		return nil, ErrInvalidPatch
+	}
+
+	switch operation.Op {
+	case "add":
+		return addPointer(document, path, value)
+	case "remove":
+		return removePointer(document, path)
+	case "replace":
+		if _, err := getPointer(document, path); err != nil {
+			return nil, err
+		}
+		if len(path) == 0 {
+			return value, nil
+		}
+		if document, err = removePointer(document, path); err != nil {
+			return nil, err
+		}
+		return addPointer(document, path, value)
+	case "move", "copy":
+		return addPointer(document, path, from)
+	default:
+		current, err := getPointer(document, path)
+		if err != nil {
+			return nil, err
+		}
+		if !reflect.DeepEqual(current, value) {
+			return nil, ErrPatchTestFailed
+		}
+		return document, nil
+	}
+}
+
+// parsePointer splits a JSON Pointer (RFC 6901) into its unescaped tokens
+func parsePointer(pointer string) ([]string, error) {
+	if pointer == "" {
+		return nil, nil
+	}
+	if !strings.HasPrefix(pointer, "/") {
+		return nil, ErrPatchPath
+	}
+
+	tokens := strings.Split(pointer[1:], "/")
+	for i, token := range tokens {
+		tokens[i] = strings.Replace(strings.Replace(token, "~1", "/", -1), "~0", "~", -1)
+	}
+	return tokens, nil
+}
+
+func isPrefix(prefix, path []string) bool {
+	if len(prefix) >= len(path) {
+		return false
+	}
+	for i := range prefix {
+		if prefix[i] != path[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func getPointer(document interface{}, path []string) (interface{}, error) {
+	for _, token := range path {
+		switch container := document.(type) {
+		case map[string]interface{}:
+			value, ok := container[token]
+			if !ok {
+				return nil, ErrPatchPath
+			}
+			document = value
+		case []interface{}:
+			index, err := arrayIndex(token, len(container)-1)
+			if err != nil {
+				return nil, err
+			}
+			document = container[index]
+		default:
+			return nil, ErrPatchPath
+		}
+	}
+	return document, nil
+}
+
+// addPointer adds value at path and returns the resulting document
+func addPointer(document interface{}, path []string, value interface{}) (interface{}, error) {
+	if len(path) == 0 {
+		return value, nil
+	}
+
+	token := path[0]
+	switch container := document.(type) {
+	case map[string]interface{}:
+		if len(path) == 1 {
+			container[token] = value
+			return container, nil
+		}
+		child, ok := container[token]
+		if !ok {
+			return nil, ErrPatchPath
+		}
+		child, err := addPointer(child, path[1:], value)
+		if err != nil {
+			return nil, err
+		}
+		container[token] = child
+		return container, nil
+	case []interface{}:
+		if len(path) == 1 {
+			if token == "-" {
+				return append(container, value), nil
+			}
+			index, err := arrayIndex(token, len(container))
+			if err != nil {
+				return nil, err
+			}
+			container = append(container, nil)
+			copy(container[index+1:], container[index:])
+			container[index] = value
+			return container, nil
+		}
+		index, err := arrayIndex(token, len(container)-1)
+		if err != nil {
+			return nil, err
+		}
+		child, err := addPointer(container[index], path[1:], value)
+		if err != nil {
+			return nil, err
+		}
+		container[index] = child
+		return container, nil
+	default:
+		return nil, ErrPatchPath
+	}
+}
+
+// removePointer removes the value at path and returns the resulting document
+func removePointer(document interface{}, path []string) (interface{}, error) {
+	if len(path) == 0 {
+		return nil, ErrPatchPath
+	}
+
+	token := path[0]
+	switch container := document.(type) {
+	case map[string]interface{}:
+		child, ok := container[token]
+		if !ok {
+			return nil, ErrPatchPath
+		}
+		if len(path) == 1 {
+			delete(container, token)
+			return container, nil
+		}
+		child, err := removePointer(child, path[1:])
+		if err != nil {
+			return nil, err
+		}
+		container[token] = child
+		return container, nil
+	case []interface{}:
+		index, err := arrayIndex(token, len(container)-1)
+		if err != nil {
+			return nil, err
+		}
+		if len(path) == 1 {
+			return append(container[:index], container[index+1:]...), nil
+		}
+		child, err := removePointer(container[index], path[1:])
+		if err != nil {
+			return nil, err
+		}
+		container[index] = child
+		return container, nil
+	default:
+		return nil, ErrPatchPath
+	}
+}
+
+// arrayIndex parses an array index token, which can't be greater than max
+func arrayIndex(token string, max int) (int, error) {
+	if token == "" || (len(token) > 1 && token[0] == '0') || strings.TrimLeft(token, "0123456789") != "" {
+		return 0, ErrPatchPath
+	}
+	index, err := strconv.Atoi(token)
+	if err != nil || index < 0 || index > max {
+		return 0, ErrPatchPath
+	}
+	return index, nil
+}
+
+func deepCopy(value interface{}) interface{} {
+	switch v := value.(type) {
+	case map[string]interface{}:
+		copied := make(map[string]interface{}, len(v))
+		for name, child := range v {
+			copied[name] = deepCopy(child)
+		}
+		return copied
+	case []interface{}:
+		copied := make([]interface{}, len(v))
+		for i, child := range v {
+			copied[i] = deepCopy(child)
+		}
+		return copied
+	default:
+		return v
+	}
+}
@@ -0,0 +1,117 @@
+/*
+Copyright (c) 2015, Alberto Corsín Lafuente
+All rights reserved.
+
+Redistribution and use in source and binary forms, with or without
+modification, are permitted provided that the following conditions are met:
+
+1. Redistributions of source code must retain the above copyright notice,
+   this list of conditions and the following disclaimer.
+
+2. Redistributions in binary form must reproduce the above copyright notice,
+   this list of conditions and the following disclaimer in the documentation
+   and/or other materials provided with the distribution.
+
+3. Neither the name of the copyright holder nor the names of its contributors
+   may be used to endorse or promote products derived from this software
+   without specific prior written permission.
+
+THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND
+ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED
+WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE
+FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER
+CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY,
+OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+*/
+package system
+
+import (
+	"encoding/json"
+	"errors"
+	"reflect"
+	"testing"
+)
+
+func TestApplyJSONPatch(t *testing.T) {
+	target := `{"name": "bolt", "size": 3, "tags": ["a", "b"], "meta": {"color": "red", "note": null}}`
+
+	tests := []struct {
+		name     string
+		patch    string
+		expected string
+		err      error
+	}{
+		{"add member", `[{"op": "add", "path": "/price", "value": 1.5}]`,
+			`{"name": "bolt", "size": 3, "tags": ["a", "b"], "meta": {"color": "red", "note": null}, "price": 1.5}`, nil},
+		{"add null", `[{"op": "add", "path": "/price", "value": null}]`,
+			`{"name": "bolt", "size": 3, "tags": ["a", "b"], "meta": {"color": "red", "note": null}, "price": null}`, nil},
+		{"add into array", `[{"op": "add", "path": "/tags/1", "value": "x"}]`,
+			`{"name": "bolt", "size": 3, "tags": ["a", "x", "b"], "meta": {"color": "red", "note": null}}`, nil},
+		{"add at end of array", `[{"op": "add", "path": "/tags/-", "value": "c"}]`,
+			`{"name": "bolt", "size": 3, "tags": ["a", "b", "c"], "meta": {"color": "red", "note": null}}`, nil},
+		{"add past end of array", `[{"op": "add", "path": "/tags/3", "value": "c"}]`, "", ErrPatchPath},
+		{"add to missing parent", `[{"op": "add", "path": "/missing/x", "value": 1}]`, "", ErrPatchPath},
+		{"add without value", `[{"op": "add", "path": "/price"}]`, "", ErrInvalidPatch},
+		{"remove member", `[{"op": "remove", "path": "/size"}]`,
+			`{"name": "bolt", "tags": ["a", "b"], "meta": {"color": "red", "note": null}}`, nil},
+		{"remove from array", `[{"op": "remove", "path": "/tags/0"}]`,
+			`{"name": "bolt", "size": 3, "tags": ["b"], "meta": {"color": "red", "note": null}}`, nil},
+		{"remove end of array", `[{"op": "remove", "path": "/tags/-"}]`, "", ErrPatchPath},
+		{"remove missing", `[{"op": "remove", "path": "/missing"}]`, "", ErrPatchPath},
+		{"replace member", `[{"op": "replace", "path": "/meta/color", "value": "blue"}]`,
+			`{"name": "bolt", "size": 3, "tags": ["a", "b"], "meta": {"color": "blue", "note": null}}`, nil},
+		{"replace with null", `[{"op": "replace", "path": "/name", "value": null}]`,
+			`{"name": null, "size": 3, "tags": ["a", "b"], "meta": {"color": "red", "note": null}}`, nil},
+		{"replace document", `[{"op": "replace", "path": "", "value": {"name": "nut"}}]`, `{"name": "nut"}`, nil},
+		{"replace missing", `[{"op": "replace", "path": "/missing", "value": 1}]`, "", ErrPatchPath},
+		{"replace bad index", `[{"op": "replace", "path": "/tags/+1", "value": 1}]`, "", ErrPatchPath},
+		{"move member", `[{"op": "move", "from": "/meta/color", "path": "/color"}]`,
+			`{"name": "bolt", "size": 3, "tags": ["a", "b"], "meta": {"note": null}, "color": "red"}`, nil},
+		{"move into itself", `[{"op": "move", "from": "/meta", "path": "/meta/inner"}]`, "", ErrPatchPath},
+		{"copy member", `[{"op": "copy", "from": "/tags/0", "path": "/tags/-"}]`,
+			`{"name": "bolt", "size": 3, "tags": ["a", "b", "a"], "meta": {"color": "red", "note": null}}`, nil},
+		{"copy without from", `[{"op": "copy", "path": "/x"}]`, "", ErrInvalidPatch},
+		{"test", `[{"op": "test", "path": "/tags", "value": ["a", "b"]}, {"op": "remove", "path": "/tags"}]`,
+			`{"name": "bolt", "size": 3, "meta": {"color": "red", "note": null}}`, nil},
+		{"test null", `[{"op": "test", "path": "/meta/note", "value": null}]`, target, nil},
+		{"test failing", `[{"op": "test", "path": "/size", "value": 4}, {"op": "remove", "path": "/size"}]`, "", ErrPatchTestFailed},
+		{"test null failing", `[{"op": "test", "path": "/name", "value": null}]`, "", ErrPatchTestFailed},
+		{"test missing", `[{"op": "test", "path": "/missing", "value": null}]`, "", ErrPatchPath},
+		{"unknown op", `[{"op": "merge", "path": "/name", "value": 1}]`, "", ErrInvalidPatch},
+		{"without path", `[{"op": "remove"}]`, "", ErrInvalidPatch},
+		{"bad pointer", `[{"op": "remove", "path": "name"}]`, "", ErrPatchPath},
+		{"not an array", `{"op": "remove", "path": "/name"}`, "", ErrInvalidPatch},
+	}
+	for _, test := range tests {
+		result, err := ApplyJSONPatch([]byte(target), []byte(test.patch))
+		if test.err != nil {
+			if !errors.Is(err, test.err) {
+				t.Errorf("%s: expected %v, got %v", test.name, test.err, err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: %v", test.name, err)
+			continue
+		}
+
+		var got, expected interface{}
+		json.Unmarshal(result, &got)
+		json.Unmarshal([]byte(test.expected), &expected)
+		if !reflect.DeepEqual(got, expected) {
+			t.Errorf("%s: expected %s, got %s", test.name, test.expected, result)
+		}
+	}
+}
+
+func TestJSONPatchErrorTellsOperation(t *testing.T) {
+	_, err := ApplyJSONPatch([]byte(`{"a": 1}`), []byte(`[{"op": "test", "path": "/a", "value": 1}, {"op": "remove", "path": "/b"}]`))
+	var patchErr *JSONPatchError
+	if !errors.As(err, &patchErr) || patchErr.Index != 1 || patchErr.Op != "remove" || patchErr.Path != "/b" {
+		t.Errorf("unexpected error %v", err)
+	}
+}
@@ -0,0 +1,98 @@
+/*
+Copyright (c) 2015, Alberto Corsín Lafuente
+All rights reserved.
+
+Redistribution and use in source and binary forms, with or without
+modification, are permitted provided that the following conditions are met:
+
+1. Redistributions of source code must retain the above copyright notice,
+   this list of conditions and the following disclaimer.
+
+2. Redistributions in binary form must reproduce the above copyright notice,
+   this list of conditions and the following disclaimer in the documentation
+   and/or other materials provided with the distribution.
+
+3. Neither the name of the copyright holder nor the names of its contributors
+   may be used to endorse or promote products derived from this software
+   without specific prior written permission.
+
+THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND
+ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED
+WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE
+FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER
+CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY,
+OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+*/
+package system
+
+import (
+	"errors"
+	"strings"
+)
+
+var ErrInvalidOrderBy = errors.New("$orderby must be a list of distinct fields, each optionally followed by asc or desc")
+
+// OrderBy is one of the sort keys of an $orderby parameter
+type OrderBy struct {
+	Field string
+	Desc  bool
+}
+
+// OrderByError is returned when $orderby uses a field that can't be sorted
+type OrderByError struct {
+	Field string
+}
+
+func (e *OrderByError) Error() string {
+	return "Can't order by " + e.Field
+}
+
+// ParseOrderBy parses an $orderby parameter such as "createdAt desc,id".
+// Only the sortable fields given are accepted and each can appear once.
+func ParseOrderBy(value string, sortable map[string]bool) ([]OrderBy, error) {
+	var order []OrderBy
+	seen := make(map[string]bool)
+
+	for _, key := range strings.Split(value, ",") {
+		words := strings.Fields(key)
+		if len(words) == 0 || len(words) > 2 {
+			return nil, ErrInvalidOrderBy
+		}
+
+		orderBy := OrderBy{Field: words[0]}
+		if len(words) == 2 {
+			switch strings.ToLower(words[1]) {
+			case "asc":
+			case "desc":
+				orderBy.Desc = true
+			default:
+				return nil, ErrInvalidOrderBy
+			}
+		}
+		if !sortable[orderBy.Field] {
+			return nil, &OrderByError{orderBy.Field}
+		}
+		if seen[orderBy.Field] {
+			return nil, ErrInvalidOrderBy
+		}
+		seen[orderBy.Field] = true
+		order = append(order, orderBy)
+	}
+	return order, nil
+}
+
+// OrderByString formats order back as an $orderby parameter
+func OrderByString(order []OrderBy) string {
+	keys := make([]string, len(order))
+	for i, orderBy := range order {
+		keys[i] = orderBy.Field
+		if orderBy.Desc {
+			keys[i] += " desc"
+		}
+	}
+	return strings.Join(keys, ",")
+}
@@ -0,0 +1,84 @@
+/*
+Copyright (c) 2015, Alberto Corsín Lafuente
+All rights reserved.
+
+Redistribution and use in source and binary forms, with or without
+modification, are permitted provided that the following conditions are met:
+
+1. Redistributions of source code must retain the above copyright notice,
+   this list of conditions and the following disclaimer.
+
+2. Redistributions in binary form must reproduce the above copyright notice,
+   this list of conditions and the following disclaimer in the documentation
+   and/or other materials provided with the distribution.
+
+3. Neither the name of the copyright holder nor the names of its contributors
+   may be used to endorse or promote products derived from this software
+   without specific prior written permission.
+
+THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND
+ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED
+WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE
+FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER
+CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY,
+OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+*/
+package system
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+)
+
+var testSortable = map[string]bool{"id": true, "createdAt": true, "updatedAt": true}
+
+func TestParseOrderBy(t *testing.T) {
+	tests := []struct {
+		value string
+		order []OrderBy
+	}{
+		{"createdAt", []OrderBy{{"createdAt", false}}},
+		{"createdAt desc", []OrderBy{{"createdAt", true}}},
+		{"updatedAt DESC, createdAt asc,id", []OrderBy{{"updatedAt", true}, {"createdAt", false}, {"id", false}}},
+		{"  id   desc ", []OrderBy{{"id", true}}},
+	}
+	for _, test := range tests {
+		order, err := ParseOrderBy(test.value, testSortable)
+		if err != nil {
+			t.Errorf("%q: %v", test.value, err)
+			continue
+		}
+		if !reflect.DeepEqual(order, test.order) {
+			t.Errorf("%q: expected %v, got %v", test.value, test.order, order)
+		}
+		if value := OrderByString(order); value != OrderByString(test.order) {
+			t.Errorf("%q: unexpected string %q", test.value, value)
+		}
+	}
+}
+
+func TestParseOrderByErrors(t *testing.T) {
+	invalid := []string{"", ",", "createdAt,", "createdAt up", "createdAt desc id", "id,id", "id asc,id desc"}
+	for _, value := range invalid {
+		if _, err := ParseOrderBy(value, testSortable); err != ErrInvalidOrderBy {
+			t.Errorf("%q: expected ErrInvalidOrderBy, got %v", value, err)
+		}
+	}
+
+	// Fields outside the allow-list are named in the error, whatever
+	// their case
+	for _, field := range []string{"userId", "href", "CreatedAt", "created_at"} {
+		_, err := ParseOrderBy("id,"+field+" desc", testSortable)
+		var orderByErr *OrderByError
+		if !errors.As(err, &orderByErr) || orderByErr.Field != field {
+			t.Errorf("%s: expected an OrderByError, got %v", field, err)
+		} else if err.Error() != "Can't order by "+field {
+			t.Errorf("%s: unexpected message %q", field, err.Error())
+		}
+	}
+}
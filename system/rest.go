@@ -31,8 +31,13 @@ package system
 
 import (
 	"net/http"
+	"net/url"
+	"strings"
 )
 
+// Relations of the paging links, in the order they are written
+var PagingRelations = []string{"first", "prev", "next", "last"}
+
 func APIReturn(code int, info string, w http.ResponseWriter) {
 	output := make(map[string]map[string]interface{})
 	output["result"] = make(map[string]interface{})
@@ -54,11 +59,48 @@ type APIMultipleOutput struct {
 	Result map[string]interface{}   `json:"result"`
 	Data   []map[string]interface{} `json:"data"`
 	Paging map[string]interface{}   `json:"paging"`
+	// Links maps paging relations (see PagingRelations) to their urls
+	Links map[string]string `json:"-"`
 }
 
+// APIMultipleResults writes a page of results. Paging links are sent in a
+// Link header (RFC 8288) and in the links entry of the paging object.
 func APIMultipleResults(resultCode int, resultInfo string, data APIMultipleOutput, w http.ResponseWriter) {
 	data.Result = make(map[string]interface{})
 	data.Result["code"] = resultCode
 	data.Result["info"] = resultInfo
+
+	if len(data.Links) > 0 {
+		var header []string
+		links := make(map[string]string)
+		for _, rel := range PagingRelations {
+			if link, ok := data.Links[rel]; ok {
+				header = append(header, "<"+link+">; rel=\""+rel+"\"")
+				links[rel] = link
+			}
+		}
+		w.Header().Set("Link", strings.Join(header, ", "))
+		if data.Paging == nil {
+			data.Paging = make(map[string]interface{})
+		}
+		data.Paging["links"] = links
+	}
+
 	WriteJSON(data, resultCode, w)
 }
+
+// PageLink returns the url of the request with the paging parameters
+// replaced by params, keeping every other query parameter
+func PageLink(r *http.Request, params map[string]string) string {
+	query := r.URL.Query()
+	for _, name := range []string{"$offset", "$after", "$before"} {
+		query.Del(name)
+	}
+	for name, value := range params {
+		query.Set(name, value)
+	}
+
+	// $ is valid in a query, keep the parameter names readable
+	link := url.URL{Path: r.URL.Path, RawQuery: strings.Replace(query.Encode(), "%24", "$", -1)}
+	return link.String()
+}
@@ -0,0 +1,95 @@
+/*
+Copyright (c) 2015, Alberto Corsín Lafuente
+All rights reserved.
+
+Redistribution and use in source and binary forms, with or without
+modification, are permitted provided that the following conditions are met:
+
+1. Redistributions of source code must retain the above copyright notice,
+   this list of conditions and the following disclaimer.
+
+2. Redistributions in binary form must reproduce the above copyright notice,
+   this list of conditions and the following disclaimer in the documentation
+   and/or other materials provided with the distribution.
+
+3. Neither the name of the copyright holder nor the names of its contributors
+   may be used to endorse or promote products derived from this software
+   without specific prior written permission.
+
+THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND
+ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED
+WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE
+FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER
+CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY,
+OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+*/
+package system
+
+import (
+	"encoding/json"
+	"net/http/httptest"
+	"net/url"
+	"reflect"
+	"testing"
+)
+
+func TestPageLink(t *testing.T) {
+	r := httptest.NewRequest("GET", "/resources?$filter=id+eq+'a'&$limit=5&$offset=10&$after=x&$before=y", nil)
+
+	link, err := url.Parse(PageLink(r, map[string]string{"$after": "c1"}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if link.Path != "/resources" {
+		t.Errorf("expected the request path, got %s", link.Path)
+	}
+	expected := url.Values{"$filter": {"id eq 'a'"}, "$limit": {"5"}, "$after": {"c1"}}
+	if !reflect.DeepEqual(link.Query(), expected) {
+		t.Errorf("expected %v, got %v", expected, link.Query())
+	}
+
+	// Without params only the paging parameters are removed
+	if first := PageLink(r, nil); first != "/resources?$filter=id+eq+%27a%27&$limit=5" {
+		t.Errorf("unexpected first link %s", first)
+	}
+}
+
+func TestAPIMultipleResultsLinks(t *testing.T) {
+	w := httptest.NewRecorder()
+	APIMultipleResults(200, "OK", APIMultipleOutput{
+		Paging: map[string]interface{}{"limit": 10},
+		Links:  map[string]string{"next": "/r?$after=n", "first": "/r", "last": "/r?$before=l", "prev": "/r?$before=p"},
+	}, w)
+
+	expected := `</r>; rel="first", </r?$before=p>; rel="prev", </r?$after=n>; rel="next", </r?$before=l>; rel="last"`
+	if link := w.Header().Get("Link"); link != expected {
+		t.Errorf("expected Link %s, got %s", expected, link)
+	}
+
+	var output struct {
+		Paging struct {
+			Limit int               `json:"limit"`
+			Links map[string]string `json:"links"`
+		} `json:"paging"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &output); err != nil {
+		t.Fatal(err)
+	}
+	if output.Paging.Limit != 10 || len(output.Paging.Links) != 4 || output.Paging.Links["prev"] != "/r?$before=p" {
+		t.Errorf("unexpected paging %+v", output.Paging)
+	}
+
+	// Without links there is no header nor links entry
+	w = httptest.NewRecorder()
+	APIMultipleResults(200, "OK", APIMultipleOutput{}, w)
+	if _, ok := w.Header()["Link"]; ok {
+		t.Errorf("expected no Link header, got %v", w.Header()["Link"])
+	}
+	if w.Body.String() != `{"result":{"code":200,"info":"OK"},"data":null,"paging":null}` {
+		t.Errorf("unexpected body %s", w.Body.String())
+	}
+}
@@ -0,0 +1,66 @@
+/*
+Copyright (c) 2015, Alberto Corsín Lafuente
+All rights reserved.
+
+Redistribution and use in source and binary forms, with or without
+modification, are permitted provided that the following conditions are met:
+
+1. Redistributions of source code must retain the above copyright notice,
+   this list of conditions and the following disclaimer.
+
+2. Redistributions in binary form must reproduce the above copyright notice,
+   this list of conditions and the following disclaimer in the documentation
+   and/or other materials provided with the distribution.
+
+3. Neither the name of the copyright holder nor the names of its contributors
+   may be used to endorse or promote products derived from this software
+   without specific prior written permission.
+
+THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND
+ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED
+WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE
+FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER
+CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY,
+OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+*/
+package system
+
+import (
+	"context"
+	"net/http"
+)
+
+type userIdKey struct{}
+
+type recordedUserIdKey struct{}
+
+// WithUserId returns a copy of r made on behalf of the user userId, the
+// authentication middleware sets it for every request
+func WithUserId(r *http.Request, userId string) *http.Request {
+	if recorded, ok := r.Context().Value(recordedUserIdKey{}).(*string); ok {
+		*recorded = userId
+	}
+	return r.WithContext(context.WithValue(r.Context(), userIdKey{}, userId))
+}
+
+// RecordUserId returns a copy of r along with a function returning the user
+// given by WithUserId to it or to the requests made from it, so middlewares
+// running before the authentication one can tell who made r once served
+func RecordUserId(r *http.Request) (*http.Request, func() string) {
+	recorded := new(string)
+	r = r.WithContext(context.WithValue(r.Context(), recordedUserIdKey{}, recorded))
+	return r, func() string {
+		return *recorded
+	}
+}
+
+// UserId returns the id of the user making r, handlers scope everything
+// they do to it
+func UserId(r *http.Request) string {
+	userId, _ := r.Context().Value(userIdKey{}).(string)
+	return userId
+}
@@ -31,11 +31,15 @@ package system
 
 import (
 	"code.google.com/p/go-uuid/uuid"
+	"errors"
 	"net/http"
 	"net/url"
+	"strconv"
 	"time"
 )
 
+var ErrInvalidPaging = errors.New("$offset can't be negative and $limit must be greater than zero")
+
 func Error(w http.ResponseWriter, error string, code int) {
 	http.Error(w, error, code)
 }
@@ -57,3 +61,26 @@ func GetQueryParameters(urlString string) (url.Values, error) {
 	m, _ := url.ParseQuery(u.RawQuery)
 	return m, nil
 }
+
+// GetPagingParameters returns the $offset and $limit query parameters, the
+// defaults are used for the missing ones and limit is capped at
+// PagingMaxLimit
+func GetPagingParameters(queryParams url.Values) (int, int, error) {
+	offset, limit := PagingOffset, PagingLimit
+	var err error
+
+	if value := queryParams.Get("$offset"); value != "" {
+		if offset, err = strconv.Atoi(value); err != nil || offset < 0 {
+			return 0, 0, ErrInvalidPaging
+		}
+	}
+	if value := queryParams.Get("$limit"); value != "" {
+		if limit, err = strconv.Atoi(value); err != nil || limit < 1 {
+			return 0, 0, ErrInvalidPaging
+		}
+	}
+	if limit > PagingMaxLimit {
+		limit = PagingMaxLimit
+	}
+	return offset, limit, nil
+}
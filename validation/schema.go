@@ -0,0 +1,620 @@
+/*
+Copyright (c) 2015, Alberto Corsín Lafuente
+All rights reserved.
+
+Redistribution and use in source and binary forms, with or without
+modification, are permitted provided that the following conditions are met:
+
+1. Redistributions of source code must retain the above copyright notice,
+   this list of conditions and the following disclaimer.
+
+2. Redistributions in binary form must reproduce the above copyright notice,
+   this list of conditions and the following disclaimer in the documentation
+   and/or other materials provided with the distribution.
+
+3. Neither the name of the copyright holder nor the names of its contributors
+   may be used to endorse or promote products derived from this software
+   without specific prior written permission.
+
+THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND
+ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED
+WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE
+FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER
+CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY,
+OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+*/
+package validation
+
+import (
+	"bytes"
+	"encoding"
+	"encoding/json"
+	"reflect"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+)
+
+// SchemaVersion is the JSON Schema dialect of the schemas built by SchemaOf
+const SchemaVersion = "https://json-schema.org/draft/2020-12/schema"
+
+// Schema is a JSON Schema describing the JSON form of a type along with the
+// rules of its validate and pattern tags. Custom validators added with
+// Register can't be described, so they are only checked by Validate.
+// Recursive types are described once in $defs and referenced with $ref,
+// anyOf allows null where those references can be null. Like Validate,
+// rules of optional strings and collections are skipped when they are
+// empty, using if and then.
+type Schema struct {
+	Schema               string             `json:"$schema,omitempty"`
+	Title                string             `json:"title,omitempty"`
+	Ref                  string             `json:"$ref,omitempty"`
+	Defs                 map[string]*Schema `json:"$defs,omitempty"`
+	AnyOf                []*Schema          `json:"anyOf,omitempty"`
+	OneOf                []*Schema          `json:"oneOf,omitempty"`
+	If                   *Schema            `json:"if,omitempty"`
+	Then                 *Schema            `json:"then,omitempty"`
+	Type                 interface{}        `json:"type,omitempty"`
+	Format               string             `json:"format,omitempty"`
+	Properties           map[string]*Schema `json:"properties,omitempty"`
+	Required             []string           `json:"required,omitempty"`
+	AdditionalProperties *Schema            `json:"additionalProperties,omitempty"`
+	Items                *Schema            `json:"items,omitempty"`
+	Enum                 []interface{}      `json:"enum,omitempty"`
+	Pattern              string             `json:"pattern,omitempty"`
+	MinLength            *float64           `json:"minLength,omitempty"`
+	MaxLength            *float64           `json:"maxLength,omitempty"`
+	MinItems             *float64           `json:"minItems,omitempty"`
+	MaxItems             *float64           `json:"maxItems,omitempty"`
+	MinProperties        *float64           `json:"minProperties,omitempty"`
+	MaxProperties        *float64           `json:"maxProperties,omitempty"`
+	Minimum              *float64           `json:"minimum,omitempty"`
+	Maximum              *float64           `json:"maximum,omitempty"`
+
+	// order keeps the properties in the order of the struct fields, to
+	// report violations in that order
+	order []string
+}
+
+// schemaFormats maps the formats of the validate tags to their JSON Schema
+// names
+var schemaFormats = map[string]string{
+	"email": "email",
+	"url":   "uri",
+	"uuid":  "uuid",
+}
+
+var (
+	// schemaCache keeps the schema of each type, built once
+	schemaCache sync.Map
+
+	timeType          = reflect.TypeOf(time.Time{})
+	jsonMarshalerType = reflect.TypeOf((*json.Marshaler)(nil)).Elem()
+	textMarshalerType = reflect.TypeOf((*encoding.TextMarshaler)(nil)).Elem()
+)
+
+// SchemaOf returns the JSON Schema of the type of value, usually a struct or
+// a pointer to one. The schema is shared, it must not be modified.
+func SchemaOf(value interface{}) *Schema {
+	t := reflect.TypeOf(value)
+	for t != nil && t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	if cached, ok := schemaCache.Load(t); ok {
+		return cached.(*Schema)
+	}
+
+	schema := &Schema{}
+	if t != nil {
+		b := &schemaBuilder{building: make(map[reflect.Type]bool), recursive: make(map[reflect.Type]bool)}
+		schema = b.typeSchema(t)
+		schema.Title = t.Name()
+		schema.Defs = b.defs
+	}
+	schema.Schema = SchemaVersion
+
+	schemaCache.Store(t, schema)
+	return schema
+}
+
+// schemaBuilder keeps the struct types being described, those found again
+// while describing themselves are moved to defs and referenced
+type schemaBuilder struct {
+	building  map[reflect.Type]bool
+	recursive map[reflect.Type]bool
+	defs      map[string]*Schema
+}
+
+// typeSchema describes values of t
+func (b *schemaBuilder) typeSchema(t reflect.Type) *Schema {
+	nullable := false
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+		nullable = true
+	}
+
+	schema := &Schema{}
+	switch {
+	case t == timeType:
+		schema.Type = "string"
+		schema.Format = "date-time"
+	case t.Implements(jsonMarshalerType) || reflect.PtrTo(t).Implements(jsonMarshalerType):
+		return schema
+	case t.Implements(textMarshalerType) || reflect.PtrTo(t).Implements(textMarshalerType):
+		schema.Type = "string"
+	default:
+		switch t.Kind() {
+		case reflect.String:
+			schema.Type = "string"
+		case reflect.Bool:
+			schema.Type = "boolean"
+		case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+			schema.Type = "integer"
+		case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+			schema.Type = "integer"
+			schema.Minimum = new(float64)
+		case reflect.Float32, reflect.Float64:
+			schema.Type = "number"
+		case reflect.Slice, reflect.Array:
+			if t.Elem().Kind() == reflect.Uint8 {
+				// encoding/json writes byte slices in base64
+				schema.Type = "string"
+				break
+			}
+			schema.Type = "array"
+			schema.Items = b.typeSchema(t.Elem())
+			nullable = nullable || t.Kind() == reflect.Slice
+		case reflect.Map:
+			schema.Type = "object"
+			schema.AdditionalProperties = b.typeSchema(t.Elem())
+			nullable = true
+		case reflect.Struct:
+			if b.building[t] {
+				b.recursive[t] = true
+				schema.Ref = "#/$defs/" + t.Name()
+				break
+			}
+			b.building[t] = true
+			b.structSchema(t, schema)
+			delete(b.building, t)
+			if b.recursive[t] {
+				if b.defs == nil {
+					b.defs = make(map[string]*Schema)
+				}
+				b.defs[t.Name()] = schema
+				schema = &Schema{Ref: "#/$defs/" + t.Name()}
+			}
+		default:
+			return schema
+		}
+	}
+
+	if nullable {
+		if schema.Ref != "" {
+			return &Schema{AnyOf: []*Schema{schema, {Type: "null"}}}
+		}
+		schema.Type = []string{schema.Type.(string), "null"}
+	}
+	return schema
+}
+
+// structSchema fills schema with the properties of the struct type t
+func (b *schemaBuilder) structSchema(t reflect.Type, schema *Schema) {
+	schema.Type = "object"
+	schema.Properties = make(map[string]*Schema)
+	for i := 0; i < t.NumField(); i++ {
+		f, ok := parseField(t, i)
+		if !ok {
+			continue
+		}
+
+		property := b.typeSchema(t.Field(i).Type)
+		if f.required {
+			schema.Required = append(schema.Required, f.name)
+			// Empty values are missing values for Validate
+			switch property.baseType() {
+			case "string":
+				property.MinLength = limit(1)
+			case "array":
+				property.MinItems = limit(1)
+			}
+		}
+		switch kind := t.Field(i).Type.Kind(); {
+		case !f.required && len(f.rules) > 0 && (kind == reflect.String || kind == reflect.Slice || kind == reflect.Map):
+			property.If, property.Then = property.nonEmpty(), &Schema{Type: property.Type}
+			for _, r := range f.rules {
+				property.Then.addRule(t.Field(i).Type, r)
+			}
+			property.Then.Type = nil
+		default:
+			for _, r := range f.rules {
+				property.addRule(t.Field(i).Type, r)
+			}
+		}
+
+		schema.Properties[f.name] = property
+		schema.order = append(schema.order, f.name)
+	}
+}
+
+// addRule adds to s the keywords checking the rule r of a field of type t
+func (s *Schema) addRule(t reflect.Type, r rule) {
+	switch r.name {
+	case "min", "max":
+		value, _ := strconv.ParseFloat(r.argument, 64)
+		var min, max **float64
+		switch s.baseType() {
+		case "string":
+			min, max = &s.MinLength, &s.MaxLength
+		case "array":
+			min, max = &s.MinItems, &s.MaxItems
+		case "object":
+			min, max = &s.MinProperties, &s.MaxProperties
+		case "integer", "number":
+			min, max = &s.Minimum, &s.Maximum
+		default:
+			return
+		}
+		if r.name == "min" {
+			*min = limit(value)
+		} else {
+			*max = limit(value)
+		}
+	case "enum":
+		for t.Kind() == reflect.Ptr {
+			t = t.Elem()
+		}
+		for _, option := range strings.Split(r.argument, "|") {
+			if t.Kind() == reflect.String {
+				s.Enum = append(s.Enum, option)
+			} else if number, err := strconv.ParseFloat(option, 64); err == nil {
+				s.Enum = append(s.Enum, number)
+			}
+		}
+	case "format":
+		s.Format = schemaFormats[r.argument]
+	case "pattern":
+		s.Pattern = r.argument
+	}
+}
+
+// nonEmpty returns the schema matching the values of s that aren't empty
+func (s *Schema) nonEmpty() *Schema {
+	switch s.baseType() {
+	case "string":
+		return &Schema{MinLength: limit(1)}
+	case "array":
+		return &Schema{MinItems: limit(1)}
+	}
+	return &Schema{MinProperties: limit(1)}
+}
+
+func limit(value float64) *float64 {
+	return &value
+}
+
+// baseType returns the type of the values described by s besides null
+func (s *Schema) baseType() string {
+	switch t := s.Type.(type) {
+	case string:
+		return t
+	case []string:
+		return t[0]
+	}
+	return ""
+}
+
+// types returns the JSON types allowed by s, none means any
+func (s *Schema) types() []string {
+	switch t := s.Type.(type) {
+	case string:
+		return []string{t}
+	case []string:
+		return t
+	}
+	return nil
+}
+
+// Validate checks the JSON document against the schema, returning the
+// decoding error if it isn't valid JSON, Errors with every violation found,
+// or nil. Violations use the same field names as the Validate function.
+func (s *Schema) Validate(document []byte) error {
+	var value interface{}
+	if !json.Valid(document) {
+		// json.Unmarshal tells what is wrong with it
+		return json.Unmarshal(document, &value)
+	}
+
+	// Numbers are kept as written to tell integers apart
+	decoder := json.NewDecoder(bytes.NewReader(document))
+	decoder.UseNumber()
+	if err := decoder.Decode(&value); err != nil {
+		return err
+	}
+
+	var errors Errors
+	s.check(s, value, "", &errors)
+	if len(errors) > 0 {
+		return errors
+	}
+	return nil
+}
+
+// check adds to errors the violations of value, found at path, of s. root
+// is the schema the references are resolved in.
+func (s *Schema) check(root *Schema, value interface{}, path string, errors *Errors) {
+	violation := func(message string) {
+		*errors = append(*errors, Violation{path, message})
+	}
+
+	if s.Ref != "" {
+		if target := root.resolve(s.Ref); target != nil {
+			target.check(root, value, path, errors)
+		}
+	}
+	if len(s.AnyOf) > 0 {
+		s.checkAlternatives(root, s.AnyOf, false, value, path, errors)
+	}
+	if len(s.OneOf) > 0 {
+		s.checkAlternatives(root, s.OneOf, true, value, path, errors)
+	}
+	if s.If != nil && s.Then != nil {
+		var found Errors
+		if s.If.check(root, value, path, &found); len(found) == 0 {
+			s.Then.check(root, value, path, errors)
+		}
+	}
+
+	if types := s.types(); len(types) > 0 && !hasType(types, jsonType(value)) {
+		violation("must be " + describeTypes(types))
+		return
+	}
+
+	if len(s.Enum) > 0 && !s.inEnum(value) {
+		options := make([]string, len(s.Enum))
+		for i, option := range s.Enum {
+			options[i] = formatValue(option)
+		}
+		violation("must be one of " + strings.Join(options, ", "))
+	}
+
+	switch v := value.(type) {
+	case string:
+		length := float64(utf8.RuneCountInString(v))
+		if s.MinLength != nil && length < *s.MinLength {
+			violation("must have at least " + formatValue(*s.MinLength) + " characters")
+		}
+		if s.MaxLength != nil && length > *s.MaxLength {
+			violation("must have at most " + formatValue(*s.MaxLength) + " characters")
+		}
+		if s.Pattern != "" && !compiledPattern(s.Pattern).MatchString(v) {
+			violation("must match " + s.Pattern)
+		}
+		if s.Format != "" && !validFormat(s.Format, v) {
+			violation("must be a valid " + formatName(s.Format))
+		}
+	case json.Number:
+		number, _ := v.Float64()
+		if s.Minimum != nil && number < *s.Minimum {
+			violation("must be at least " + formatValue(*s.Minimum))
+		}
+		if s.Maximum != nil && number > *s.Maximum {
+			violation("must be at most " + formatValue(*s.Maximum))
+		}
+	case []interface{}:
+		size := float64(len(v))
+		if s.MinItems != nil && size < *s.MinItems {
+			violation("must have at least " + formatValue(*s.MinItems) + " items")
+		}
+		if s.MaxItems != nil && size > *s.MaxItems {
+			violation("must have at most " + formatValue(*s.MaxItems) + " items")
+		}
+		if s.Items != nil {
+			for i, item := range v {
+				s.Items.check(root, item, path+"["+strconv.Itoa(i)+"]", errors)
+			}
+		}
+	case map[string]interface{}:
+		size := float64(len(v))
+		if s.MinProperties != nil && size < *s.MinProperties {
+			violation("must have at least " + formatValue(*s.MinProperties) + " items")
+		}
+		if s.MaxProperties != nil && size > *s.MaxProperties {
+			violation("must have at most " + formatValue(*s.MaxProperties) + " items")
+		}
+		for _, name := range s.Required {
+			if _, ok := v[name]; !ok {
+				*errors = append(*errors, Violation{join(path, name), "is required"})
+			}
+		}
+		for _, name := range s.order {
+			if property, ok := v[name]; ok {
+				s.Properties[name].check(root, property, join(path, name), errors)
+			}
+		}
+		if s.AdditionalProperties != nil {
+			keys := make([]string, 0, len(v))
+			for key := range v {
+				keys = append(keys, key)
+			}
+			sort.Strings(keys)
+			for _, key := range keys {
+				s.AdditionalProperties.check(root, v[key], join(path, key), errors)
+			}
+		}
+	}
+}
+
+// checkAlternatives checks that value matches one of alternatives, or
+// exactly one if only is set. When none matches, the violations of the
+// first one accepting the type of value are reported.
+func (s *Schema) checkAlternatives(root *Schema, alternatives []*Schema, only bool, value interface{}, path string, errors *Errors) {
+	var types []string
+	var first Errors
+	matched := 0
+	for _, alternative := range alternatives {
+		var found Errors
+		alternative.check(root, value, path, &found)
+		if len(found) == 0 {
+			matched++
+			continue
+		}
+
+		if alternative.Ref != "" {
+			if target := root.resolve(alternative.Ref); target != nil {
+				alternative = target
+			}
+		}
+		allowed := alternative.types()
+		for _, t := range allowed {
+			if !hasType(types, t) {
+				types = append(types, t)
+			}
+		}
+		if first == nil && (len(allowed) == 0 || hasType(allowed, jsonType(value))) {
+			first = found
+		}
+	}
+
+	switch {
+	case matched == 0 && first != nil:
+		*errors = append(*errors, first...)
+	case matched == 0:
+		*errors = append(*errors, Violation{path, "must be " + describeTypes(types)})
+	case only && matched > 1:
+		*errors = append(*errors, Violation{path, "must match only one of its schemas"})
+	}
+}
+
+// resolve returns the schema ref points to, s itself for "#" or one of its
+// $defs, or nil if there is none
+func (s *Schema) resolve(ref string) *Schema {
+	if ref == "#" {
+		return s
+	}
+	if strings.HasPrefix(ref, "#/$defs/") {
+		return s.Defs[strings.TrimPrefix(ref, "#/$defs/")]
+	}
+	return nil
+}
+
+func join(path, name string) string {
+	if path == "" {
+		return name
+	}
+	return path + "." + name
+}
+
+// jsonType returns the JSON Schema type of a value decoded with UseNumber
+func jsonType(value interface{}) string {
+	switch v := value.(type) {
+	case nil:
+		return "null"
+	case bool:
+		return "boolean"
+	case string:
+		return "string"
+	case json.Number:
+		if _, err := v.Int64(); err == nil {
+			return "integer"
+		}
+		if number, err := v.Float64(); err == nil && number == float64(int64(number)) {
+			return "integer"
+		}
+		return "number"
+	case []interface{}:
+		return "array"
+	}
+	return "object"
+}
+
+func hasType(types []string, t string) bool {
+	for _, allowed := range types {
+		if allowed == t || (allowed == "number" && t == "integer") {
+			return true
+		}
+	}
+	return false
+}
+
+// describeTypes returns types as "a string", "an integer or null"...
+func describeTypes(types []string) string {
+	described := make([]string, len(types))
+	for i, t := range types {
+		switch t {
+		case "null":
+			described[i] = t
+		case "integer", "array", "object":
+			described[i] = "an " + t
+		default:
+			described[i] = "a " + t
+		}
+	}
+	return strings.Join(described, " or ")
+}
+
+func (s *Schema) inEnum(value interface{}) bool {
+	for _, option := range s.Enum {
+		switch o := option.(type) {
+		case string:
+			if value == o {
+				return true
+			}
+		case float64:
+			if number, ok := value.(json.Number); ok {
+				if n, err := number.Float64(); err == nil && n == o {
+					return true
+				}
+			}
+		}
+	}
+	return false
+}
+
+func formatValue(value interface{}) string {
+	if number, ok := value.(float64); ok {
+		return strconv.FormatFloat(number, 'f', -1, 64)
+	}
+	return value.(string)
+}
+
+// validFormat checks value against a JSON Schema format
+func validFormat(format, value string) bool {
+	if format == "date-time" {
+		_, err := time.Parse(time.RFC3339, value)
+		return err == nil
+	}
+	check := formats[formatName(format)]
+	return check == nil || check(value)
+}
+
+// formatName returns the validate tag name of a JSON Schema format
+func formatName(format string) string {
+	for name, schemaFormat := range schemaFormats {
+		if schemaFormat == format {
+			return name
+		}
+	}
+	return format
+}
+
+var patternsCache sync.Map
+
+// compiledPattern returns pattern compiled, the patterns of schemas come
+// from pattern tags that were already checked
+func compiledPattern(pattern string) *regexp.Regexp {
+	if cached, ok := patternsCache.Load(pattern); ok {
+		return cached.(*regexp.Regexp)
+	}
+	compiled := regexp.MustCompile(pattern)
+	patternsCache.Store(pattern, compiled)
+	return compiled
+}
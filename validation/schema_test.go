@@ -0,0 +1,259 @@
+/*
+Copyright (c) 2015, Alberto Corsín Lafuente
+All rights reserved.
+
+Redistribution and use in source and binary forms, with or without
+modification, are permitted provided that the following conditions are met:
+
+1. Redistributions of source code must retain the above copyright notice,
+   this list of conditions and the following disclaimer.
+
+2. Redistributions in binary form must reproduce the above copyright notice,
+   this list of conditions and the following disclaimer in the documentation
+   and/or other materials provided with the distribution.
+
+3. Neither the name of the copyright holder nor the names of its contributors
+   may be used to endorse or promote products derived from this software
+   without specific prior written permission.
+
+THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND
+ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED
+WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE
+FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER
+CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY,
+OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+*/
+package validation
+
+import (
+	"encoding/json"
+	"reflect"
+	"sort"
+	"testing"
+	"time"
+)
+
+type node struct {
+	Name     string `json:"name" validate:"required,max=10"`
+	Parent   *node  `json:"parent"`
+	Children []node `json:"children" validate:"max=2"`
+}
+
+type account struct {
+	Email    string            `json:"email" validate:"required,format=email"`
+	Site     string            `json:"site" validate:"format=url"`
+	Id       string            `json:"id" validate:"format=uuid"`
+	Kind     string            `json:"kind" validate:"enum=basic|premium"`
+	Code     string            `json:"code" pattern:"^[A-Z]{3}$"`
+	Nickname *string           `json:"nickname" validate:"min=2"`
+	Tags     []string          `json:"tags" validate:"required,max=3"`
+	Labels   map[string]string `json:"labels" validate:"max=1"`
+	Age      *int              `json:"age" validate:"min=18"`
+	Level    int               `json:"level" validate:"enum=1|2"`
+	Joined   time.Time         `json:"joined"`
+	Owner    *node             `json:"owner"`
+	Friends  []node            `json:"friends"`
+}
+
+// schemaViolations validates document against schema, returning the
+// message of each field
+func schemaViolations(t *testing.T, schema *Schema, document string) map[string]string {
+	found := make(map[string]string)
+	err := schema.Validate([]byte(document))
+	if err == nil {
+		return found
+	}
+	errors, ok := err.(Errors)
+	if !ok {
+		t.Fatalf("%s: expected Errors, got %v", document, err)
+	}
+	for _, violation := range errors {
+		if _, ok := found[violation.Field]; !ok {
+			found[violation.Field] = violation.Message
+		}
+	}
+	return found
+}
+
+func checkViolations(t *testing.T, document string, found, expected map[string]string) {
+	if len(found) != len(expected) {
+		t.Errorf("%s: expected %v, got %v", document, expected, found)
+		return
+	}
+	for field, message := range expected {
+		if found[field] != message {
+			t.Errorf("%s: expected %s %q, got %q", document, field, message, found[field])
+		}
+	}
+}
+
+func TestSchemaOfRecursiveTypes(t *testing.T) {
+	schema := SchemaOf(&node{})
+	if schema.Ref != "#/$defs/node" || schema.Title != "node" || schema.Schema != SchemaVersion {
+		t.Fatalf("expected a reference to the node definition, got %+v", schema)
+	}
+	definition := schema.Defs["node"]
+	if definition == nil || definition.Type != "object" {
+		t.Fatalf("expected node in $defs, got %v", schema.Defs)
+	}
+	parent := definition.Properties["parent"]
+	if len(parent.AnyOf) != 2 || parent.AnyOf[0].Ref != "#/$defs/node" || parent.AnyOf[1].Type != "null" {
+		t.Errorf("expected parent to be a node or null, got %+v", parent)
+	}
+	if children := definition.Properties["children"]; children.Items == nil || children.Items.Ref != "#/$defs/node" {
+		t.Errorf("expected children to be nodes, got %+v", children)
+	}
+
+	// Types that aren't recursive are inlined
+	if schema := SchemaOf(account{}); len(schema.Defs) != 1 || schema.Properties["owner"].AnyOf[0].Ref != "#/$defs/node" {
+		t.Errorf("expected only node in $defs, got %v", schema.Defs)
+	}
+
+	output, err := json.Marshal(SchemaOf(node{}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var published map[string]interface{}
+	if err = json.Unmarshal(output, &published); err != nil {
+		t.Fatal(err)
+	}
+	if published["$ref"] != "#/$defs/node" || published["$defs"] == nil {
+		t.Errorf("expected $ref and $defs to be published, got %s", output)
+	}
+}
+
+func TestSchemaRefs(t *testing.T) {
+	schema := SchemaOf(node{})
+	tests := []struct {
+		document string
+		expected map[string]string
+	}{
+		{`{"name": "root", "parent": null, "children": []}`, nil},
+		{`{"name": "root", "parent": {"name": "up", "parent": {"name": "top"}}}`, nil},
+		{`{"name": "root", "parent": {"name": "up", "parent": {"name": "much too long"}}}`,
+			map[string]string{"parent.parent.name": "must have at most 10 characters"}},
+		{`{"name": "root", "children": [{"name": "a"}, {"children": [{"name": 1}]}]}`,
+			map[string]string{"children[1].name": "is required", "children[1].children[0].name": "must be a string"}},
+		{`{"name": "root", "parent": "up"}`, map[string]string{"parent": "must be an object or null"}},
+		{`{"name": "root", "children": [{"name": "a"}, {"name": "b"}, {"name": "c"}]}`,
+			map[string]string{"children": "must have at most 2 items"}},
+		{`[]`, map[string]string{"": "must be an object"}},
+	}
+	for _, test := range tests {
+		checkViolations(t, test.document, schemaViolations(t, schema, test.document), test.expected)
+	}
+
+	// "#" refers to the root schema
+	list := &Schema{Type: "object", Properties: map[string]*Schema{
+		"value": {Type: "integer"},
+		"next":  {AnyOf: []*Schema{{Ref: "#"}, {Type: "null"}}},
+	}, order: []string{"value", "next"}}
+	document := `{"value": 1, "next": {"value": 2, "next": {"value": "three", "next": null}}}`
+	checkViolations(t, document, schemaViolations(t, list, document), map[string]string{"next.next.value": "must be an integer"})
+}
+
+func TestSchemaAlternatives(t *testing.T) {
+	anyOf := &Schema{AnyOf: []*Schema{{Type: "integer", Minimum: limit(0)}, {Type: "string", Format: "email"}}}
+	oneOf := &Schema{OneOf: []*Schema{{Type: "string", MaxLength: limit(3)}, {Type: "string", Pattern: "^a"}}}
+	tests := []struct {
+		schema   *Schema
+		document string
+		expected map[string]string
+	}{
+		{anyOf, `3`, nil},
+		{anyOf, `"a@example.com"`, nil},
+		{anyOf, `-1`, map[string]string{"": "must be at least 0"}},
+		{anyOf, `"nobody"`, map[string]string{"": "must be a valid email"}},
+		{anyOf, `true`, map[string]string{"": "must be an integer or a string"}},
+		{oneOf, `"xyz"`, nil},
+		{oneOf, `"abcdef"`, nil},
+		{oneOf, `"abc"`, map[string]string{"": "must match only one of its schemas"}},
+		{oneOf, `"xyzxyz"`, map[string]string{"": "must have at most 3 characters"}},
+		{oneOf, `5`, map[string]string{"": "must be a string"}},
+	}
+	for _, test := range tests {
+		checkViolations(t, test.document, schemaViolations(t, test.schema, test.document), test.expected)
+	}
+}
+
+func TestSchemaFormatsAndRequired(t *testing.T) {
+	schema := SchemaOf(account{})
+	if !reflect.DeepEqual(schema.Required, []string{"email", "tags"}) {
+		t.Errorf("expected email and tags to be required, got %v", schema.Required)
+	}
+	formats := map[string]string{"email": "email", "site": "uri", "id": "uuid", "joined": "date-time"}
+	for name, format := range formats {
+		property := schema.Properties[name]
+		if property.Then != nil {
+			property = property.Then
+		}
+		if property.Format != format {
+			t.Errorf("%s: expected format %s, got %q", name, format, property.Format)
+		}
+	}
+
+	valid := `{"email": "a@example.com", "tags": ["x"]}`
+	tests := []struct {
+		document string
+		expected map[string]string
+	}{
+		{valid, nil},
+		{`{"email": "a@example.com", "tags": ["x"], "site": "https://example.com/a", "id": "123e4567-e89b-12d3-a456-426614174000", "joined": "2024-01-02T03:04:05Z"}`, nil},
+		{`{}`, map[string]string{"email": "is required", "tags": "is required"}},
+		{`{"email": "", "tags": []}`, map[string]string{"email": "must have at least 1 characters", "tags": "must have at least 1 items"}},
+		{`{"email": "nobody", "tags": ["x"]}`, map[string]string{"email": "must be a valid email"}},
+		{`{"email": "a@example.com", "tags": ["x"], "site": "example.com"}`, map[string]string{"site": "must be a valid url"}},
+		{`{"email": "a@example.com", "tags": ["x"], "id": "123"}`, map[string]string{"id": "must be a valid uuid"}},
+		{`{"email": "a@example.com", "tags": ["x"], "joined": "yesterday"}`, map[string]string{"joined": "must be a valid date-time"}},
+		// Rules of optional strings and collections skip empty values
+		{`{"email": "a@example.com", "tags": ["x"], "site": "", "id": "", "kind": "", "code": "", "labels": {}}`, nil},
+		{`{"email": "a@example.com", "tags": ["x"], "nickname": ""}`, map[string]string{"nickname": "must have at least 2 characters"}},
+	}
+	for _, test := range tests {
+		checkViolations(t, test.document, schemaViolations(t, schema, test.document), test.expected)
+	}
+}
+
+// The published schema must accept and reject the same documents as the
+// struct tags, for the same fields
+func TestSchemaMatchesTags(t *testing.T) {
+	// Numbers missing from documents are zero for the tags, so they are
+	// always given
+	documents := []string{
+		`{"level": 1, "email": "a@example.com", "tags": ["x"]}`,
+		`{"level": 1, "email": "", "tags": []}`,
+		`{"level": 1, "email": "nobody", "tags": ["a", "b", "c", "d"]}`,
+		`{"level": 1, "email": "a@example.com", "tags": ["x"], "site": "", "id": "", "kind": "", "code": "", "labels": {}}`,
+		`{"level": 1, "email": "a@example.com", "tags": ["x"], "site": "ftp:", "id": "x", "kind": "gold", "code": "ab"}`,
+		`{"level": 1, "email": "a@example.com", "tags": ["x"], "site": "https://example.com", "kind": "basic", "code": "ABC"}`,
+		`{"level": 3, "email": "a@example.com", "tags": ["x"], "nickname": "", "age": 17}`,
+		`{"level": 1, "email": "a@example.com", "tags": ["x"], "nickname": "al", "age": null, "labels": {"a": "1", "b": "2"}}`,
+		`{"level": 1, "email": "a@example.com", "tags": ["x"], "owner": {"name": "", "children": [{"name": "much too long"}]}}`,
+		`{"level": 1, "email": "a@example.com", "tags": ["x"], "friends": [{"name": "a", "parent": {"name": ""}}]}`,
+	}
+
+	schema := SchemaOf(account{})
+	for _, document := range documents {
+		var value account
+		if err := json.Unmarshal([]byte(document), &value); err != nil {
+			t.Fatalf("%s: %v", document, err)
+		}
+
+		var fromTags, fromSchema []string
+		for field := range violations(t, value) {
+			fromTags = append(fromTags, field)
+		}
+		for field := range schemaViolations(t, schema, document) {
+			fromSchema = append(fromSchema, field)
+		}
+		sort.Strings(fromTags)
+		sort.Strings(fromSchema)
+		if !reflect.DeepEqual(fromTags, fromSchema) {
+			t.Errorf("%s: the tags report %v, the schema %v", document, fromTags, fromSchema)
+		}
+	}
+}
@@ -0,0 +1,321 @@
+/*
+Copyright (c) 2015, Alberto Corsín Lafuente
+All rights reserved.
+
+Redistribution and use in source and binary forms, with or without
+modification, are permitted provided that the following conditions are met:
+
+1. Redistributions of source code must retain the above copyright notice,
+   this list of conditions and the following disclaimer.
+
+2. Redistributions in binary form must reproduce the above copyright notice,
+   this list of conditions and the following disclaimer in the documentation
+   and/or other materials provided with the distribution.
+
+3. Neither the name of the copyright holder nor the names of its contributors
+   may be used to endorse or promote products derived from this software
+   without specific prior written permission.
+
+THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND
+ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED
+WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE
+FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER
+CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY,
+OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+*/
+package validation
+
+import (
+	"fmt"
+	"net/mail"
+	"net/url"
+	"reflect"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"unicode/utf8"
+)
+
+// Validate checks the fields of a struct against the rules in their
+// validate tags, separated by commas:
+//
+//	required      the field can't be empty (zero)
+//	min=N, max=N  length of strings, slices and maps, range of numbers
+//	enum=a|b|c    the value must be one of those
+//	format=F      email, url or uuid strings
+//	name          a validator added with Register
+//
+// A pattern tag holds a regular expression strings must match. Rules other
+// than required are skipped for empty strings, collections, pointers and
+// structs, numbers and booleans are always checked. Nested structs, and
+// slices of them, are validated too. Fields are named after their json tag.
+//
+//	type Contact struct {
+//		Name  string `json:"name" validate:"required,max=100"`
+//		Email string `json:"email" validate:"required,format=email"`
+//		Phone string `json:"phone" pattern:"^[0-9 +]+$"`
+//	}
+//
+// Validate returns nil or Errors with every violation found.
+func Validate(value interface{}) error {
+	var errors Errors
+	validate(reflect.ValueOf(value), "", &errors)
+	if len(errors) > 0 {
+		return errors
+	}
+	return nil
+}
+
+// Violation is a field that doesn't follow one of its rules
+type Violation struct {
+	Field   string
+	Message string
+}
+
+// Errors lists the violations found by Validate
+type Errors []Violation
+
+func (e Errors) Error() string {
+	messages := make([]string, len(e))
+	for i, violation := range e {
+		messages[i] = violation.Field + " " + violation.Message
+	}
+	return strings.Join(messages, ", ")
+}
+
+// Validator checks a field value, returning what is wrong with it or ""
+type Validator func(value interface{}) string
+
+var (
+	validatorsMutex sync.RWMutex
+	validators      = make(map[string]Validator)
+)
+
+// Register adds a validator used by the fields with name in their
+// validate tag
+func Register(name string, validator Validator) {
+	validatorsMutex.Lock()
+	defer validatorsMutex.Unlock()
+	validators[name] = validator
+}
+
+type rule struct {
+	name     string
+	argument string
+	pattern  *regexp.Regexp
+}
+
+type field struct {
+	index    int
+	name     string
+	required bool
+	rules    []rule
+}
+
+// fieldsCache keeps the rules of each struct type, parsed once
+var fieldsCache sync.Map
+
+var formats = map[string]func(string) bool{
+	"email": func(value string) bool {
+		address, err := mail.ParseAddress(value)
+		return err == nil && address.Address == value
+	},
+	"url": func(value string) bool {
+		u, err := url.ParseRequestURI(value)
+		return err == nil && u.Scheme != "" && u.Host != ""
+	},
+	"uuid": regexp.MustCompile(`^[0-9a-fA-F]{8}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{12}$`).MatchString,
+}
+
+func validate(v reflect.Value, path string, errors *Errors) {
+	for v.Kind() == reflect.Ptr || v.Kind() == reflect.Interface {
+		if v.IsNil() {
+			return
+		}
+		v = v.Elem()
+	}
+
+	switch v.Kind() {
+	case reflect.Struct:
+		for _, f := range structFields(v.Type()) {
+			value := v.Field(f.index)
+			name := f.name
+			if path != "" {
+				name = path + "." + name
+			}
+			if isEmpty(value) {
+				if f.required {
+					*errors = append(*errors, Violation{name, "is required"})
+					continue
+				}
+				if omittable(value.Kind()) {
+					continue
+				}
+			}
+			for _, r := range f.rules {
+				if message := r.check(reflect.Indirect(value)); message != "" {
+					*errors = append(*errors, Violation{name, message})
+				}
+			}
+			validate(value, name, errors)
+		}
+	case reflect.Slice, reflect.Array:
+		for i := 0; i < v.Len(); i++ {
+			validate(v.Index(i), path+"["+strconv.Itoa(i)+"]", errors)
+		}
+	}
+}
+
+// structFields returns the exported fields of t with their rules, the
+// tags are checked here so invalid ones panic on first use
+func structFields(t reflect.Type) []field {
+	if cached, ok := fieldsCache.Load(t); ok {
+		return cached.([]field)
+	}
+
+	var fields []field
+	for i := 0; i < t.NumField(); i++ {
+		sf := t.Field(i)
+		f, ok := parseField(t, i)
+		if ok && (f.required || len(f.rules) > 0 || isNested(sf.Type)) {
+			fields = append(fields, f)
+		}
+	}
+
+	fieldsCache.Store(t, fields)
+	return fields
+}
+
+// parseField returns the name and rules of the field i of t, or false if
+// it isn't encoded in JSON
+func parseField(t reflect.Type, i int) (field, bool) {
+	sf := t.Field(i)
+	name := strings.Split(sf.Tag.Get("json"), ",")[0]
+	if sf.PkgPath != "" || name == "-" {
+		return field{}, false
+	}
+	if name == "" {
+		name = sf.Name
+	}
+
+	f := field{index: i, name: name}
+	if tag := sf.Tag.Get("validate"); tag != "" {
+		for _, spec := range strings.Split(tag, ",") {
+			parts := strings.SplitN(strings.TrimSpace(spec), "=", 2)
+			r := rule{name: parts[0]}
+			if len(parts) == 2 {
+				r.argument = parts[1]
+			}
+			switch r.name {
+			case "required":
+				f.required = true
+				continue
+			case "min", "max":
+				if _, err := strconv.ParseFloat(r.argument, 64); err != nil {
+					panic(fmt.Sprintf("validation: invalid %s in %s.%s", r.name, t.Name(), sf.Name))
+				}
+			case "format":
+				if formats[r.argument] == nil {
+					panic(fmt.Sprintf("validation: unknown format %q in %s.%s", r.argument, t.Name(), sf.Name))
+				}
+			}
+			f.rules = append(f.rules, r)
+		}
+	}
+	if pattern := sf.Tag.Get("pattern"); pattern != "" {
+		f.rules = append(f.rules, rule{name: "pattern", argument: pattern, pattern: regexp.MustCompile(pattern)})
+	}
+	return f, true
+}
+
+func (r rule) check(v reflect.Value) string {
+	switch r.name {
+	case "min", "max":
+		limit, _ := strconv.ParseFloat(r.argument, 64)
+		size, unit := measure(v)
+		bound := "at least "
+		if r.name == "max" {
+			bound = "at most "
+		}
+		if (r.name == "min" && size < limit) || (r.name == "max" && size > limit) {
+			if unit != "" {
+				return "must have " + bound + r.argument + " " + unit
+			}
+			return "must be " + bound + r.argument
+		}
+	case "enum":
+		value := fmt.Sprint(v.Interface())
+		for _, option := range strings.Split(r.argument, "|") {
+			if value == option {
+				return ""
+			}
+		}
+		return "must be one of " + strings.Replace(r.argument, "|", ", ", -1)
+	case "format":
+		if v.Kind() != reflect.String || !formats[r.argument](v.String()) {
+			return "must be a valid " + r.argument
+		}
+	case "pattern":
+		if v.Kind() != reflect.String || !r.pattern.MatchString(v.String()) {
+			return "must match " + r.argument
+		}
+	default:
+		validatorsMutex.RLock()
+		validator, ok := validators[r.name]
+		validatorsMutex.RUnlock()
+		if !ok {
+			panic("validation: unknown validator " + r.name)
+		}
+		return validator(v.Interface())
+	}
+	return ""
+}
+
+// measure returns the length of strings and collections, along with what
+// it counts, or the value of numbers
+func measure(v reflect.Value) (float64, string) {
+	switch v.Kind() {
+	case reflect.String:
+		return float64(utf8.RuneCountInString(v.String())), "characters"
+	case reflect.Slice, reflect.Array, reflect.Map:
+		return float64(v.Len()), "items"
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return float64(v.Int()), ""
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return float64(v.Uint()), ""
+	case reflect.Float32, reflect.Float64:
+		return v.Float(), ""
+	}
+	return 0, ""
+}
+
+func isEmpty(v reflect.Value) bool {
+	switch v.Kind() {
+	case reflect.Slice, reflect.Map:
+		return v.Len() == 0
+	}
+	return v.IsZero()
+}
+
+// omittable tells whether empty values of kind are taken as left out, so
+// only required applies to them
+func omittable(kind reflect.Kind) bool {
+	switch kind {
+	case reflect.String, reflect.Slice, reflect.Map, reflect.Ptr, reflect.Interface, reflect.Struct:
+		return true
+	}
+	return false
+}
+
+// isNested tells whether values of t can hold structs to validate
+func isNested(t reflect.Type) bool {
+	for t.Kind() == reflect.Ptr || t.Kind() == reflect.Slice || t.Kind() == reflect.Array {
+		t = t.Elem()
+	}
+	return t.Kind() == reflect.Struct
+}
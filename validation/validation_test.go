@@ -0,0 +1,92 @@
+/*
+Copyright (c) 2015, Alberto Corsín Lafuente
+All rights reserved.
+
+Redistribution and use in source and binary forms, with or without
+modification, are permitted provided that the following conditions are met:
+
+1. Redistributions of source code must retain the above copyright notice,
+   this list of conditions and the following disclaimer.
+
+2. Redistributions in binary form must reproduce the above copyright notice,
+   this list of conditions and the following disclaimer in the documentation
+   and/or other materials provided with the distribution.
+
+3. Neither the name of the copyright holder nor the names of its contributors
+   may be used to endorse or promote products derived from this software
+   without specific prior written permission.
+
+THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND
+ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED
+WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE
+FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER
+CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY,
+OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+*/
+package validation
+
+import (
+	"testing"
+)
+
+type item struct {
+	Name     string  `json:"name" validate:"max=5"`
+	Quantity int     `json:"quantity" validate:"min=1,max=10"`
+	Level    int     `json:"level" validate:"enum=1|2|3"`
+	Price    float64 `json:"price" validate:"required,min=0.5"`
+	Discount *int    `json:"discount" validate:"max=50"`
+}
+
+func violations(t *testing.T, value interface{}) map[string]string {
+	err := Validate(value)
+	found := make(map[string]string)
+	if err == nil {
+		return found
+	}
+	errors, ok := err.(Errors)
+	if !ok {
+		t.Fatalf("expected Errors, got %T", err)
+	}
+	for _, violation := range errors {
+		found[violation.Field] = violation.Message
+	}
+	return found
+}
+
+func TestZeroNumbersAreChecked(t *testing.T) {
+	found := violations(t, item{})
+
+	expected := map[string]string{
+		"quantity": "must be at least 1",
+		"level":    "must be one of 1, 2, 3",
+		"price":    "is required",
+	}
+	if len(found) != len(expected) {
+		t.Fatalf("expected %v, got %v", expected, found)
+	}
+	for field, message := range expected {
+		if found[field] != message {
+			t.Errorf("%s: expected %q, got %q", field, message, found[field])
+		}
+	}
+}
+
+func TestValidValues(t *testing.T) {
+	discount := 10
+	found := violations(t, item{Name: "bolt", Quantity: 3, Level: 2, Price: 1, Discount: &discount})
+	if len(found) != 0 {
+		t.Fatalf("expected no violations, got %v", found)
+	}
+}
+
+func TestPointersAreCheckedWhenSet(t *testing.T) {
+	discount := 60
+	found := violations(t, item{Quantity: 1, Level: 1, Price: 1, Discount: &discount})
+	if found["discount"] != "must be at most 50" {
+		t.Fatalf("expected discount to be too big, got %v", found)
+	}
+}